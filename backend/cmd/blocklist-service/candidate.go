@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// CANDIDATE LIST EVALUATION
+// Dry-run a blocklist in a scratch matcher without touching the live blocklist
+// ============================================================================
+
+const (
+	maxCandidateTestDomains = 1000 // Caller-supplied test set limit
+	candidateSourceName     = "candidate"
+)
+
+// CandidateOverlap describes how many candidate entries an existing source already covers
+type CandidateOverlap struct {
+	Source      string  `json:"source"`
+	SharedCount int     `json:"shared_count"`
+	SharedRatio float64 `json:"shared_ratio"` // Shared entries / candidate entries
+	SourceTotal int     `json:"source_total"`
+}
+
+// handleBlocklistEvaluate parses a candidate list and reports its impact without activating it
+// Privacy: Test domains are evaluated in memory and never logged
+func handleBlocklistEvaluate(c *gin.Context) {
+	var request struct {
+		Format        string   `json:"format"`                   // "hosts", "adblock", "domains"
+		Category      string   `json:"category,omitempty"`       // Category the candidate would use
+		Data          string   `json:"data,omitempty"`           // Inline list data
+		URL           string   `json:"url,omitempty"`            // URL to fetch the list from
+		ReplaceSource string   `json:"replace_source,omitempty"` // Existing source the candidate would replace
+		TestDomains   []string `json:"test_domains,omitempty"`   // Domains to evaluate (never logged)
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if (request.Data == "") == (request.URL == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of data or url is required"})
+		return
+	}
+
+	if len(request.TestDomains) > maxCandidateTestDomains {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("test set size %d exceeds limit %d", len(request.TestDomains), maxCandidateTestDomains),
+		})
+		return
+	}
+
+	if request.Category == "" {
+		request.Category = "candidate"
+	}
+
+	start := time.Now()
+
+	// Parse the candidate list
+	var parsed *ParseResult
+	var err error
+	if request.Data != "" {
+		parsed, err = parseBlocklist(strings.NewReader(request.Data), request.Format)
+	} else {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), fetchTimeoutSeconds*time.Second)
+		var fetched *FetchResult
+		fetched, err = fetchBlocklistSource(ctx, BlocklistSource{URL: request.URL, Format: request.Format}, nil)
+		cancel()
+		if err == nil {
+			parsed = fetched.Parsed
+		}
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	parseTime := time.Since(start)
+
+	// Snapshot the live sources and matcher; compiled structures are immutable so they can be read after unlocking
+	mutex.RLock()
+	if blocklistManager == nil {
+		mutex.RUnlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "blocklist manager not initialized"})
+		return
+	}
+	sources := make([]BlocklistSource, len(blocklistManager.sources))
+	copy(sources, blocklistManager.sources)
+	active := currentVersion()
+	live := liveMatcher()
+	mutex.RUnlock()
+
+	activeDomains := map[string][]string{}
+	if active != nil {
+		activeDomains = active.sourceDomains
+	}
+
+	if request.ReplaceSource != "" {
+		found := false
+		for _, source := range sources {
+			found = found || source.Name == request.ReplaceSource
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("source %s not found", request.ReplaceSource)})
+			return
+		}
+	}
+
+	// Overlap with each current source
+	candidateSet := make(map[string]bool, len(parsed.Domains))
+	for _, domain := range parsed.Domains {
+		candidateSet[domain] = true
+	}
+
+	overlaps := make([]CandidateOverlap, 0, len(sources))
+	for _, source := range sources {
+		shared := 0
+		for _, domain := range activeDomains[source.Name] {
+			if candidateSet[domain] {
+				shared++
+			}
+		}
+
+		overlap := CandidateOverlap{
+			Source:      source.Name,
+			SharedCount: shared,
+			SourceTotal: len(activeDomains[source.Name]),
+		}
+		if len(parsed.Domains) > 0 {
+			overlap.SharedRatio = float64(shared) / float64(len(parsed.Domains))
+		}
+		overlaps = append(overlaps, overlap)
+	}
+
+	// Compile the scratch version: the live sources, minus the replaced one, plus the candidate
+	// The candidate comes last so live sources keep their categories for shared entries
+	candidateSource := BlocklistSource{Name: candidateSourceName, Category: request.Category, Enabled: true}
+	scratchSources := []BlocklistSource{}
+	for _, source := range sources {
+		if source.Priority >= candidateSource.Priority {
+			candidateSource.Priority = source.Priority + 1
+		}
+	}
+	scratchDomains := map[string][]string{candidateSourceName: parsed.Domains}
+	scratchPrefixes := map[string][]netip.Prefix{candidateSourceName: parsed.Prefixes}
+	scratchTypeRules := map[string][]DNSTypeRule{candidateSourceName: parsed.TypeRules}
+	for _, source := range sources {
+		if source.Name != request.ReplaceSource {
+			scratchSources = append(scratchSources, source)
+			scratchDomains[source.Name] = activeDomains[source.Name]
+			if active != nil {
+				scratchPrefixes[source.Name] = active.sourcePrefixes[source.Name]
+				scratchTypeRules[source.Name] = active.sourceTypeRules[source.Name]
+			}
+		}
+	}
+	scratch := compileBlocklistVersion(append(scratchSources, candidateSource), scratchDomains, scratchPrefixes, scratchTypeRules)
+
+	// Evaluate the test set on the blocklist stages only (bloom filter, $dnstype rules,
+	// schedules), once per matcher; pauses, temporary allows, safe search and
+	// heuristics are the same for both versions and would hide differences
+	newlyBlocked := []gin.H{}
+	newlyUnblocked := []gin.H{}
+	evaluated := 0
+	for _, raw := range request.TestDomains {
+		domain := normalizeDomain(raw)
+		if !isValidDomain(domain) {
+			continue
+		}
+		evaluated++
+
+		before := evaluateBlocklists(live, domain, "", false)
+		after := evaluateBlocklists(scratch.matcher(), domain, "", false)
+		blockedBefore, categoryBefore := before.Blocked, before.Category
+		blockedAfter, categoryAfter := after.Blocked, after.Category
+
+		switch {
+		case blockedAfter && !blockedBefore:
+			newlyBlocked = append(newlyBlocked, gin.H{"domain": domain, "category": categoryAfter})
+		case blockedBefore && !blockedAfter:
+			newlyUnblocked = append(newlyUnblocked, gin.H{"domain": domain, "category": categoryBefore})
+		}
+	}
+
+	evaluationTime := time.Since(start)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "evaluation_complete",
+		"activated": false,
+		"candidate": gin.H{
+			"format":           request.Format,
+			"category":         request.Category,
+			"entry_count":      len(parsed.Domains),
+			"ip_range_count":   len(parsed.Prefixes),
+			"type_rule_count":  len(parsed.TypeRules),
+			"lines_read":       parsed.LinesRead,
+			"revision":         parsed.Revision,
+			"rejected_count":   parsed.Rejected,
+			"rejected_samples": parsed.RejectedSamples,
+			"skipped_count":    parsed.Unsupported,
+		},
+		"overlap": overlaps,
+		"test_set": gin.H{
+			"evaluated":       evaluated,
+			"newly_blocked":   newlyBlocked,
+			"newly_unblocked": newlyUnblocked,
+			"replace_source":  request.ReplaceSource,
+		},
+		"performance": gin.H{
+			"parse_time":      parseTime.String(),
+			"evaluation_time": evaluationTime.String(),
+		},
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		// Note: Test domains are echoed back to the caller only, never logged
+	})
+
+	log.Printf("🧪 Candidate evaluated: %d entries, %d rejected, %d newly blocked, %d newly unblocked in %v",
+		len(parsed.Domains), parsed.Rejected, len(newlyBlocked), len(newlyUnblocked), evaluationTime)
+}
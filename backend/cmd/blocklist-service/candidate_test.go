@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// installTestBlocklist compiles sources into the live blocklist for the duration of a test
+func installTestBlocklist(t *testing.T, sources []BlocklistSource, domains map[string][]string) {
+	t.Helper()
+	installTestVersion(t, sources, compileBlocklistVersion(sources, domains, map[string][]netip.Prefix{}, map[string][]DNSTypeRule{}))
+}
+
+// installTestVersion activates a compiled version for the duration of a test
+func installTestVersion(t *testing.T, sources []BlocklistSource, version *BlocklistVersion) {
+	t.Helper()
+
+	mutex.Lock()
+	blocklistManager = &BlocklistManager{sources: sources}
+	storeVersion(version)
+	activateVersion(version)
+	mutex.Unlock()
+
+	t.Cleanup(func() {
+		mutex.Lock()
+		blocklistManager = nil
+		mutex.Unlock()
+	})
+}
+
+// evaluateCandidate posts a request to handleBlocklistEvaluate and decodes the test set result
+func evaluateCandidate(t *testing.T, request map[string]interface{}) (newlyBlocked, newlyUnblocked []string, evaluated int) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/evaluate", handleBlocklistEvaluate)
+
+	body, _ := json.Marshal(request)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/evaluate", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		TestSet struct {
+			Evaluated      int                       `json:"evaluated"`
+			NewlyBlocked   []struct{ Domain string } `json:"newly_blocked"`
+			NewlyUnblocked []struct{ Domain string } `json:"newly_unblocked"`
+		} `json:"test_set"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range response.TestSet.NewlyBlocked {
+		newlyBlocked = append(newlyBlocked, entry.Domain)
+	}
+	for _, entry := range response.TestSet.NewlyUnblocked {
+		newlyUnblocked = append(newlyUnblocked, entry.Domain)
+	}
+	return newlyBlocked, newlyUnblocked, response.TestSet.Evaluated
+}
+
+func TestBlocklistEvaluateIgnoresPauseOverrides(t *testing.T) {
+	installTestBlocklist(t,
+		[]BlocklistSource{{Name: "base", Category: "ads", Enabled: true}},
+		map[string][]string{"base": {"ads.example"}})
+
+	// Temporary allows and pauses apply to both versions alike, so they must not
+	// hide what the candidate changes
+	pauseMutex.Lock()
+	temporaryAllows["allowed.example"] = time.Now().Add(time.Hour)
+	pausedCategories["ads"] = time.Now().Add(time.Hour)
+	pauseMutex.Unlock()
+	t.Cleanup(func() {
+		pauseMutex.Lock()
+		delete(temporaryAllows, "allowed.example")
+		delete(pausedCategories, "ads")
+		pauseMutex.Unlock()
+	})
+
+	blocked, unblocked, evaluated := evaluateCandidate(t, map[string]interface{}{
+		"format":       "domains",
+		"data":         "tracker.example\nallowed.example\nads.example\n",
+		"test_domains": []string{"tracker.example", "allowed.example", "ads.example", "clean.example", "not a domain"},
+	})
+	if len(blocked) != 2 || blocked[0] != "tracker.example" || blocked[1] != "allowed.example" {
+		t.Errorf("newly blocked = %v, want [tracker.example allowed.example]", blocked)
+	}
+	if len(unblocked) != 0 {
+		t.Errorf("newly unblocked = %v, want none", unblocked)
+	}
+	if evaluated != 4 {
+		t.Errorf("evaluated = %d, want 4 (invalid domains skipped)", evaluated)
+	}
+}
+
+func TestBlocklistEvaluateReplaceSource(t *testing.T) {
+	installTestBlocklist(t,
+		[]BlocklistSource{
+			{Name: "base", Category: "ads", Enabled: true},
+			{Name: "other", Category: "tracking", Enabled: true},
+		},
+		map[string][]string{"base": {"ads.example", "dropped.example"}, "other": {"shared.example"}})
+
+	blocked, unblocked, _ := evaluateCandidate(t, map[string]interface{}{
+		"format":         "domains",
+		"data":           "ads.example\nnew.example\n",
+		"replace_source": "base",
+		"test_domains":   []string{"ads.example", "dropped.example", "new.example", "shared.example"},
+	})
+	if len(blocked) != 1 || blocked[0] != "new.example" {
+		t.Errorf("newly blocked = %v, want [new.example]", blocked)
+	}
+	if len(unblocked) != 1 || unblocked[0] != "dropped.example" {
+		t.Errorf("newly unblocked = %v, want [dropped.example]", unblocked)
+	}
+}
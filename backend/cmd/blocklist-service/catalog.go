@@ -0,0 +1,261 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// SOURCE CATALOG
+// Curated blocklist sources shipped with the service, subscribable by ID
+// ============================================================================
+
+// defaultCatalog is the catalog file shipped with the service
+//
+//go:embed catalog.json
+var defaultCatalog []byte
+
+// blocklistCatalog holds the loaded catalog entries, read-only after startup
+var blocklistCatalog []CatalogEntry
+
+// CatalogEntry describes a curated blocklist source
+type CatalogEntry struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Format     string `json:"format"`
+	Category   string `json:"category"`
+	License    string `json:"license"`
+	Homepage   string `json:"homepage"`
+	UpdateFreq string `json:"update_freq"`
+}
+
+// loadCatalog reads the catalog from CATALOG_PATH or falls back to the shipped file
+func loadCatalog() error {
+	data := defaultCatalog
+	if path := os.Getenv("CATALOG_PATH"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read catalog %s: %w", path, err)
+		}
+	}
+
+	var catalog struct {
+		Version int            `json:"version"`
+		Entries []CatalogEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return fmt.Errorf("invalid catalog: %w", err)
+	}
+
+	seen := make(map[string]bool, len(catalog.Entries))
+	for _, entry := range catalog.Entries {
+		if entry.ID == "" || entry.URL == "" {
+			return fmt.Errorf("catalog entry %q is missing id or url", entry.Name)
+		}
+		if seen[entry.ID] {
+			return fmt.Errorf("duplicate catalog entry %s", entry.ID)
+		}
+		if !blocklistFormats[entry.Format] {
+			return fmt.Errorf("catalog entry %s has unsupported format %s", entry.ID, entry.Format)
+		}
+		seen[entry.ID] = true
+	}
+
+	blocklistCatalog = catalog.Entries
+	log.Printf("📚 Loaded blocklist catalog: %d entries", len(blocklistCatalog))
+	return nil
+}
+
+// findCatalogEntry returns a catalog entry by ID
+func findCatalogEntry(id string) (CatalogEntry, bool) {
+	for _, entry := range blocklistCatalog {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return CatalogEntry{}, false
+}
+
+// sourceMatchesEntry reports whether a configured source was created from a catalog entry
+// Sources configured by URL before the catalog existed are matched by URL
+func sourceMatchesEntry(source BlocklistSource, entry CatalogEntry) bool {
+	return source.CatalogID == entry.ID || source.URL == entry.URL
+}
+
+// handleCatalogList returns the catalog with subscription state
+// Privacy: Static catalog data only
+func handleCatalogList(c *gin.Context) {
+	category := c.Query("category")
+
+	mutex.RLock()
+	if blocklistManager == nil {
+		mutex.RUnlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "blocklist manager not initialized"})
+		return
+	}
+	sources := make([]BlocklistSource, len(blocklistManager.sources))
+	copy(sources, blocklistManager.sources)
+	mutex.RUnlock()
+
+	entries := []gin.H{}
+	categories := map[string]int{}
+	for _, entry := range blocklistCatalog {
+		categories[entry.Category]++
+		if category != "" && entry.Category != category {
+			continue
+		}
+
+		subscribed := false
+		for _, source := range sources {
+			subscribed = subscribed || sourceMatchesEntry(source, entry)
+		}
+
+		entries = append(entries, gin.H{
+			"id":          entry.ID,
+			"name":        entry.Name,
+			"url":         entry.URL,
+			"format":      entry.Format,
+			"category":    entry.Category,
+			"license":     entry.License,
+			"homepage":    entry.Homepage,
+			"update_freq": entry.UpdateFreq,
+			"subscribed":  subscribed,
+		})
+	}
+
+	categoryNames := make([]string, 0, len(categories))
+	for name := range categories {
+		categoryNames = append(categoryNames, name)
+	}
+	sort.Strings(categoryNames)
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":    entries,
+		"categories": categoryNames,
+		"total":      len(blocklistCatalog),
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleCatalogSubscribe creates a source from a catalog entry and fetches it
+// The parser is selected from the entry's format
+func handleCatalogSubscribe(c *gin.Context) {
+	entry, ok := findCatalogEntry(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "catalog entry not found"})
+		return
+	}
+
+	mutex.Lock()
+	if blocklistManager == nil {
+		mutex.Unlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "blocklist manager not initialized"})
+		return
+	}
+
+	priority := 0
+	for _, source := range blocklistManager.sources {
+		if sourceMatchesEntry(source, entry) {
+			mutex.Unlock()
+			c.JSON(http.StatusConflict, gin.H{"error": "already subscribed", "source": source.Name})
+			return
+		}
+		if source.Name == entry.Name {
+			mutex.Unlock()
+			c.JSON(http.StatusConflict, gin.H{"error": "a source with this name already exists"})
+			return
+		}
+		if source.Priority > priority {
+			priority = source.Priority
+		}
+	}
+
+	source := BlocklistSource{
+		Name:       entry.Name,
+		URL:        entry.URL,
+		Format:     entry.Format,
+		Category:   entry.Category,
+		Enabled:    true,
+		Priority:   priority + 1,
+		UpdateFreq: entry.UpdateFreq,
+		CatalogID:  entry.ID,
+	}
+	blocklistManager.sources = append(blocklistManager.sources, source)
+	blocklistManager.stats.ActiveSources = countEnabledSources(blocklistManager.sources)
+	mutex.Unlock()
+
+	// Fetch the new source in the background; existing sources revalidate conditionally
+	go updateBlocklists("subscribe")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "subscribed",
+		"source": gin.H{
+			"name":     source.Name,
+			"format":   source.Format,
+			"category": source.Category,
+			"priority": source.Priority,
+		},
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+
+	log.Printf("➕ Subscribed to catalog source %s (%s format)", entry.ID, entry.Format)
+}
+
+// handleCatalogUnsubscribe removes the source created from a catalog entry
+// Remaining sources are recompiled from memory in the background without re-fetching
+func handleCatalogUnsubscribe(c *gin.Context) {
+	entry, ok := findCatalogEntry(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "catalog entry not found"})
+		return
+	}
+
+	mutex.Lock()
+	if blocklistManager == nil {
+		mutex.Unlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "blocklist manager not initialized"})
+		return
+	}
+
+	removed := ""
+	remaining := make([]BlocklistSource, 0, len(blocklistManager.sources))
+	for _, source := range blocklistManager.sources {
+		if removed == "" && sourceMatchesEntry(source, entry) {
+			removed = source.Name
+			continue
+		}
+		remaining = append(remaining, source)
+	}
+
+	if removed == "" {
+		mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "not subscribed"})
+		return
+	}
+
+	blocklistManager.sources = remaining
+	blocklistManager.stats.ActiveSources = countEnabledSources(remaining)
+	mutex.Unlock()
+
+	// Recompile in the background; a running update holds updateMutex until it finishes
+	go recompileBlocklists("unsubscribe")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":    "unsubscribed",
+		"source":    removed,
+		"progress":  "/api/v1/blocklist/versions",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+
+	log.Printf("➖ Unsubscribed from catalog source %s", entry.ID)
+}
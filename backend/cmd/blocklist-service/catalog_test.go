@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCatalogUnsubscribeDuringUpdate(t *testing.T) {
+	if err := loadCatalog(); err != nil {
+		t.Fatal(err)
+	}
+	entry := blocklistCatalog[0]
+	installTestBlocklist(t,
+		[]BlocklistSource{
+			{Name: "subscribed", Category: "ads", Enabled: true, CatalogID: entry.ID},
+			{Name: "base", Category: "tracking", Enabled: true},
+		},
+		map[string][]string{"subscribed": {"ads.example"}, "base": {"tracker.example"}})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/catalog/:id/unsubscribe", handleCatalogUnsubscribe)
+	unsubscribe := func() int {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/catalog/"+entry.ID+"/unsubscribe", nil))
+		return recorder.Code
+	}
+
+	// A running update holds updateMutex; the request must not wait for it
+	updateMutex.Lock()
+	codes := make(chan int, 1)
+	go func() { codes <- unsubscribe() }()
+	select {
+	case code := <-codes:
+		if code != http.StatusAccepted {
+			t.Errorf("status = %d, want 202", code)
+		}
+	case <-time.After(2 * time.Second):
+		updateMutex.Unlock()
+		t.Fatal("unsubscribe blocked on the running update")
+	}
+
+	mutex.RLock()
+	stillBlocked := checkDomain("ads.example", "").Blocked
+	mutex.RUnlock()
+	if !stillBlocked {
+		t.Error("entries were dropped before the recompile ran")
+	}
+	updateMutex.Unlock()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mutex.RLock()
+		version := currentVersion()
+		adsBlocked := checkDomain("ads.example", "").Blocked
+		trackerBlocked := checkDomain("tracker.example", "").Blocked
+		mutex.RUnlock()
+		if !adsBlocked {
+			if !trackerBlocked || version.Trigger != "unsubscribe" {
+				t.Errorf("recompiled version %d (%s) lost the remaining source", version.ID, version.Trigger)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("recompile did not run after the update finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := unsubscribe(); code != http.StatusNotFound {
+		t.Errorf("second unsubscribe status = %d, want 404", code)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ============================================================================
+// STREAMING DECOMPRESSION
+// gzip, zstd and brotli decoders chained between the response body and parser
+// ============================================================================
+
+const (
+	maxDecompressedMB = maxMemoryUsageMB / 2 // Zip bomb guard, a single list's text stays within the memory budget
+	maxZstdWindowMB   = 8                    // Caps zstd decoder memory regardless of frame header
+)
+
+// errDecompressedTooLarge is returned once a list inflates past maxDecompressedMB
+var errDecompressedTooLarge = fmt.Errorf("decompressed blocklist exceeds %dMB limit", maxDecompressedMB)
+
+// acceptEncodings is advertised on fetches; the transport's transparent gzip is bypassed
+const acceptEncodings = "gzip, zstd, br"
+
+// encodingFromContentEncoding maps a Content-Encoding header to a decoder name
+func encodingFromContentEncoding(header string) string {
+	switch strings.ToLower(strings.TrimSpace(header)) {
+	case "gzip", "x-gzip":
+		return "gzip"
+	case "zstd":
+		return "zstd"
+	case "br":
+		return "br"
+	default:
+		return ""
+	}
+}
+
+// encodingFromURL maps a list file extension to a decoder name
+func encodingFromURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	switch strings.ToLower(path.Ext(parsed.Path)) {
+	case ".gz", ".gzip":
+		return "gzip"
+	case ".zst", ".zstd":
+		return "zstd"
+	case ".br":
+		return "br"
+	default:
+		return ""
+	}
+}
+
+// decompressBody wraps body with the decoders implied by the Content-Encoding and file extension
+// A file extension matching the declared Content-Encoding is only decoded once
+// transportDecoded reports that the HTTP transport already removed a gzip encoding
+func decompressBody(body io.Reader, contentEncoding, sourceURL string, transportDecoded bool) (io.ReadCloser, error) {
+	var encodings []string
+	if transportDecoded {
+		contentEncoding = ""
+		if encodingFromURL(sourceURL) == "gzip" {
+			sourceURL = "" // The extension names the layer already removed
+		}
+	}
+	if header := strings.TrimSpace(contentEncoding); header != "" && !strings.EqualFold(header, "identity") {
+		transport := encodingFromContentEncoding(header)
+		if transport == "" {
+			return nil, fmt.Errorf("unsupported content encoding: %s", header)
+		}
+		encodings = append(encodings, transport)
+	}
+	if file := encodingFromURL(sourceURL); file != "" && (len(encodings) == 0 || encodings[0] != file) {
+		encodings = append(encodings, file)
+	}
+
+	reader := io.NopCloser(body)
+	closers := []io.Closer{}
+	for _, encoding := range encodings {
+		decoded, err := newDecoder(reader, encoding)
+		if err != nil {
+			for _, closer := range closers {
+				closer.Close()
+			}
+			return nil, err
+		}
+		closers = append(closers, decoded)
+		reader = decoded
+	}
+
+	return &limitedDecompressor{
+		reader:    reader,
+		remaining: maxDecompressedMB * 1024 * 1024,
+		closers:   closers,
+	}, nil
+}
+
+// newDecoder creates a streaming decoder; none of them buffer the whole payload
+func newDecoder(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip":
+		decoder, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		return decoder, nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxWindow(maxZstdWindowMB*1024*1024))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd stream: %w", err)
+		}
+		return decoder.IOReadCloser(), nil
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// limitedDecompressor fails instead of truncating when the output exceeds the limit
+type limitedDecompressor struct {
+	reader    io.Reader
+	remaining int64
+	closers   []io.Closer
+}
+
+func (l *limitedDecompressor) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Probe for one more byte to distinguish an exact fit from an overflow
+		var probe [1]byte
+		n, err := l.reader.Read(probe[:])
+		if n > 0 {
+			return 0, errDecompressedTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+func (l *limitedDecompressor) Close() error {
+	var errs []error
+	for i := len(l.closers) - 1; i >= 0; i-- {
+		errs = append(errs, l.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
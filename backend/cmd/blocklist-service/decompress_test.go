@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const testListBody = "ads.example\ntracker.example\n"
+
+// compressTestBody encodes data with the named encoding
+func compressTestBody(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buf)
+	case "zstd":
+		encoder, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer = encoder
+	case "br":
+		writer = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %s", encoding)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readDecompressed decodes body through decompressBody and returns the text
+func readDecompressed(t *testing.T, body []byte, contentEncoding, sourceURL string, transportDecoded bool) (string, error) {
+	t.Helper()
+
+	reader, err := decompressBody(bytes.NewReader(body), contentEncoding, sourceURL, transportDecoded)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	text, err := io.ReadAll(reader)
+	return string(text), err
+}
+
+func TestDecompressBodyEncodings(t *testing.T) {
+	extensions := map[string]string{"gzip": ".gz", "zstd": ".zst", "br": ".br"}
+	for encoding, extension := range extensions {
+		compressed := compressTestBody(t, encoding, []byte(testListBody))
+
+		// Content-Encoding header on a plain file name
+		if text, err := readDecompressed(t, compressed, encoding, "https://lists.example/hosts.txt", false); err != nil || text != testListBody {
+			t.Errorf("%s by header: %q, %v", encoding, text, err)
+		}
+		// File extension without a header
+		if text, err := readDecompressed(t, compressed, "", "https://lists.example/hosts.txt"+extension+"?v=1", false); err != nil || text != testListBody {
+			t.Errorf("%s by extension: %q, %v", encoding, text, err)
+		}
+		// Both naming the same encoding is a single layer
+		if text, err := readDecompressed(t, compressed, encoding, "https://lists.example/hosts.txt"+extension, false); err != nil || text != testListBody {
+			t.Errorf("%s by header and extension: %q, %v", encoding, text, err)
+		}
+	}
+
+	// Different header and extension are two layers
+	layered := compressTestBody(t, "br", compressTestBody(t, "zstd", []byte(testListBody)))
+	if text, err := readDecompressed(t, layered, "br", "https://lists.example/hosts.txt.zst", false); err != nil || text != testListBody {
+		t.Errorf("br over zstd: %q, %v", text, err)
+	}
+
+	if text, err := readDecompressed(t, []byte(testListBody), "identity", "https://lists.example/hosts.txt", false); err != nil || text != testListBody {
+		t.Errorf("identity: %q, %v", text, err)
+	}
+	if _, err := readDecompressed(t, []byte(testListBody), "compress", "https://lists.example/hosts.txt", false); err == nil {
+		t.Error("unsupported Content-Encoding was accepted")
+	}
+	if _, err := readDecompressed(t, []byte(testListBody), "gzip", "https://lists.example/hosts.txt", false); err == nil {
+		t.Error("plain text declared as gzip was accepted")
+	}
+}
+
+func TestDecompressBodyTransportDecoded(t *testing.T) {
+	// The transport already removed the gzip layer of a .gz file: the body is plain text
+	text, err := readDecompressed(t, []byte(testListBody), "", "https://lists.example/hosts.txt.gz", true)
+	if err != nil || text != testListBody {
+		t.Errorf("transport-decoded .gz: %q, %v", text, err)
+	}
+
+	// Other extensions still name a layer of their own
+	compressed := compressTestBody(t, "zstd", []byte(testListBody))
+	text, err = readDecompressed(t, compressed, "", "https://lists.example/hosts.txt.zst", true)
+	if err != nil || text != testListBody {
+		t.Errorf("transport-decoded .zst: %q, %v", text, err)
+	}
+}
+
+func TestFetchBlocklistSourceDecodesOnce(t *testing.T) {
+	compressed := compressTestBody(t, "gzip", []byte(testListBody))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "zstd") {
+			t.Errorf("Accept-Encoding = %q, want %q", r.Header.Get("Accept-Encoding"), acceptEncodings)
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed)
+	}))
+	t.Cleanup(server.Close)
+
+	source := BlocklistSource{Name: "gz", URL: server.URL + "/hosts.txt.gz", Format: "domains"}
+	result, err := fetchBlocklistSource(context.Background(), source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Parsed.Domains) != 2 {
+		t.Errorf("domains = %v, want 2", result.Parsed.Domains)
+	}
+}
+
+func TestLimitedDecompressor(t *testing.T) {
+	// Output exactly at the limit is accepted
+	exact := &limitedDecompressor{reader: strings.NewReader("12345"), remaining: 5}
+	if data, err := io.ReadAll(exact); err != nil || string(data) != "12345" {
+		t.Errorf("exact fit: %q, %v", data, err)
+	}
+
+	// One byte more fails instead of truncating
+	over := &limitedDecompressor{reader: strings.NewReader("123456"), remaining: 5}
+	if _, err := io.ReadAll(over); !errors.Is(err, errDecompressedTooLarge) {
+		t.Errorf("overflow error = %v, want errDecompressedTooLarge", err)
+	}
+
+	// A highly compressible payload hits the limit while streaming
+	bomb := compressTestBody(t, "gzip", make([]byte, 2*1024*1024))
+	reader, err := decompressBody(bytes.NewReader(bomb), "gzip", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.(*limitedDecompressor).remaining = 1024 * 1024
+	if _, err := io.Copy(io.Discard, reader); !errors.Is(err, errDecompressedTooLarge) {
+		t.Errorf("bomb error = %v, want errDecompressedTooLarge", err)
+	}
+}
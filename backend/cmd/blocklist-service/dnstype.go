@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// QUERY-TYPE AWARE RULES
+// AdGuard $dnstype rules and a global policy per resource record type
+// ============================================================================
+
+const categoryRRTypePolicy = "rrtype-policy"
+
+// dnsTypeNames are the record types accepted in qtype fields, $dnstype and the RR-type policy
+var dnsTypeNames = map[string]bool{
+	"A": true, "AAAA": true, "ANY": true, "CAA": true, "CNAME": true, "DNSKEY": true,
+	"DS": true, "HINFO": true, "HTTPS": true, "MX": true, "NAPTR": true, "NS": true,
+	"PTR": true, "SOA": true, "SRV": true, "SVCB": true, "TXT": true,
+}
+
+// rrTypeActions are the actions the RR-type policy can take
+var rrTypeActions = map[string]bool{
+	"allow":  true,
+	"block":  true, // Blocked answer for every name
+	"refuse": true, // REFUSED, e.g. ANY per RFC 8482
+}
+
+// DNSTypeRule is a blocklist rule that only applies to some query types
+// Example: "||example.org^$dnstype=AAAA|TXT" or "||example.org^$dnstype=~A"
+type DNSTypeRule struct {
+	Domain  string
+	Types   []string // Upper-case record type names
+	Negated bool     // Rule applies to every type except Types
+}
+
+// Matches reports whether the rule applies to a query type
+func (r DNSTypeRule) Matches(qtype string) bool {
+	return containsType(r.Types, qtype) != r.Negated
+}
+
+// Merge combines two rules for the same domain into one matching either rule
+// "AAAA" + "TXT" gives "AAAA|TXT"; with negation, "~A|~MX" + "MX" gives "~A"
+func (r DNSTypeRule) Merge(other DNSTypeRule) DNSTypeRule {
+	merged := DNSTypeRule{Domain: r.Domain}
+	switch {
+	case !r.Negated && !other.Negated:
+		merged.Types = unionTypes(r.Types, other.Types)
+	case r.Negated && other.Negated:
+		merged.Negated = true
+		merged.Types = intersectTypes(r.Types, other.Types)
+	default:
+		negated, plain := r, other
+		if other.Negated {
+			negated, plain = other, r
+		}
+		merged.Negated = true
+		merged.Types = subtractTypes(negated.Types, plain.Types)
+	}
+	return merged
+}
+
+// unionTypes returns the types listed in a or b
+func unionTypes(a, b []string) []string {
+	union := append([]string{}, a...)
+	return append(union, subtractTypes(b, a)...)
+}
+
+// intersectTypes returns the types listed in both a and b
+func intersectTypes(a, b []string) []string {
+	var both []string
+	for _, t := range a {
+		if containsType(b, t) {
+			both = append(both, t)
+		}
+	}
+	return both
+}
+
+// subtractTypes returns the types in a that are not in b
+func subtractTypes(a, b []string) []string {
+	var rest []string
+	for _, t := range a {
+		if !containsType(b, t) {
+			rest = append(rest, t)
+		}
+	}
+	return rest
+}
+
+// containsType reports whether qtype is listed in types
+func containsType(types []string, qtype string) bool {
+	for _, t := range types {
+		if t == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// typedRuleEntry is a compiled $dnstype rule with the category of its source
+type typedRuleEntry struct {
+	rule     DNSTypeRule
+	category string
+}
+
+var (
+	// rrTypePolicy maps a record type to its action; unlisted types are allowed
+	rrTypePolicy = loadRRTypePolicy(os.Getenv("RRTYPE_POLICY"))
+	rrTypeMutex  sync.RWMutex
+)
+
+// loadRRTypePolicy parses "TYPE=action" pairs (comma-separated) on top of the default policy
+// Invalid pairs are skipped so a typo cannot stop the service
+func loadRRTypePolicy(spec string) map[string]string {
+	policy := map[string]string{"ANY": "refuse"}
+	for _, pair := range strings.Split(spec, ",") {
+		qtype, action, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			continue
+		}
+		qtype, ok := normalizeQType(qtype)
+		action = strings.ToLower(strings.TrimSpace(action))
+		if !ok || qtype == "" || !rrTypeActions[action] {
+			continue
+		}
+		policy[qtype] = action
+	}
+	return policy
+}
+
+// normalizeQType upper-cases a record type name; an empty qtype is valid and means "unknown"
+func normalizeQType(qtype string) (string, bool) {
+	qtype = strings.ToUpper(strings.TrimSpace(qtype))
+	if qtype == "" {
+		return "", true
+	}
+	return qtype, dnsTypeNames[qtype]
+}
+
+// parseDNSTypeModifier parses the value of a $dnstype modifier
+// Either all types are negated with "~" or none are, as in AdGuard Home
+func parseDNSTypeModifier(value string) ([]string, bool, error) {
+	var types []string
+	negated := 0
+	for _, part := range strings.Split(value, "|") {
+		if strings.HasPrefix(part, "~") {
+			negated++
+			part = part[1:]
+		}
+		qtype, ok := normalizeQType(part)
+		if !ok || qtype == "" {
+			return nil, false, fmt.Errorf("unknown record type %q", part)
+		}
+		types = append(types, qtype)
+	}
+	if negated != 0 && negated != len(types) {
+		return nil, false, fmt.Errorf("mixed negated and plain record types")
+	}
+	return types, negated > 0, nil
+}
+
+// errUnsupportedModifier marks adblock rules whose modifiers have no DNS equivalent here
+// Dropping the modifier would block more than the rule asks for, so the rule is skipped
+var errUnsupportedModifier = errors.New("unsupported modifier")
+
+// parseAdblockModifiers splits "domain$modifiers" into the domain and an optional $dnstype rule
+// Any modifier other than dnstype ($badfilter, $client, $ctag, $denyallow, $important, ...)
+// returns errUnsupportedModifier
+func parseAdblockModifiers(rule string) (string, *DNSTypeRule, error) {
+	domain, modifiers, found := strings.Cut(rule, "$")
+	if !found {
+		return domain, nil, nil
+	}
+
+	var typeRule *DNSTypeRule
+	for _, modifier := range strings.Split(modifiers, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(modifier), "=")
+		if name != "dnstype" {
+			return "", nil, fmt.Errorf("%w: $%s", errUnsupportedModifier, name)
+		}
+		if typeRule != nil {
+			return "", nil, fmt.Errorf("duplicate $dnstype modifier")
+		}
+		types, negated, err := parseDNSTypeModifier(value)
+		if err != nil {
+			return "", nil, err
+		}
+		typeRule = &DNSTypeRule{Types: types, Negated: negated}
+	}
+
+	return domain, typeRule, nil
+}
+
+// checkRRTypePolicy returns the configured action for a query type
+func checkRRTypePolicy(qtype string) string {
+	if qtype == "" {
+		return "allow"
+	}
+	rrTypeMutex.RLock()
+	defer rrTypeMutex.RUnlock()
+	if action, ok := rrTypePolicy[qtype]; ok {
+		return action
+	}
+	return "allow"
+}
+
+// lookupTypeRules checks $dnstype rules for the domain and each parent domain
+// Every matching rule's category is collected for schedules and pauses
+func lookupTypeRules(matcher blocklistMatcher, domain, qtype string) DomainVerdict {
+	if qtype == "" || len(matcher.typeRules) == 0 {
+		return DomainVerdict{}
+	}
+
+	verdict := DomainVerdict{}
+	for suffix := domain; suffix != ""; {
+		for _, entry := range matcher.typeRules[suffix] {
+			if entry.rule.Matches(qtype) {
+				if !verdict.Blocked {
+					verdict = DomainVerdict{Blocked: true, Category: entry.category, Method: "dnstype"}
+				}
+				verdict.categories = appendCategory(verdict.categories, entry.category)
+			}
+		}
+		dot := strings.IndexByte(suffix, '.')
+		if dot < 0 {
+			break
+		}
+		suffix = suffix[dot+1:]
+	}
+	return verdict
+}
+
+// ============================================================================
+// RR-TYPE POLICY API HANDLERS
+// Global per-record-type actions, system configuration only
+// ============================================================================
+
+// handleRRTypePolicy returns the per-record-type actions
+func handleRRTypePolicy(c *gin.Context) {
+	rrTypeMutex.RLock()
+	policy := make(map[string]string, len(rrTypePolicy))
+	for qtype, action := range rrTypePolicy {
+		policy[qtype] = action
+	}
+	rrTypeMutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"policy":         policy,
+		"default_action": "allow",
+		"record_types":   sortedDNSTypeNames(),
+		"timestamp":      time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleRRTypePolicyUpdate replaces the per-record-type actions
+func handleRRTypePolicyUpdate(c *gin.Context) {
+	var request struct {
+		Policy map[string]string `json:"policy"` // Record type -> allow, block or refuse
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	policy := make(map[string]string, len(request.Policy))
+	for qtype, action := range request.Policy {
+		normalized, ok := normalizeQType(qtype)
+		if !ok || normalized == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown record type %q", qtype)})
+			return
+		}
+		action = strings.ToLower(action)
+		if !rrTypeActions[action] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be allow, block or refuse"})
+			return
+		}
+		if action != "allow" {
+			policy[normalized] = action
+		}
+	}
+
+	rrTypeMutex.Lock()
+	rrTypePolicy = policy
+	rrTypeMutex.Unlock()
+
+	log.Printf("⚙️ RR-type policy updated: %d record types restricted", len(policy))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "updated",
+		"policy":    policy,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// sortedDNSTypeNames lists the supported record types for API clients
+func sortedDNSTypeNames() []string {
+	names := make([]string, 0, len(dnsTypeNames))
+	for name := range dnsTypeNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
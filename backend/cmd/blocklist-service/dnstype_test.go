@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestDNSTypeRuleMerge(t *testing.T) {
+	tests := []struct {
+		a, b    DNSTypeRule
+		match   []string
+		noMatch []string
+	}{
+		{DNSTypeRule{Types: []string{"AAAA"}}, DNSTypeRule{Types: []string{"TXT"}}, []string{"AAAA", "TXT"}, []string{"A", "MX"}},
+		{DNSTypeRule{Types: []string{"A", "MX"}, Negated: true}, DNSTypeRule{Types: []string{"MX"}}, []string{"MX", "TXT"}, []string{"A"}},
+		{DNSTypeRule{Types: []string{"AAAA"}}, DNSTypeRule{Types: []string{"A", "AAAA"}, Negated: true}, []string{"AAAA", "TXT"}, []string{"A"}},
+		{DNSTypeRule{Types: []string{"A", "MX"}, Negated: true}, DNSTypeRule{Types: []string{"A", "TXT"}, Negated: true}, []string{"MX", "TXT", "AAAA"}, []string{"A"}},
+	}
+	for _, test := range tests {
+		merged := test.a.Merge(test.b)
+		for _, qtype := range test.match {
+			if !merged.Matches(qtype) {
+				t.Errorf("%+v merged with %+v does not match %s", test.a, test.b, qtype)
+			}
+		}
+		for _, qtype := range test.noMatch {
+			if merged.Matches(qtype) {
+				t.Errorf("%+v merged with %+v matches %s", test.a, test.b, qtype)
+			}
+		}
+	}
+}
+
+func TestParseBlocklistMergesDNSTypeRules(t *testing.T) {
+	list := strings.Join([]string{
+		"||tracker.example^$dnstype=AAAA",
+		"||tracker.example^$dnstype=TXT",
+		"||other.example^$dnstype=CAA|NAPTR",
+	}, "\n")
+
+	parsed, err := parseBlocklist(strings.NewReader(list), "adblock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.TypeRules) != 2 {
+		t.Fatalf("parsed %d type rules, want 2: %+v", len(parsed.TypeRules), parsed.TypeRules)
+	}
+
+	version := compileBlocklistVersion(
+		[]BlocklistSource{{Name: "typed", Category: "ads", Enabled: true}},
+		map[string][]string{}, map[string][]netip.Prefix{},
+		map[string][]DNSTypeRule{"typed": parsed.TypeRules})
+	matcher := version.matcher()
+
+	for _, test := range []struct {
+		domain, qtype string
+		blocked       bool
+	}{
+		{"tracker.example", "AAAA", true},
+		{"tracker.example", "TXT", true}, // Second rule used to be dropped
+		{"tracker.example", "A", false},
+		{"cdn.tracker.example", "TXT", true},
+		{"other.example", "CAA", true},
+		{"other.example", "NAPTR", true},
+		{"other.example", "DS", false},
+	} {
+		if verdict := lookupTypeRules(matcher, test.domain, test.qtype); verdict.Blocked != test.blocked {
+			t.Errorf("lookupTypeRules(%s, %s) blocked = %v, want %v", test.domain, test.qtype, verdict.Blocked, test.blocked)
+		}
+	}
+}
+
+func TestCompileTypeRulesAcrossSources(t *testing.T) {
+	version := compileBlocklistVersion(
+		[]BlocklistSource{
+			{Name: "first", Category: "ads", Enabled: true, Priority: 1},
+			{Name: "second", Category: "tracking", Enabled: true, Priority: 2},
+		},
+		map[string][]string{}, map[string][]netip.Prefix{},
+		map[string][]DNSTypeRule{
+			"first":  {{Domain: "x.example", Types: []string{"AAAA"}}},
+			"second": {{Domain: "x.example", Types: []string{"AAAA", "TXT"}}},
+		})
+	matcher := version.matcher()
+
+	if verdict := lookupTypeRules(matcher, "x.example", "AAAA"); verdict.Category != "ads" {
+		t.Errorf("AAAA category = %q, want ads from the higher priority source", verdict.Category)
+	}
+	if verdict := lookupTypeRules(matcher, "x.example", "TXT"); !verdict.Blocked || verdict.Category != "tracking" {
+		t.Errorf("TXT verdict = %+v, want blocked as tracking", verdict)
+	}
+	if version.TotalTypeRules != 2 {
+		t.Errorf("TotalTypeRules = %d, want 2", version.TotalTypeRules)
+	}
+}
+
+func TestParseBlocklistSkipsUnsupportedModifiers(t *testing.T) {
+	list := strings.Join([]string{
+		"||ads.example^",
+		"||typed.example^$dnstype=AAAA",
+		"||allowed.example^$badfilter",
+		"||client.example^$client=192.168.1.2",
+		"||tagged.example^$ctag=device_phone",
+		"||wide.example^$denyallow=keep.example",
+		"||important.example^$important",
+		"||mixed.example^$dnstype=A,important",
+		"||broken.example^$dnstype=NOPE",
+		"||twice.example^$dnstype=A,dnstype=AAAA",
+	}, "\n")
+
+	parsed, err := parseBlocklist(strings.NewReader(list), "adblock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Domains) != 1 || parsed.Domains[0] != "ads.example" {
+		t.Errorf("domains = %v, want [ads.example]", parsed.Domains)
+	}
+	if len(parsed.TypeRules) != 1 || parsed.TypeRules[0].Domain != "typed.example" {
+		t.Errorf("type rules = %+v, want typed.example only", parsed.TypeRules)
+	}
+	if parsed.Unsupported != 6 {
+		t.Errorf("unsupported = %d, want 6", parsed.Unsupported)
+	}
+	if parsed.Rejected != 2 {
+		t.Errorf("rejected = %d (%v), want 2", parsed.Rejected, parsed.RejectedSamples)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ============================================================================
+// BLOCKLIST FETCHING
+// Conditional, compressed HTTP downloads streamed straight into the parsers
+// ============================================================================
+
+const (
+	fetchTimeoutSeconds = 60 // Upper bound for a single list download
+)
+
+// blocklistHTTPClient is shared by all fetches for connection reuse
+var blocklistHTTPClient = &http.Client{
+	Timeout: fetchTimeoutSeconds * time.Second,
+}
+
+// FetchResult describes a fetched and parsed blocklist source
+type FetchResult struct {
+	Parsed       *ParseResult // nil when the source was not modified
+	NotModified  bool         // Server answered 304, previous entries are still current
+	ETag         string
+	LastModified string
+}
+
+// fetchBlocklistSource downloads and parses a source
+// previous carries the validators of the last successful fetch for conditional requests
+func fetchBlocklistSource(ctx context.Context, source BlocklistSource, previous *SourceRevision) (*FetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source URL: %w", err)
+	}
+	req.Header.Set("User-Agent", "Shroudinger-Blocklist/1.0")
+	req.Header.Set("Accept-Encoding", acceptEncodings)
+
+	if previous != nil {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+
+	resp, err := blocklistHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	result := &FetchResult{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		result.NotModified = true
+		return result, nil
+	default:
+		return nil, fmt.Errorf("source returned status %d", resp.StatusCode)
+	}
+
+	// Decode while parsing so memory stays flat regardless of list size
+	body, err := decompressBody(resp.Body, resp.Header.Get("Content-Encoding"), source.URL, resp.Uncompressed)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	result.Parsed, err = parseBlocklist(body, source.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
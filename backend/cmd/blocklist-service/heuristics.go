@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/publicsuffix"
+)
+
+// ============================================================================
+// HEURISTIC DOMAIN SCORING
+// Flags likely DGA and DNS tunneling names that no blocklist knows yet
+// Privacy: Domains are scored in memory only and never logged
+// ============================================================================
+
+const (
+	categoryHeuristicDGA    = "heuristic-dga"
+	categoryHeuristicTunnel = "heuristic-tunnel"
+)
+
+// HeuristicsConfig holds the tunable scoring settings
+type HeuristicsConfig struct {
+	Mode            string  `json:"mode"`             // "off", "warn", "block"
+	DGAThreshold    float64 `json:"dga_threshold"`    // Score at or above which a name is flagged as DGA
+	TunnelThreshold float64 `json:"tunnel_threshold"` // Score at or above which a name is flagged as tunneling
+	MinLabelLength  int     `json:"min_label_length"` // Shorter registrable labels are never scored as DGA
+}
+
+// HeuristicResult is the scoring outcome for one domain
+type HeuristicResult struct {
+	Flagged     bool    `json:"flagged"`
+	Category    string  `json:"category,omitempty"`
+	DGAScore    float64 `json:"dga_score"`
+	TunnelScore float64 `json:"tunnel_score"`
+}
+
+var (
+	heuristicsConfig = HeuristicsConfig{
+		Mode:            envOrDefault("HEURISTICS_MODE", "off"),
+		DGAThreshold:    0.65,
+		TunnelThreshold: 0.70,
+		MinLabelLength:  8,
+	}
+	heuristicsMutex sync.RWMutex
+)
+
+// validHeuristicsMode reports whether mode is one of "off", "warn" or "block"
+func validHeuristicsMode(mode string) bool {
+	return mode == "off" || mode == "warn" || mode == "block"
+}
+
+// checkHeuristicsConfig rejects an unknown HEURISTICS_MODE at startup
+// A typo would otherwise score names without ever blocking them
+func checkHeuristicsConfig() error {
+	if mode := currentHeuristicsConfig().Mode; !validHeuristicsMode(mode) {
+		return fmt.Errorf("invalid HEURISTICS_MODE %q: must be off, warn or block", mode)
+	}
+	return nil
+}
+
+// commonBigrams are frequent letter pairs in English and in human-chosen domain names
+// Random (DGA) labels contain few of them
+var commonBigrams = func() map[string]bool {
+	bigrams := map[string]bool{}
+	for _, bigram := range strings.Fields(`
+		th he in er an re on at en nd ti es or te of ed is it al ar st to nt ng
+		se ha as ou io le ve co me de hi ri ro ic ne ea ra ce li ch ll be ma si
+		om ur ca el ta la ns di fo ho pe ec pr no ct us ac ot il tr ly nc et ut
+		ss so rs un lo wa ge ie wh ee wi em ad ol rt po we na ul ni ts mo ow pa
+		im mi ai sh ir su id os iv ia am fi ci vi pl ig tu ev ld ry mp fe bl ab
+		gh ty op wo sa ay ex ke fr oo av ag if ap gr od bo sp rd do uc bu ei ov
+		by rm ep tt oc fa ef cu rn sc gi da yo cr cl du ga qu ue ff ba ey ls va
+		um pp ua up lu go ht ru ug ds lt pi rc rr eg au ck ew mu br bi pt ak pu
+		ui rg ib tl ny ki rk ys ob mm fu ph og ms ye ud mb ip ub oi rl gu dr hr
+		cc tw ft wn nu af hu nn eo vo rv nf xp gn sm fl iz ok nl my gl aw ju oa
+		eq sy sl ps jo lf nv je nk kn gs dy hy ze ks xt bs ik dd cy rp sk`) {
+		bigrams[bigram] = true
+	}
+	return bigrams
+}()
+
+// envOrDefault returns an environment variable or a fallback value
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// clamp01 limits a value to the 0..1 range
+func clamp01(value float64) float64 {
+	return math.Max(0, math.Min(1, value))
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(s))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// isConsonant reports lowercase ASCII consonants and digits (digits break up pronounceable names too)
+func isConsonant(ch byte) bool {
+	if ch >= '0' && ch <= '9' {
+		return true
+	}
+	return ch >= 'a' && ch <= 'z' && !strings.ContainsRune("aeiouy", rune(ch))
+}
+
+// longestConsonantRun returns the longest run of consecutive consonants or digits
+func longestConsonantRun(s string) int {
+	longest, current := 0, 0
+	for i := 0; i < len(s); i++ {
+		if isConsonant(s[i]) {
+			current++
+			if current > longest {
+				longest = current
+			}
+		} else {
+			current = 0
+		}
+	}
+	return longest
+}
+
+// commonBigramRatio returns the share of letter bigrams found in commonBigrams
+func commonBigramRatio(s string) float64 {
+	total, common := 0, 0
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] < 'a' || s[i] > 'z' || s[i+1] < 'a' || s[i+1] > 'z' {
+			continue
+		}
+		total++
+		if commonBigrams[s[i:i+2]] {
+			common++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(common) / float64(total)
+}
+
+// digitRatio returns the share of digits in s
+func digitRatio(s string) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+	digits := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			digits++
+		}
+	}
+	return float64(digits) / float64(len(s))
+}
+
+// scoreDGA scores the registrable label (e.g. "x7gk2qpz" in "x7gk2qpz.com")
+func scoreDGA(label string, minLength int) float64 {
+	if len(label) < minLength {
+		return 0
+	}
+
+	entropy := clamp01((shannonEntropy(label) - 2.5) / 1.5)
+	consonants := clamp01(float64(longestConsonantRun(label)-3) / 3)
+	bigrams := clamp01(1 - commonBigramRatio(label)/0.6)
+	digits := clamp01(digitRatio(label) * 2)
+	length := clamp01(float64(len(label)-10) / 10)
+
+	return 0.3*entropy + 0.2*consonants + 0.3*bigrams + 0.1*digits + 0.1*length
+}
+
+// scoreTunnel scores the subdomain part for data encoded into query names
+func scoreTunnel(subdomain string, labels []string) float64 {
+	if subdomain == "" {
+		return 0
+	}
+
+	longestLabel := 0
+	for _, label := range labels {
+		if len(label) > longestLabel {
+			longestLabel = len(label)
+		}
+	}
+
+	length := clamp01(float64(len(subdomain)-20) / 40)
+	longest := clamp01(float64(longestLabel-15) / 25)
+	depth := clamp01(float64(len(labels)-3) / 5)
+	entropy := clamp01((shannonEntropy(strings.ReplaceAll(subdomain, ".", "")) - 2.5) / 1.5)
+
+	return 0.3*length + 0.25*longest + 0.15*depth + 0.3*entropy
+}
+
+// scoreDomain computes DGA and tunneling scores for a normalized domain
+func scoreDomain(domain string, config HeuristicsConfig) HeuristicResult {
+	result := HeuristicResult{}
+
+	// The registrable label sits left of the public suffix ("example" in "a.example.co.uk")
+	site, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return result // A bare public suffix has nothing registrable to score
+	}
+	registrable, _, _ := strings.Cut(site, ".")
+
+	var subdomainLabels []string
+	if subdomain := strings.TrimSuffix(domain, "."+site); subdomain != domain {
+		subdomainLabels = strings.Split(subdomain, ".")
+	}
+
+	result.DGAScore = scoreDGA(registrable, config.MinLabelLength)
+	result.TunnelScore = scoreTunnel(strings.Join(subdomainLabels, "."), subdomainLabels)
+
+	switch {
+	case result.TunnelScore >= config.TunnelThreshold:
+		result.Flagged = true
+		result.Category = categoryHeuristicTunnel
+	case result.DGAScore >= config.DGAThreshold:
+		result.Flagged = true
+		result.Category = categoryHeuristicDGA
+	}
+
+	return result
+}
+
+// currentHeuristicsConfig returns a snapshot of the scoring settings
+func currentHeuristicsConfig() HeuristicsConfig {
+	heuristicsMutex.RLock()
+	defer heuristicsMutex.RUnlock()
+	return heuristicsConfig
+}
+
+// ============================================================================
+// HEURISTICS API HANDLERS
+// Configuration and ad-hoc scoring for threshold tuning
+// ============================================================================
+
+// handleHeuristicsConfig returns the current scoring settings
+func handleHeuristicsConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"config":    currentHeuristicsConfig(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleHeuristicsConfigUpdate changes mode and thresholds at runtime
+func handleHeuristicsConfigUpdate(c *gin.Context) {
+	config := currentHeuristicsConfig()
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if !validHeuristicsMode(config.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be off, warn or block"})
+		return
+	}
+	if config.DGAThreshold <= 0 || config.DGAThreshold > 1 || config.TunnelThreshold <= 0 || config.TunnelThreshold > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "thresholds must be in (0, 1]"})
+		return
+	}
+	if config.MinLabelLength < 1 || config.MinLabelLength > maxLabelLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid min_label_length"})
+		return
+	}
+
+	heuristicsMutex.Lock()
+	heuristicsConfig = config
+	heuristicsMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "updated",
+		"config":    config,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleHeuristicsScore scores a domain without consulting the blocklist
+// Privacy: The domain is scored in memory and never logged
+func handleHeuristicsScore(c *gin.Context) {
+	var request struct {
+		Domain string `json:"domain"` // Domain to score (never logged)
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	domain := normalizeDomain(request.Domain)
+	if !isValidDomain(domain) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid domain format"})
+		return
+	}
+
+	config := currentHeuristicsConfig()
+	result := scoreDomain(domain, config)
+
+	c.JSON(http.StatusOK, gin.H{
+		"result":    result,
+		"config":    config,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestScoreDomainRegistrableLabel(t *testing.T) {
+	config := HeuristicsConfig{Mode: "block", DGAThreshold: 0.65, TunnelThreshold: 0.70, MinLabelLength: 8}
+
+	// The same random label must score the same under one- and two-part suffixes
+	random := scoreDomain("x7gk2qpzvw9t.com", config)
+	if !random.Flagged || random.Category != categoryHeuristicDGA {
+		t.Fatalf("x7gk2qpzvw9t.com = %+v, want flagged as DGA", random)
+	}
+	for _, domain := range []string{"x7gk2qpzvw9t.co.uk", "www.x7gk2qpzvw9t.com.au"} {
+		if result := scoreDomain(domain, config); result.DGAScore != random.DGAScore {
+			t.Errorf("%s DGA score = %.3f, want %.3f", domain, result.DGAScore, random.DGAScore)
+		}
+	}
+
+	// "co" is part of the suffix, not a short registrable label hiding the real one
+	if result := scoreDomain("bbc.co.uk", config); result.Flagged || result.DGAScore != 0 {
+		t.Errorf("bbc.co.uk = %+v, want unflagged", result)
+	}
+
+	// A bare public suffix has nothing to score
+	if result := scoreDomain("co.uk", config); result != (HeuristicResult{}) {
+		t.Errorf("co.uk = %+v, want zero result", result)
+	}
+
+	// Tunneling scores only the part left of the registrable domain
+	tunnel := scoreDomain("aGVsbG8gd29ybGQgdGhpcyBpcyBh.ZXhmaWx0cmF0ZWQgZGF0YQ.q1.q2.q3.example.co.uk", config)
+	if !tunnel.Flagged || tunnel.Category != categoryHeuristicTunnel {
+		t.Errorf("tunnel name = %+v, want flagged as tunneling", tunnel)
+	}
+}
+
+func TestCheckHeuristicsConfig(t *testing.T) {
+	previous := currentHeuristicsConfig()
+	t.Cleanup(func() {
+		heuristicsMutex.Lock()
+		heuristicsConfig = previous
+		heuristicsMutex.Unlock()
+	})
+
+	for mode, valid := range map[string]bool{"off": true, "warn": true, "block": true, "blcok": false, "on": false} {
+		heuristicsMutex.Lock()
+		heuristicsConfig.Mode = mode
+		heuristicsMutex.Unlock()
+
+		if err := checkHeuristicsConfig(); (err == nil) != valid {
+			t.Errorf("checkHeuristicsConfig with mode %q = %v, want valid %v", mode, err, valid)
+		}
+	}
+}
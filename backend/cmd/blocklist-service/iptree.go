@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/netip"
+)
+
+// ============================================================================
+// IP PREFIX TREE
+// Binary radix tree for CIDR blocklists, O(address bits) lookups
+// ============================================================================
+
+// IPPrefixTree stores blocked IPv4 and IPv6 prefixes in separate bit tries
+type IPPrefixTree struct {
+	v4       *ipTrieNode
+	v6       *ipTrieNode
+	prefixes int
+}
+
+// ipTrieNode is one bit position in the tree
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	blocked  bool
+	category string
+	prefix   netip.Prefix
+}
+
+// NewIPPrefixTree creates an empty prefix tree
+func NewIPPrefixTree() *IPPrefixTree {
+	return &IPPrefixTree{
+		v4: &ipTrieNode{},
+		v6: &ipTrieNode{},
+	}
+}
+
+// unmapPrefix rewrites an IPv4-mapped prefix (e.g. ::ffff:10.0.0.0/104) as the
+// IPv4 prefix it covers, since Check looks up mapped addresses in the v4 tree
+// Prefixes broader than ::ffff:0:0/96 are left alone as IPv6
+func unmapPrefix(prefix netip.Prefix) netip.Prefix {
+	if !prefix.Addr().Is4In6() || prefix.Bits() < 96 {
+		return prefix.Masked()
+	}
+	return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96).Masked()
+}
+
+// Add inserts a prefix; single addresses are stored as /32 or /128
+// The first category added for a prefix wins, matching domain priority handling
+func (t *IPPrefixTree) Add(prefix netip.Prefix, category string) {
+	prefix = unmapPrefix(prefix)
+	addr := prefix.Addr()
+
+	current := t.v6
+	if addr.Is4() {
+		current = t.v4
+	}
+
+	bytes := addr.AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := (bytes[i/8] >> (7 - uint(i%8))) & 1
+		if current.children[bit] == nil {
+			current.children[bit] = &ipTrieNode{}
+		}
+		current = current.children[bit]
+	}
+
+	if current.blocked {
+		return // Already added by a higher priority source
+	}
+	t.prefixes++
+	current.blocked = true
+	current.category = category
+	current.prefix = prefix
+}
+
+// Check returns the most specific blocked prefix containing addr
+// IPv4-mapped addresses are checked as IPv4, then against broad IPv6 prefixes
+func (t *IPPrefixTree) Check(addr netip.Addr) (bool, string, netip.Prefix) {
+	if addr.Is4In6() {
+		if blocked, category, prefix := t.check(addr.Unmap()); blocked {
+			return blocked, category, prefix
+		}
+	}
+	return t.check(addr)
+}
+
+// check walks the tree of the address family of addr
+func (t *IPPrefixTree) check(addr netip.Addr) (bool, string, netip.Prefix) {
+	current := t.v6
+	bits := 128
+	if addr.Is4() {
+		current = t.v4
+		bits = 32
+	}
+
+	var match *ipTrieNode
+	if current.blocked {
+		match = current
+	}
+
+	bytes := addr.AsSlice()
+	for i := 0; i < bits && current != nil; i++ {
+		bit := (bytes[i/8] >> (7 - uint(i%8))) & 1
+		current = current.children[bit]
+		if current != nil && current.blocked {
+			match = current
+		}
+	}
+
+	if match == nil {
+		return false, "", netip.Prefix{}
+	}
+	return true, match.category, match.prefix
+}
+
+// Len returns the number of distinct prefixes stored
+func (t *IPPrefixTree) Len() int {
+	return t.prefixes
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIPPrefixTreeCheck(t *testing.T) {
+	tree := NewIPPrefixTree()
+	for _, rule := range []string{"10.0.0.0/8", "10.1.0.0/16", "2001:db8::/32", "::ffff:192.168.0.0/112", "::/80"} {
+		prefix, ok := parseIPPrefix(rule)
+		if !ok {
+			t.Fatalf("parseIPPrefix(%q) rejected", rule)
+		}
+		tree.Add(prefix, rule)
+	}
+
+	tests := []struct {
+		addr    string
+		blocked bool
+		prefix  string
+	}{
+		{"10.2.3.4", true, "10.0.0.0/8"},
+		{"10.1.3.4", true, "10.1.0.0/16"}, // Most specific prefix wins
+		{"::ffff:10.1.3.4", true, "10.1.0.0/16"},
+		{"192.168.7.7", true, "192.168.0.0/16"}, // Mapped rule stored as IPv4
+		{"::ffff:192.168.7.7", true, "192.168.0.0/16"},
+		{"::ffff:8.8.8.8", true, "::/80"}, // Broad IPv6 prefix covering the mapped range
+		{"8.8.8.8", false, ""},
+		{"2001:db8::1", true, "2001:db8::/32"},
+		{"2001:db9::1", false, ""},
+	}
+	for _, test := range tests {
+		blocked, _, prefix := tree.Check(netip.MustParseAddr(test.addr))
+		if blocked != test.blocked {
+			t.Errorf("Check(%s) blocked = %v, want %v", test.addr, blocked, test.blocked)
+			continue
+		}
+		if blocked && prefix.String() != test.prefix {
+			t.Errorf("Check(%s) prefix = %s, want %s", test.addr, prefix, test.prefix)
+		}
+	}
+}
+
+func TestParseIPPrefixMapped(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8", true},
+		{"::ffff:10.0.0.1", "10.0.0.1/32", true},
+		{"::ffff:0.0.0.0/100", "", false}, // Unmaps to a /4, broader than minIPv4PrefixBits
+		{"10.0.0.0/4", "", false},
+	}
+	for _, test := range tests {
+		prefix, ok := parseIPPrefix(test.value)
+		if ok != test.ok || ok && prefix.String() != test.want {
+			t.Errorf("parseIPPrefix(%q) = %s, %v; want %s, %v", test.value, prefix, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestLookupBlocklistParents(t *testing.T) {
+	version := compileBlocklistVersion(
+		[]BlocklistSource{{Name: "base", Category: "tracking", Enabled: true}},
+		map[string][]string{"base": {"tracker.example"}},
+		map[string][]netip.Prefix{}, map[string][]DNSTypeRule{})
+	matcher := version.matcher()
+
+	tests := []struct {
+		domain  string
+		parents bool
+		blocked bool
+		method  string
+	}{
+		{"tracker.example", false, true, "hash_table"},
+		{"tracker.example", true, true, "hash_table"},
+		// Plain lookups only probe the bloom filter for the name itself
+		{"cdn.tracker.example", false, false, "bloom_filter"},
+		// CNAME target checks also probe parents and reach the trie
+		{"cdn.tracker.example", true, true, "trie"},
+		{"clean.example", true, false, "bloom_filter"},
+	}
+	for _, test := range tests {
+		verdict := lookupBlocklist(matcher, test.domain, test.parents)
+		if verdict.Blocked != test.blocked || verdict.Method != test.method {
+			t.Errorf("lookupBlocklist(%s, parents=%v) = %v via %s, want %v via %s",
+				test.domain, test.parents, verdict.Blocked, verdict.Method, test.blocked, test.method)
+		}
+	}
+}
@@ -4,7 +4,7 @@
 //
 // Core responsibilities:
 // 1. Fetch and parse blocklists from multiple sources
-// 2. Optimize data structures (Trie, Bloom filters, Hash tables)
+// 2. Optimize data structures (Trie, Bloom filters, Hash tables, IP radix tree)
 // 3. Provide microsecond domain lookup performance
 // 4. Maintain privacy by never storing user queries
 package main
@@ -15,6 +15,7 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"strings"
@@ -84,19 +85,56 @@ func main() {
 		// Data management
 		api.POST("/blocklist/fetch", handleBlocklistFetch)		// Fetch from sources
 		api.POST("/blocklist/parse", handleBlocklistParse)		// Parse formats
+		api.POST("/blocklist/evaluate", handleBlocklistEvaluate)	// Dry-run a candidate list
 		api.POST("/blocklist/optimize", handleBlocklistOptimize)	// Optimize structures
 		api.POST("/blocklist/reload", handleBlocklistReload)		// Reload all data
 		
+		// Version history
+		api.GET("/blocklist/versions", handleBlocklistVersions)	// List compiled versions
+		api.POST("/blocklist/rollback", handleBlocklistRollback)	// Activate a stored version
+		
 		// Query endpoints (high-performance)
 		api.POST("/blocklist/check", handleDomainCheck)		// Check if domain blocked
 		api.POST("/blocklist/batch", handleBatchCheck)		// Batch domain check
+		api.POST("/blocklist/check-ip", handleIPCheck)		// Check answer IPs against CIDR lists
 		
 		// Status and configuration
 		api.GET("/blocklist/sources", handleBlocklistSources)		// List sources
+		
+		// Source catalog
+		api.GET("/blocklist/catalog", handleCatalogList)			// Browse curated sources
+		api.POST("/blocklist/catalog/:id/subscribe", handleCatalogSubscribe)	// Add source by catalog ID
+		api.POST("/blocklist/catalog/:id/unsubscribe", handleCatalogUnsubscribe)	// Remove source by catalog ID
 		api.GET("/blocklist/stats", handleBlocklistStats)		// Statistics
+		api.GET("/blocklist/overlap", handleBlocklistOverlap)		// Source redundancy report
 		api.GET("/blocklist/status", handleBlocklistStatus)		// Service status
 		api.GET("/stats", handleBlocklistStats)			// Short stats endpoint
 		
+		// Heuristic DGA/tunnel detection
+		api.GET("/heuristics/config", handleHeuristicsConfig)		// Current mode and thresholds
+		api.POST("/heuristics/config", handleHeuristicsConfigUpdate)	// Tune mode and thresholds
+		api.POST("/heuristics/score", handleHeuristicsScore)		// Score a domain (never logged)
+		
+		// Time-based schedules
+		api.GET("/schedules", handleScheduleList)			// Schedules and which are active now
+		api.POST("/schedules", handleScheduleUpsert)			// Create or replace a schedule
+		api.DELETE("/schedules/:id", handleScheduleDelete)		// Remove a schedule
+		
+		// Pause and temporary allows (memory only)
+		api.POST("/pause", handlePause)					// Suspend all blocking or one category
+		api.POST("/resume", handleResume)				// End a pause early
+		api.GET("/allow/temporary", handleTemporaryAllowList)		// Unexpired temporary allows
+		api.POST("/allow/temporary", handleTemporaryAllowAdd)		// Allow a domain until a deadline
+		api.DELETE("/allow/temporary/:domain", handleTemporaryAllowRemove)	// End a temporary allow early
+		
+		// Safe search
+		api.GET("/safesearch", handleSafeSearchConfig)			// Mode, engines and mapping table
+		api.POST("/safesearch", handleSafeSearchConfigUpdate)		// Change mode and engines
+		
+		// Per-record-type policy
+		api.GET("/rrtype/policy", handleRRTypePolicy)			// Current actions per RR type
+		api.POST("/rrtype/policy", handleRRTypePolicyUpdate)		// Replace actions per RR type
+		
 		// Performance monitoring
 		api.GET("/performance/lookup", handleLookupPerformance)	// Lookup timing
 		api.GET("/performance/memory", handleMemoryUsage)		// Memory stats
@@ -113,12 +151,25 @@ func main() {
 		MaxHeaderBytes: 1024,			// Small headers for performance
 	}
 
+	// Load the source catalog shipped with the service
+	if err := loadCatalog(); err != nil {
+		log.Fatalf("❌ Failed to load blocklist catalog: %v", err)
+	}
+	
+	// Refuse to start with a mistyped heuristics mode
+	if err := checkHeuristicsConfig(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	
 	// Initialize blocklist data structures in background
 	go initializeBlocklistManager()
 	
 	// Start performance monitoring
 	go startPerformanceMonitoring()
 	
+	// Expire pauses and temporary allows
+	go pauseExpiryWheel.Run()
+	
 	// Start HTTP server
 	go func() {
 		log.Printf("🚀 Blocklist Service starting on port %s", port)
@@ -176,13 +227,21 @@ type BlocklistManager struct {
 	// Data structures for microsecond lookups
 	domainTrie     *DomainTrie		// Prefix tree for wildcard matching
 	bloomFilter    *BloomFilter		// Fast negative filtering
-	exactDomains   map[string]bool		// Hash table for exact matches
+	exactDomains   map[string]string	// Hash table for exact matches (domain -> category)
+	extraCategories map[string][]string	// Other categories whose sources also list a domain
+	ipTree         *IPPrefixTree		// Radix tree for CIDR/IP blocklists
+	typeRules      map[string][]typedRuleEntry	// $dnstype rules, checked when a qtype is given
 	
 	// Source management
 	sources        []BlocklistSource
 	lastUpdate     time.Time
 	updateInterval time.Duration
 	
+	// Version history (newest last)
+	versions       []*BlocklistVersion
+	activeVersion  int
+	nextVersionID  int
+	
 	// Performance metrics
 	stats          BlocklistStats
 }
@@ -209,6 +268,8 @@ type BlocklistSource struct {
 	Category   string	// "ads", "tracking", "malware"
 	Enabled    bool
 	Priority   int
+	UpdateFreq string	// "daily", "weekly", etc.
+	CatalogID  string	// Catalog entry this source was subscribed from
 	LastUpdate time.Time
 	EntryCount int
 }
@@ -287,7 +348,9 @@ func initializeBlocklistManager() {
 	blocklistManager = &BlocklistManager{
 		domainTrie:     NewDomainTrie(),
 		bloomFilter:    NewBloomFilter(maxDomainEntries, bloomFilterFalsePositiveRate),
-		exactDomains:   make(map[string]bool, maxDomainEntries),
+		exactDomains:   make(map[string]string, maxDomainEntries),
+		ipTree:         NewIPPrefixTree(),
+		typeRules:      make(map[string][]typedRuleEntry),
 		updateInterval: updateIntervalHours * time.Hour,
 		stats:          BlocklistStats{},
 	}
@@ -320,7 +383,7 @@ func startPeriodicUpdates() {
 		case <-ticker.C:
 			log.Println("🔄 Automatic blocklist update triggered")
 			updateStart := time.Now()
-			updateBlocklists()
+			updateBlocklists("scheduled")
 			updateDuration := time.Since(updateStart)
 			log.Printf("✅ Blocklist update completed in %v", updateDuration)
 		}
@@ -373,114 +436,80 @@ func loadDefaultBlocklists() {
 	// Default blocklist sources with privacy-first selections
 	sources := []BlocklistSource{
 		{
-			Name:     "StevenBlack",
-			URL:      "https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts",
-			Format:   "hosts",
-			Category: "ads",
-			Enabled:  true,
-			Priority: 1,
+			Name:       "StevenBlack",
+			URL:        "https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts",
+			Format:     "hosts",
+			Category:   "ads",
+			Enabled:    true,
+			Priority:   1,
+			UpdateFreq: "daily",
+			CatalogID:  "stevenblack-unified",
 		},
 		{
-			Name:     "SomeoneWhoCares",
-			URL:      "https://someonewhocares.org/hosts/zero/hosts",
-			Format:   "hosts",
-			Category: "tracking",
-			Enabled:  true,
-			Priority: 2,
+			Name:       "SomeoneWhoCares",
+			URL:        "https://someonewhocares.org/hosts/zero/hosts",
+			Format:     "hosts",
+			Category:   "tracking",
+			Enabled:    true,
+			Priority:   2,
+			UpdateFreq: "weekly",
+			CatalogID:  "someonewhocares",
 		},
 		{
-			Name:     "AdGuard",
-			URL:      "https://raw.githubusercontent.com/AdguardTeam/AdguardFilters/master/BaseFilter/sections/adservers.txt",
-			Format:   "adblock",
-			Category: "ads",
-			Enabled:  true,
-			Priority: 3,
+			Name:       "AdGuard",
+			URL:        "https://raw.githubusercontent.com/AdguardTeam/AdguardFilters/master/BaseFilter/sections/adservers.txt",
+			Format:     "adblock",
+			Category:   "ads",
+			Enabled:    true,
+			Priority:   3,
+			UpdateFreq: "daily",
+			CatalogID:  "adguard-adservers",
 		},
 	}
 	
 	mutex.Lock()
 	blocklistManager.sources = sources
-	blocklistManager.stats.ActiveSources = len(sources)
+	blocklistManager.stats.ActiveSources = countEnabledSources(sources)
 	mutex.Unlock()
 	
-	// Start loading blocklists in background
-	for _, source := range sources {
-		go loadBlocklistSource(source)
-	}
-	
 	log.Printf("✅ Initialized %d blocklist sources", len(sources))
-}
-
-// loadBlocklistSource loads a single blocklist source
-func loadBlocklistSource(source BlocklistSource) {
-	log.Printf("📥 Loading %s blocklist (%s format)", source.Name, source.Format)
-	
-	// TODO: Implement actual fetching and parsing
-	// For now, simulate loading with example domains
-	exampleDomains := []string{
-		"doubleclick.net",
-		"googleadservices.com",
-		"googlesyndication.com",
-		"facebook.com", // Example - would be from actual blocklist
-	}
-	
-	start := time.Now()
 	
-	// Add domains to data structures with thread safety
-	mutex.Lock()
-	for _, domain := range exampleDomains {
-		// Add to all data structures for optimal performance
-		blocklistManager.exactDomains[domain] = true
-		blocklistManager.domainTrie.Add(domain, source.Category)
-		blocklistManager.bloomFilter.Add(domain)
-		blocklistManager.stats.TotalDomains++
-	}
-	mutex.Unlock()
-	
-	loadDuration := time.Since(start)
-	log.Printf("✅ Loaded %s: %d domains in %v", source.Name, len(exampleDomains), loadDuration)
+	// Compile the first version before serving real data
+	updateBlocklists("startup")
 }
 
-// updateBlocklists refreshes all blocklist sources
-func updateBlocklists() {
+// updateBlocklists refreshes all blocklist sources and activates the result as a new version
+func updateBlocklists(trigger string) *BlocklistVersion {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	
 	log.Println("🔄 Updating all blocklist sources...")
 	
 	updateStart := time.Now()
-	updatedSources := 0
-	
-	mutex.RLock()
-	sources := blocklistManager.sources
-	mutex.RUnlock()
 	
-	// Update each enabled source
-	for _, source := range sources {
-		if !source.Enabled {
-			continue
-		}
-		
-		log.Printf("🔄 Updating %s...", source.Name)
-		
-		// TODO: Implement actual update logic
-		// For now, simulate update
-		time.Sleep(100 * time.Millisecond) // Simulate network delay
-		
-		updatedSources++
-	}
+	// Fetch and compile outside the lock, lookups keep using the active version
+	version := buildBlocklistVersion(trigger)
 	
-	// Update statistics
+	// Swap in the new version atomically
 	mutex.Lock()
+	storeVersion(version)
+	activateVersion(version)
 	blocklistManager.lastUpdate = time.Now()
 	blocklistManager.stats.LastUpdate = time.Now()
+	stats := blocklistManager.stats
 	mutex.Unlock()
+	finishUpdateProgress(version.ID)
 	
 	updateDuration := time.Since(updateStart)
-	log.Printf("✅ Updated %d sources in %v", updatedSources, updateDuration)
+	log.Printf("✅ Compiled version %d from %d sources in %v", version.ID, len(version.Results), updateDuration)
 	
 	// Log performance metrics (no user data)
 	log.Printf("📊 Current stats: %d domains, %.2f%% cache hit rate, %v avg lookup", 
-		blocklistManager.stats.TotalDomains, 
-		blocklistManager.stats.CacheHitRate*100,
-		blocklistManager.stats.AvgLookupTime)
+		stats.TotalDomains, 
+		stats.CacheHitRate*100,
+		stats.AvgLookupTime)
+	
+	return version
 }
 
 // ============================================================================
@@ -647,13 +676,7 @@ func handleBlocklistParse(c *gin.Context) {
 	start := time.Now()
 	
 	// Validate format
-	validFormats := map[string]bool{
-		"hosts":   true,
-		"adblock": true,
-		"domains": true,
-	}
-	
-	if !validFormats[request.Format] {
+	if !blocklistFormats[request.Format] {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format"})
 		return
 	}
@@ -761,6 +784,8 @@ func handleBlocklistSources(c *gin.Context) {
 			"format": source.Format,
 			"enabled": source.Enabled,
 			"priority": source.Priority,
+			"update_freq": source.UpdateFreq,
+			"catalog_id": source.CatalogID,
 			"entry_count": source.EntryCount,
 			"last_update": source.LastUpdate.Format(time.RFC3339),
 			// Note: URL not exposed for security
@@ -800,6 +825,7 @@ func handleBlocklistSources(c *gin.Context) {
 func handleDomainCheck(c *gin.Context) {
 	var request struct {
 		Domain string `json:"domain"` // Domain to check (never logged)
+		QType  string `json:"qtype,omitempty"` // Query type, e.g. "AAAA" (enables $dnstype and RR-type policy)
 	}
 	
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -813,6 +839,12 @@ func handleDomainCheck(c *gin.Context) {
 		return
 	}
 	
+	qtype, ok := normalizeQType(request.QType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported qtype"})
+		return
+	}
+	
 	start := time.Now()
 	
 	// PRIVACY CRITICAL: Check domain blocking without logging the domain name
@@ -824,38 +856,13 @@ func handleDomainCheck(c *gin.Context) {
 		return
 	}
 	
-	// Multi-stage lookup for optimal performance:
-	// 1. Bloom filter (O(1) - fast negative)
-	// 2. Hash table (O(1) - exact match)
-	// 3. Trie (O(m) - wildcard/prefix match)
-	
-	blocked := false
-	category := ""
-	lookupMethod := ""
-	
-	// Stage 1: Bloom filter (fastest negative filter)
-	if !blocklistManager.bloomFilter.Check(request.Domain) {
-		// Definitely not blocked
-		blocked = false
-		lookupMethod = "bloom_filter"
-		cacheMisses++
+	// Multi-stage lookup: RR-type policy -> bloom filter -> hash table -> trie -> $dnstype -> schedules -> heuristics
+	verdict := checkDomain(request.Domain, qtype)
+	blocked, category, lookupMethod := verdict.Blocked, verdict.Category, verdict.Method
+	if blocked {
+		cacheHits++
 	} else {
-		// Stage 2: Hash table (exact match)
-		if blocklistManager.exactDomains[request.Domain] {
-			blocked = true
-			category = "exact"
-			lookupMethod = "hash_table"
-			cacheHits++
-		} else {
-			// Stage 3: Trie (wildcard/prefix match)
-			blocked, category = blocklistManager.domainTrie.Check(request.Domain)
-			lookupMethod = "trie"
-			if blocked {
-				cacheHits++
-			} else {
-				cacheMisses++
-			}
-		}
+		cacheMisses++
 	}
 	
 	lookupTime := time.Since(start)
@@ -874,6 +881,11 @@ func handleDomainCheck(c *gin.Context) {
 		"category": category,
 		"lookup_time": lookupTime.String(),
 		"lookup_method": lookupMethod,
+		"refuse": verdict.Refuse,		// Answer REFUSED instead of a blocked response
+		"schedule": verdict.Schedule,		// Schedule that decided, if any
+		"rewrite": verdict.Rewrite,		// Safe-search CNAME target, if any
+		"heuristic": verdict.Heuristic,	// Set in warn and block modes when flagged
+		"paused": verdict.Paused,		// Pause or temporary allow covers the name
 		"performance_target_met": lookupTime <= time.Millisecond,
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 		// CRITICAL: No domain name in response to prevent logging
@@ -884,11 +896,198 @@ func handleDomainCheck(c *gin.Context) {
 		blocked, lookupMethod, lookupTime)
 }
 
+// DomainVerdict is the outcome of a domain lookup
+// Privacy: Never contains the domain itself
+type DomainVerdict struct {
+	Blocked   bool
+	Category  string
+	Method    string		// Stage that decided: rrtype_policy, bloom_filter, hash_table, trie, dnstype, schedule, heuristic
+	Refuse    bool			// RR-type policy asks for REFUSED rather than a blocked answer
+	Schedule  string		// Schedule whose window decided the verdict, if any
+	Rewrite   string		// Safe-search CNAME target the resolver must answer with
+	Paused    bool			// Blocking, the name or every category listing it is paused; resolvers skip their blocklist filters too
+	Heuristic *HeuristicResult	// Set when heuristic scoring flagged the domain
+	
+	categories []string		// Every category listing the name, decisive one first; set by blocklist stages
+}
+
+// allCategories returns the categories schedules and pauses must all lift before a
+// blocked name is allowed; stages that do not list categories report their own
+func (verdict DomainVerdict) allCategories() []string {
+	if len(verdict.categories) == 0 {
+		return []string{verdict.Category}
+	}
+	return verdict.categories
+}
+
+// blocklistMatcher is the set of compiled structures a domain lookup runs against
+// The structures are never mutated after compilation, so a snapshot stays valid after unlocking
+type blocklistMatcher struct {
+	domainTrie      *DomainTrie
+	bloomFilter     *BloomFilter
+	exactDomains    map[string]string
+	extraCategories map[string][]string
+	typeRules       map[string][]typedRuleEntry
+}
+
+// liveMatcher returns the structures live traffic is checked against
+// Caller must hold mutex for reading
+func liveMatcher() blocklistMatcher {
+	return blocklistMatcher{
+		domainTrie:      blocklistManager.domainTrie,
+		bloomFilter:     blocklistManager.bloomFilter,
+		exactDomains:    blocklistManager.exactDomains,
+		extraCategories: blocklistManager.extraCategories,
+		typeRules:       blocklistManager.typeRules,
+	}
+}
+
+// checkDomain runs the multi-stage lookup shared by the single and batch handlers
+// qtype is optional; without it $dnstype rules and the RR-type policy are skipped
+// Caller must hold mutex for reading
+func checkDomain(domain, qtype string) DomainVerdict {
+	return checkDomainWith(liveMatcher(), domain, qtype, false)
+}
+
+// checkDomainWith runs the full lookup pipeline against the given structures
+// parents also probes the bloom filter for each parent domain (CNAME targets only)
+func checkDomainWith(matcher blocklistMatcher, domain, qtype string, parents bool) DomainVerdict {
+	// Pauses and temporary allows override every blocking stage
+	verdict := applyPauses(domain, evaluateDomain(matcher, domain, qtype, parents))
+	paused := verdict.Paused || pauseOverride(domain, "") != ""
+	
+	// Safe search stays enforced while blocking is paused
+	if !verdict.Blocked {
+		if target, engine := safeSearchTarget(domain); target != "" {
+			verdict = DomainVerdict{Category: engine, Method: "safe_search", Rewrite: target}
+		}
+	}
+	verdict.Paused = paused
+	
+	return verdict
+}
+
+// evaluateDomain runs the blocking stages in order
+func evaluateDomain(matcher blocklistMatcher, domain, qtype string, parents bool) DomainVerdict {
+	// Global per-record-type policy applies to every name
+	switch checkRRTypePolicy(qtype) {
+	case "block":
+		return DomainVerdict{Blocked: true, Category: categoryRRTypePolicy, Method: "rrtype_policy"}
+	case "refuse":
+		return DomainVerdict{Blocked: true, Category: categoryRRTypePolicy, Method: "rrtype_policy", Refuse: true}
+	}
+	
+	verdict := evaluateBlocklists(matcher, domain, qtype, parents)
+	
+	// Optional heuristic stage for names no blocklist knows yet
+	if !verdict.Blocked {
+		config := currentHeuristicsConfig()
+		if config.Mode != "off" {
+			result := scoreDomain(normalizeDomain(domain), config)
+			if result.Flagged {
+				verdict.Heuristic = &result
+				if config.Mode == "block" {
+					verdict.Blocked = true
+					verdict.Category = result.Category
+					verdict.Method = "heuristic"
+				}
+			}
+		}
+	}
+	
+	return verdict
+}
+
+// evaluateBlocklists runs the stages driven by blocklist content: the compiled
+// lookup, $dnstype rules and schedules
+// Candidate evaluation compares versions on these stages alone
+func evaluateBlocklists(matcher blocklistMatcher, domain, qtype string, parents bool) DomainVerdict {
+	verdict := lookupBlocklist(matcher, domain, parents)
+	
+	// Rules restricted to some query types; when the name is already listed their
+	// categories still count for schedules and pauses
+	if typed := lookupTypeRules(matcher, domain, qtype); typed.Blocked {
+		if !verdict.Blocked {
+			verdict = typed
+		} else {
+			for _, category := range typed.categories {
+				verdict.categories = appendCategory(verdict.categories, category)
+			}
+		}
+	}
+	
+	// Time windows for scheduled categories and rule sets
+	return applySchedules(domain, verdict)
+}
+
+// lookupBlocklist checks the compiled blocklist structures
+// With parents set the bloom filter is also probed for each parent domain, so
+// CNAME targets under a listed tracker zone reach the trie
+func lookupBlocklist(matcher blocklistMatcher, domain string, parents bool) DomainVerdict {
+	// Stage 1: Bloom filter (O(1), O(labels) with parents - fast negative)
+	candidate := matcher.bloomFilter.Check(domain)
+	for suffix := domain; parents && !candidate; {
+		dot := strings.IndexByte(suffix, '.')
+		if dot < 0 {
+			break
+		}
+		suffix = suffix[dot+1:]
+		candidate = matcher.bloomFilter.Check(suffix)
+	}
+	if !candidate {
+		return DomainVerdict{Method: "bloom_filter"} // Definitely not blocked
+	}
+	
+	// Stage 2: Hash table (O(1) - exact match)
+	if category, ok := matcher.exactDomains[domain]; ok {
+		return DomainVerdict{Blocked: true, Category: category, Method: "hash_table", categories: listingCategories(matcher, domain, category)}
+	}
+	
+	// Stage 3: Trie (O(m) - wildcard/prefix match)
+	blocked, category := matcher.domainTrie.Check(domain)
+	if !blocked {
+		return DomainVerdict{Method: "trie"}
+	}
+	return DomainVerdict{Blocked: true, Category: category, Method: "trie", categories: listingCategories(matcher, domain, category)}
+}
+
+// listingCategories collects the categories of every source listing domain or one
+// of its parents, starting with the decisive category
+func listingCategories(matcher blocklistMatcher, domain, decisive string) []string {
+	categories := []string{decisive}
+	for suffix := domain; suffix != ""; {
+		if category, ok := matcher.exactDomains[suffix]; ok {
+			categories = appendCategory(categories, category)
+			for _, extra := range matcher.extraCategories[suffix] {
+				categories = appendCategory(categories, extra)
+			}
+		}
+		dot := strings.IndexByte(suffix, '.')
+		if dot < 0 {
+			break
+		}
+		suffix = suffix[dot+1:]
+	}
+	return categories
+}
+
+// appendCategory adds a category once
+func appendCategory(categories []string, category string) []string {
+	for _, existing := range categories {
+		if existing == category {
+			return categories
+		}
+	}
+	return append(categories, category)
+}
+
 // handleBatchCheck performs batch domain checking for efficiency
 // Privacy: Batch processing without domain logging
 func handleBatchCheck(c *gin.Context) {
 	var request struct {
 		Domains []string `json:"domains"` // Domains to check (never logged)
+		QType string `json:"qtype,omitempty"` // Query type applied to every domain in the batch
+		MatchParents bool `json:"match_parents,omitempty"` // Also probe parent domains (CNAME target checks)
 		MaxBatchSize int `json:"max_batch_size,omitempty"`
 	}
 	
@@ -897,6 +1096,12 @@ func handleBatchCheck(c *gin.Context) {
 		return
 	}
 	
+	qtype, ok := normalizeQType(request.QType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported qtype"})
+		return
+	}
+	
 	// Limit batch size for performance and security
 	maxBatch := 100
 	if request.MaxBatchSize > 0 && request.MaxBatchSize < maxBatch {
@@ -921,6 +1126,7 @@ func handleBatchCheck(c *gin.Context) {
 	}
 	
 	// Process batch efficiently
+	matcher := liveMatcher()
 	results := make([]gin.H, len(request.Domains))
 	blockedCount := 0
 	maxLookupTime := time.Duration(0)
@@ -929,21 +1135,9 @@ func handleBatchCheck(c *gin.Context) {
 		// Individual domain check (same logic as single check)
 		domainStart := time.Now()
 		
-		blocked := false
-		category := ""
-		lookupMethod := ""
-		
-		// Fast lookup using bloom -> hash -> trie
-		if !blocklistManager.bloomFilter.Check(domain) {
-			lookupMethod = "bloom_filter"
-		} else if blocklistManager.exactDomains[domain] {
-			blocked = true
-			category = "exact"
-			lookupMethod = "hash_table"
-		} else {
-			blocked, category = blocklistManager.domainTrie.Check(domain)
-			lookupMethod = "trie"
-		}
+		// Fast lookup using RR-type policy -> bloom -> hash -> trie -> $dnstype -> schedules -> heuristics
+		verdict := checkDomainWith(matcher, domain, qtype, request.MatchParents)
+		blocked, category, lookupMethod := verdict.Blocked, verdict.Category, verdict.Method
 		
 		domainTime := time.Since(domainStart)
 		if domainTime > maxLookupTime {
@@ -961,6 +1155,11 @@ func handleBatchCheck(c *gin.Context) {
 			"category": category,
 			"lookup_time": domainTime.String(),
 			"lookup_method": lookupMethod,
+			"refuse": verdict.Refuse,
+			"schedule": verdict.Schedule,
+			"rewrite": verdict.Rewrite,
+			"heuristic": verdict.Heuristic,
+			"paused": verdict.Paused,
 			// Note: No domain name to maintain privacy
 		}
 		
@@ -999,6 +1198,83 @@ func handleBatchCheck(c *gin.Context) {
 		len(request.Domains), blockedCount, avgTimePerDomain, maxLookupTime)
 }
 
+// handleIPCheck checks resolved addresses against CIDR/IP blocklists
+// Used by the dns-service to filter answers that point into known-bad infrastructure
+// Privacy: Addresses are checked in memory and never logged
+func handleIPCheck(c *gin.Context) {
+	var request struct {
+		IPs []string `json:"ips"` // Answer addresses to check (never logged)
+		Domain string `json:"domain,omitempty"` // Queried name, so temporary allows apply (never logged)
+	}
+	
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	
+	if len(request.IPs) == 0 || len(request.IPs) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "between 1 and 100 ips required"})
+		return
+	}
+	
+	start := time.Now()
+	
+	mutex.RLock()
+	defer mutex.RUnlock()
+	
+	if blocklistManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service not ready"})
+		return
+	}
+	
+	results := make([]gin.H, len(request.IPs))
+	blockedCount := 0
+	
+	for i, raw := range request.IPs {
+		addr, err := netip.ParseAddr(raw)
+		if err != nil {
+			results[i] = gin.H{"index": i, "blocked": false, "error": "invalid ip"}
+			continue
+		}
+		
+		blocked, category, prefix := blocklistManager.ipTree.Check(addr)
+		
+		// Pauses and temporary allows override IP blocking like domain blocking
+		method := "ip_tree"
+		if blocked {
+			if override := pauseOverride(request.Domain, category); override != "" {
+				blocked, category, method = false, "", override
+			}
+		}
+		
+		result := gin.H{
+			"index": i,
+			"blocked": blocked,
+			"category": category,
+			"lookup_method": method,
+		}
+		if blocked {
+			blockedCount++
+			result["matched_prefix"] = prefix.String()
+		}
+		results[i] = result
+	}
+	
+	lookupTime := time.Since(start)
+	
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"blocked_count": blockedCount,
+		"ip_ranges_loaded": blocklistManager.ipTree.Len(),
+		"lookup_time": lookupTime.String(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		// Note: Addresses are not echoed back, results are matched by index
+	})
+	
+	// Log only performance metrics (no addresses)
+	log.Printf("🔍 IP check: %d ips, %d blocked, time=%v", len(request.IPs), blockedCount, lookupTime)
+}
+
 // ============================================================================
 // STATUS AND MONITORING HANDLERS
 // System health and performance monitoring endpoints
@@ -1031,7 +1307,10 @@ func handleHealthCheck(c *gin.Context) {
 			"privacy_mode": privacyMode,
 			"no_query_logging": noQueryLogging,
 			"no_user_data_storage": noUserDataStorage,
+			"heuristics_mode": currentHeuristicsConfig().Mode,
+			"safe_search_mode": currentSafeSearchConfig().Mode,
 		},
+		"pause": pauseStatus(),		// Shown by the menu bar
 		"performance": gin.H{
 			"lookup_target_ms": domainLookupTargetMs,
 			"memory_target_mb": maxMemoryUsageMB,
@@ -1084,11 +1363,43 @@ func handlePerformanceMetrics(c *gin.Context) {
 	})
 }
 
-// Placeholder handlers for remaining endpoints
+// handleBlocklistReload re-fetches all sources in the background and activates the
+// result as a new version; fetches can outlast the write timeout, so progress is
+// reported by /blocklist/status instead of this response
+// Privacy: System operation only, no user data involved
 func handleBlocklistReload(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	mutex.RLock()
+	ready := blocklistManager != nil
+	mutex.RUnlock()
+	
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "blocklist manager not initialized"})
+		return
+	}
+	
+	// Claim the update slot so concurrent reload requests start a single reload
+	updateProgressMux.Lock()
+	running := updateProgress.Running
+	if !running {
+		updateProgress = UpdateProgress{Trigger: "reload", Running: true, StartedAt: time.Now()}
+	}
+	updateProgressMux.Unlock()
+	
+	status := "reload_in_progress"
+	if !running {
+		status = "reload_started"
+		go updateBlocklists("reload")
+	}
+	
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": status,
+		"progress": "/api/v1/blocklist/status",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
 }
 
+// Placeholder handlers for remaining endpoints
+
 func handleBlocklistStats(c *gin.Context) {
 	start := time.Now()
 	
@@ -1131,8 +1442,37 @@ func handleBlocklistStats(c *gin.Context) {
 	})
 }
 
+// handleBlocklistStatus reports what is being enforced right now
+// Privacy: System state only, no user data
 func handleBlocklistStatus(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	start := time.Now()
+	
+	mutex.RLock()
+	if blocklistManager == nil {
+		mutex.RUnlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service not ready"})
+		return
+	}
+	activeVersion := blocklistManager.activeVersion
+	domainCount := blocklistManager.stats.TotalDomains
+	lastUpdate := blocklistManager.lastUpdate
+	mutex.RUnlock()
+	
+	scheduleMutex.RLock()
+	activeSchedules := activeScheduleIDs(scheduleClock())
+	scheduleMutex.RUnlock()
+	
+	c.JSON(http.StatusOK, gin.H{
+		"active_version": activeVersion,
+		"domains_loaded": domainCount,
+		"last_update": lastUpdate.Format(time.RFC3339),
+		"pause": pauseStatus(),
+		"update": updateProgressStatus(),
+		"active_schedules": activeSchedules,
+		"heuristics_mode": currentHeuristicsConfig().Mode,
+		"response_time": time.Since(start).String(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
 }
 
 func handleLookupPerformance(c *gin.Context) {
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// SOURCE OVERLAP ANALYSIS
+// Pairwise redundancy report computed on sorted 64-bit domain hashes
+// ============================================================================
+
+const (
+	// Approximate per-entry cost across hash table, trie node and bloom bits
+	entryOverheadBytes = 160
+
+	// Sources contributing fewer unique entries than this ratio are drop candidates
+	redundantUniqueRatio = 0.05
+)
+
+// hashedSource is a source's domains reduced to a sorted, deduplicated hash set
+type hashedSource struct {
+	name        string
+	hashes      []uint64
+	domainBytes int
+}
+
+// hashDomain computes a 64-bit FNV-1a hash without allocating
+func hashDomain(domain string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(domain); i++ {
+		hash ^= uint64(domain[i])
+		hash *= 1099511628211
+	}
+	return hash
+}
+
+// newHashedSource builds the sorted hash set for a source
+func newHashedSource(name string, domains []string) hashedSource {
+	hashes := make([]uint64, len(domains))
+	domainBytes := 0
+	for i, domain := range domains {
+		hashes[i] = hashDomain(domain)
+		domainBytes += len(domain)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	// Deduplicate in place (parsers already dedupe, collisions are merged here)
+	unique := hashes[:0]
+	for i, hash := range hashes {
+		if i == 0 || hash != hashes[i-1] {
+			unique = append(unique, hash)
+		}
+	}
+
+	return hashedSource{name: name, hashes: unique, domainBytes: domainBytes}
+}
+
+// intersectCount counts shared hashes between two sorted sets in O(n+m)
+func intersectCount(a, b []uint64) int {
+	shared := 0
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			shared++
+			i++
+			j++
+		}
+	}
+	return shared
+}
+
+// estimateMemoryMB approximates the memory a source's entries occupy once compiled
+func estimateMemoryMB(entries, domainBytes int) float64 {
+	return float64(entries*entryOverheadBytes+domainBytes) / (1024 * 1024)
+}
+
+// uniqueCount counts the hashes of a source no other counted source contains
+func uniqueCount(source hashedSource, sourceCounts map[uint64]int) int {
+	unique := 0
+	for _, hash := range source.hashes {
+		if sourceCounts[hash] == 1 {
+			unique++
+		}
+	}
+	return unique
+}
+
+// dropSavingsMB approximates the memory freed by dropping unique entries of a source
+func dropSavingsMB(source hashedSource, unique int) float64 {
+	if len(source.hashes) == 0 {
+		return 0
+	}
+	avgDomainBytes := source.domainBytes / len(source.hashes)
+	return estimateMemoryMB(unique, unique*avgDomainBytes)
+}
+
+// selectDropCandidates greedily picks redundant sources: the least unique source is
+// dropped first and its hashes uncounted before the rest are scored again, so two
+// identical lists never both qualify and reclaimable memory is counted once
+// sourceCounts is consumed; the returned set holds indexes into hashed
+func selectDropCandidates(hashed []hashedSource, sourceCounts map[uint64]int) (map[int]bool, []string, float64) {
+	dropped := map[int]bool{}
+	names := []string{}
+	reclaimableMB := 0.0
+
+	for {
+		best, bestUnique, bestRatio := -1, 0, redundantUniqueRatio
+		for i, source := range hashed {
+			if dropped[i] || len(source.hashes) == 0 {
+				continue
+			}
+			unique := uniqueCount(source, sourceCounts)
+			if ratio := float64(unique) / float64(len(source.hashes)); ratio < bestRatio {
+				best, bestUnique, bestRatio = i, unique, ratio
+			}
+		}
+		if best < 0 {
+			return dropped, names, reclaimableMB
+		}
+
+		dropped[best] = true
+		names = append(names, hashed[best].name)
+		reclaimableMB += dropSavingsMB(hashed[best], bestUnique)
+		for _, hash := range hashed[best].hashes {
+			sourceCounts[hash]--
+		}
+	}
+}
+
+// handleBlocklistOverlap reports pairwise overlap, unique contribution and Jaccard similarity
+// Privacy: Operates on list contents only, no user data
+func handleBlocklistOverlap(c *gin.Context) {
+	start := time.Now()
+
+	mutex.RLock()
+	if blocklistManager == nil {
+		mutex.RUnlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "blocklist manager not initialized"})
+		return
+	}
+	sources := make([]BlocklistSource, len(blocklistManager.sources))
+	copy(sources, blocklistManager.sources)
+	active := currentVersion()
+	mutex.RUnlock()
+
+	if active == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no compiled blocklist version yet"})
+		return
+	}
+
+	// Hash every enabled source of the active version (immutable, safe without the lock)
+	hashed := make([]hashedSource, 0, len(sources))
+	for _, source := range sources {
+		if source.Enabled {
+			hashed = append(hashed, newHashedSource(source.Name, active.sourceDomains[source.Name]))
+		}
+	}
+
+	// Count how many sources contain each hash to find unique contributions
+	sourceCounts := make(map[uint64]int)
+	totalEntries := 0
+	totalDomainBytes := 0
+	for _, source := range hashed {
+		totalEntries += len(source.hashes)
+		totalDomainBytes += source.domainBytes
+		for _, hash := range source.hashes {
+			sourceCounts[hash]++
+		}
+	}
+	distinctEntries := len(sourceCounts)
+
+	sourceReports := make([]gin.H, len(hashed))
+	for i, source := range hashed {
+		unique := uniqueCount(source, sourceCounts)
+
+		uniqueRatio := 0.0
+		if len(source.hashes) > 0 {
+			uniqueRatio = float64(unique) / float64(len(source.hashes))
+		}
+
+		sourceReports[i] = gin.H{
+			"name":                source.name,
+			"entry_count":         len(source.hashes),
+			"unique_count":        unique,
+			"unique_ratio":        uniqueRatio,
+			"estimated_memory_mb": estimateMemoryMB(len(source.hashes), source.domainBytes),
+			"drop_savings_mb":     dropSavingsMB(source, unique),
+		}
+	}
+
+	dropped, dropCandidates, reclaimableMB := selectDropCandidates(hashed, sourceCounts)
+	for i := range sourceReports {
+		sourceReports[i]["redundant"] = dropped[i]
+	}
+
+	pairs := []gin.H{}
+	for i := 0; i < len(hashed); i++ {
+		for j := i + 1; j < len(hashed); j++ {
+			shared := intersectCount(hashed[i].hashes, hashed[j].hashes)
+			union := len(hashed[i].hashes) + len(hashed[j].hashes) - shared
+
+			jaccard := 0.0
+			if union > 0 {
+				jaccard = float64(shared) / float64(union)
+			}
+
+			pairs = append(pairs, gin.H{
+				"source_a": hashed[i].name,
+				"source_b": hashed[j].name,
+				"shared":   shared,
+				"jaccard":  jaccard,
+			})
+		}
+	}
+
+	// Compiled structures hold each distinct entry once
+	estimatedTotalMB := 0.0
+	if totalEntries > 0 {
+		estimatedTotalMB = estimateMemoryMB(distinctEntries, totalDomainBytes*distinctEntries/totalEntries)
+	}
+
+	analysisTime := time.Since(start)
+
+	c.JSON(http.StatusOK, gin.H{
+		"version": active.ID,
+		"sources": sourceReports,
+		"pairs":   pairs,
+		"summary": gin.H{
+			"total_entries":       totalEntries,
+			"distinct_entries":    distinctEntries,
+			"duplicate_entries":   totalEntries - distinctEntries,
+			"estimated_memory_mb": estimatedTotalMB,
+			"memory_target_mb":    maxMemoryUsageMB,
+			"drop_candidates":     dropCandidates,
+			"reclaimable_mb":      reclaimableMB,
+		},
+		"analysis_time": analysisTime.String(),
+		"timestamp":     time.Now().UTC().Format(time.RFC3339),
+	})
+
+	log.Printf("📊 Overlap analysis: %d sources, %d distinct entries in %v",
+		len(hashed), distinctEntries, analysisTime)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// numberedDomains returns count domains named prefix-N.example
+func numberedDomains(prefix string, from, count int) []string {
+	domains := make([]string, count)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("%s-%d.example", prefix, from+i)
+	}
+	return domains
+}
+
+func TestIntersectCount(t *testing.T) {
+	tests := []struct {
+		a, b []uint64
+		want int
+	}{
+		{nil, nil, 0},
+		{[]uint64{1, 2, 3}, nil, 0},
+		{[]uint64{1, 2, 3}, []uint64{1, 2, 3}, 3},
+		{[]uint64{1, 3, 5, 7}, []uint64{2, 3, 4, 7, 9}, 2},
+		{[]uint64{1, 2}, []uint64{3, 4}, 0},
+	}
+	for _, test := range tests {
+		if got := intersectCount(test.a, test.b); got != test.want {
+			t.Errorf("intersectCount(%v, %v) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := intersectCount(test.b, test.a); got != test.want {
+			t.Errorf("intersectCount(%v, %v) = %d, want %d", test.b, test.a, got, test.want)
+		}
+	}
+}
+
+func TestNewHashedSource(t *testing.T) {
+	source := newHashedSource("list", []string{"b.example", "a.example", "b.example", "c.example"})
+
+	if source.name != "list" {
+		t.Errorf("name = %q, want list", source.name)
+	}
+	if len(source.hashes) != 3 {
+		t.Fatalf("hashes = %v, want 3 distinct", source.hashes)
+	}
+	if !sort.SliceIsSorted(source.hashes, func(i, j int) bool { return source.hashes[i] < source.hashes[j] }) {
+		t.Errorf("hashes %v are not sorted", source.hashes)
+	}
+	if source.domainBytes != 4*len("a.example") {
+		t.Errorf("domainBytes = %d, want %d", source.domainBytes, 4*len("a.example"))
+	}
+	if intersectCount(source.hashes, []uint64{hashDomain("a.example"), hashDomain("z.example")}) != 1 {
+		t.Error("hash set does not contain a.example")
+	}
+}
+
+func TestHandleBlocklistOverlap(t *testing.T) {
+	shared := numberedDomains("shared", 0, 100)
+	copied := append(numberedDomains("shared", 0, 100), numberedDomains("copied", 0, 10)...)
+	installTestBlocklist(t,
+		[]BlocklistSource{
+			{Name: "copy-a", Category: "ads", Enabled: true},
+			{Name: "copy-b", Category: "ads", Enabled: true},
+			{Name: "mostly-covered", Category: "tracking", Enabled: true},
+			{Name: "distinct", Category: "malware", Enabled: true},
+		},
+		map[string][]string{
+			"copy-a":         copied,
+			"copy-b":         copied,
+			"mostly-covered": append(append([]string{}, shared[:99]...), "only-here.example"),
+			"distinct":       numberedDomains("distinct", 0, 50),
+		})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/overlap", handleBlocklistOverlap)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/overlap", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		Sources []struct {
+			Name          string  `json:"name"`
+			UniqueCount   int     `json:"unique_count"`
+			DropSavingsMB float64 `json:"drop_savings_mb"`
+			Redundant     bool    `json:"redundant"`
+		} `json:"sources"`
+		Pairs []struct {
+			SourceA string  `json:"source_a"`
+			SourceB string  `json:"source_b"`
+			Shared  int     `json:"shared"`
+			Jaccard float64 `json:"jaccard"`
+		} `json:"pairs"`
+		Summary struct {
+			TotalEntries    int      `json:"total_entries"`
+			DistinctEntries int      `json:"distinct_entries"`
+			DropCandidates  []string `json:"drop_candidates"`
+			ReclaimableMB   float64  `json:"reclaimable_mb"`
+		} `json:"summary"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Summary.TotalEntries != 370 || response.Summary.DistinctEntries != 161 {
+		t.Errorf("total/distinct = %d/%d, want 370/161", response.Summary.TotalEntries, response.Summary.DistinctEntries)
+	}
+
+	// Only one of the identical lists may go; the other then holds the shared entries alone
+	candidates := response.Summary.DropCandidates
+	if len(candidates) != 2 || candidates[1] != "mostly-covered" || (candidates[0] != "copy-a" && candidates[0] != "copy-b") {
+		t.Fatalf("drop candidates = %v, want one copy then mostly-covered", candidates)
+	}
+
+	reclaimable := 0.0
+	for _, source := range response.Sources {
+		if source.Redundant != (source.Name == candidates[0] || source.Name == candidates[1]) {
+			t.Errorf("%s: redundant = %v", source.Name, source.Redundant)
+		}
+		if source.Name == "mostly-covered" {
+			if source.UniqueCount != 1 {
+				t.Errorf("mostly-covered unique count = %d, want 1", source.UniqueCount)
+			}
+			reclaimable = source.DropSavingsMB
+		}
+	}
+	// The dropped copy frees nothing while its twin stays, so only the unique entry counts
+	if reclaimable == 0 || response.Summary.ReclaimableMB != reclaimable {
+		t.Errorf("reclaimable_mb = %v, want %v", response.Summary.ReclaimableMB, reclaimable)
+	}
+
+	for _, pair := range response.Pairs {
+		if pair.SourceA == "copy-a" && pair.SourceB == "copy-b" && (pair.Shared != 110 || pair.Jaccard != 1) {
+			t.Errorf("identical pair shared %d, jaccard %v, want 110 and 1", pair.Shared, pair.Jaccard)
+		}
+		if pair.SourceB == "distinct" && pair.Shared != 0 {
+			t.Errorf("%s/distinct shared = %d, want 0", pair.SourceA, pair.Shared)
+		}
+	}
+}
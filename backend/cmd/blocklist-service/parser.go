@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// ============================================================================
+// BLOCKLIST PARSING
+// Streaming parsers for hosts, adblock, plain domain and CIDR list formats
+// ============================================================================
+
+const (
+	maxDomainLength    = 253 // RFC 1035 limit for a full domain name
+	maxLabelLength     = 63  // RFC 1035 limit for a single label
+	maxRejectedSamples = 10  // Rejected rules kept for diagnostics
+	minIPv4PrefixBits  = 8   // Broader prefixes are rejected as list errors
+	minIPv6PrefixBits  = 16
+)
+
+// ParseResult holds the outcome of parsing one blocklist
+// Privacy: Contains list rules only, never user queries
+type ParseResult struct {
+	Domains         []string       // Normalized, deduplicated domains
+	Prefixes        []netip.Prefix // Deduplicated IP prefixes (cidr format only)
+	TypeRules       []DNSTypeRule  // Rules restricted to some query types ($dnstype, adblock format only)
+	LinesRead       int            // Total lines read from the source
+	Rejected        int            // Rules that failed validation
+	RejectedSamples []string       // First few rejected rules for diagnostics
+	Unsupported     int            // Adblock rules skipped for modifiers without DNS support
+	Revision        string         // SHA-256 of the raw list content
+}
+
+// blocklistFormats lists the formats with a registered parser
+var blocklistFormats = map[string]bool{
+	"hosts":   true,
+	"adblock": true,
+	"domains": true,
+	"cidr":    true,
+}
+
+// hostsSinkholeAddresses are the addresses hosts files use to block a name
+var hostsSinkholeAddresses = map[string]bool{
+	"0.0.0.0":   true,
+	"127.0.0.1": true,
+	"::":        true,
+	"::1":       true,
+}
+
+// hostsLocalNames are hosts file entries that must never be blocked
+var hostsLocalNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"0.0.0.0":               true,
+}
+
+// parseBlocklist streams a blocklist from r and extracts domains
+// The raw content is hashed while reading so the revision is known without buffering
+func parseBlocklist(r io.Reader, format string) (*ParseResult, error) {
+	var lineParser func(line string) (string, bool)
+	switch format {
+	case "hosts":
+		lineParser = parseHostsLine
+	case "adblock":
+		lineParser = parseAdblockLine
+	case "domains":
+		lineParser = parseDomainsLine
+	case "cidr":
+		lineParser = parseCIDRLine
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+
+	hasher := sha256.New()
+	scanner := bufio.NewScanner(io.TeeReader(r, hasher))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	result := &ParseResult{}
+	seen := make(map[string]bool)
+	seenPrefixes := make(map[netip.Prefix]bool)
+	seenTypeRules := make(map[string]int) // Domain -> index in result.TypeRules
+
+	for scanner.Scan() {
+		result.LinesRead++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		domain, isRule := lineParser(line)
+		if !isRule {
+			continue // Comment or non-blocking directive
+		}
+
+		var typeRule *DNSTypeRule
+		if format == "adblock" {
+			var err error
+			domain, typeRule, err = parseAdblockModifiers(domain)
+			if errors.Is(err, errUnsupportedModifier) {
+				result.Unsupported++
+				continue
+			}
+			if err != nil {
+				result.reject(line)
+				continue
+			}
+		}
+
+		if format == "cidr" {
+			prefix, ok := parseIPPrefix(domain)
+			if !ok {
+				result.reject(line)
+				continue
+			}
+			if !seenPrefixes[prefix] {
+				seenPrefixes[prefix] = true
+				result.Prefixes = append(result.Prefixes, prefix)
+			}
+		} else {
+			domain = normalizeDomain(domain)
+			if !isValidDomain(domain) {
+				result.reject(line)
+				continue
+			}
+			switch {
+			case typeRule != nil:
+				typeRule.Domain = domain
+				if index, ok := seenTypeRules[domain]; ok {
+					// Several $dnstype rules for one domain block the union of their types
+					result.TypeRules[index] = result.TypeRules[index].Merge(*typeRule)
+				} else {
+					seenTypeRules[domain] = len(result.TypeRules)
+					result.TypeRules = append(result.TypeRules, *typeRule)
+				}
+			case !seen[domain]:
+				seen[domain] = true
+				result.Domains = append(result.Domains, domain)
+			}
+		}
+
+		if len(result.Domains)+len(result.Prefixes)+len(result.TypeRules) >= maxDomainEntries {
+			return nil, fmt.Errorf("blocklist exceeds %d entries", maxDomainEntries)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+
+	result.Revision = hex.EncodeToString(hasher.Sum(nil))
+	return result, nil
+}
+
+// reject records a rule that failed validation
+func (r *ParseResult) reject(line string) {
+	r.Rejected++
+	if len(r.RejectedSamples) < maxRejectedSamples {
+		r.RejectedSamples = append(r.RejectedSamples, line)
+	}
+}
+
+// parseHostsLine extracts the blocked name from a hosts file line
+// Example: "0.0.0.0 ads.example.com # comment"
+func parseHostsLine(line string) (string, bool) {
+	if strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !hostsSinkholeAddresses[fields[0]] {
+		return "", false
+	}
+	if hostsLocalNames[strings.ToLower(fields[1])] {
+		return "", false
+	}
+
+	return fields[1], true
+}
+
+// parseAdblockLine extracts the blocked domain from an adblock-style rule
+// Only network-level "||domain^" rules apply to DNS; cosmetic and exception rules are skipped
+func parseAdblockLine(line string) (string, bool) {
+	if strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "@@") {
+		return "", false
+	}
+	if strings.Contains(line, "##") || strings.Contains(line, "#@#") {
+		return "", false // Cosmetic rule, not applicable to DNS
+	}
+	if !strings.HasPrefix(line, "||") {
+		return line, true // Unsupported rule syntax, reported as rejected
+	}
+
+	// Modifiers are kept for parseAdblockModifiers ($dnstype)
+	rule := strings.TrimPrefix(line, "||")
+	domain, modifiers, found := strings.Cut(rule, "$")
+	domain = strings.TrimSuffix(domain, "^")
+	if found {
+		return domain + "$" + modifiers, true
+	}
+
+	return domain, true
+}
+
+// parseDomainsLine extracts a domain from a plain one-domain-per-line list
+func parseDomainsLine(line string) (string, bool) {
+	if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+		return "", false
+	}
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+
+	return line, line != ""
+}
+
+// parseCIDRLine extracts an address or prefix from an IP list line
+// Example: "192.0.2.0/24 ; SBL123456" (Spamhaus DROP style)
+func parseCIDRLine(line string) (string, bool) {
+	if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+		return "", false
+	}
+
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ';' || r == '#' || r == ','
+	})
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	return fields[0], true
+}
+
+// parseIPPrefix accepts a CIDR prefix or a bare address (stored as a host prefix)
+func parseIPPrefix(value string) (netip.Prefix, bool) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		prefix = unmapPrefix(prefix)
+		if prefix.Addr().Is4() && prefix.Bits() < minIPv4PrefixBits ||
+			!prefix.Addr().Is4() && prefix.Bits() < minIPv6PrefixBits {
+			return netip.Prefix{}, false
+		}
+		return prefix, true
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
+
+// normalizeDomain lowercases a domain and strips the trailing root dot
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// isValidDomain validates domain syntax per RFC 1035 (underscores allowed for SRV-style labels)
+func isValidDomain(domain string) bool {
+	if len(domain) == 0 || len(domain) > maxDomainLength {
+		return false
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > maxLabelLength {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			ch := label[i]
+			if !(ch >= 'a' && ch <= 'z') && !(ch >= '0' && ch <= '9') && ch != '-' && ch != '_' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
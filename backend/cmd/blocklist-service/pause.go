@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// PAUSE AND TEMPORARY ALLOWS
+// Time-limited overrides kept in memory only and expired by a timer wheel
+// Privacy: Allowed domains are user configuration, never logged or persisted
+// ============================================================================
+
+const (
+	maxPauseDuration     = 24 * time.Hour
+	maxTemporaryAllows   = 1000
+	expiryWheelSlots     = 3600 // One turn per hour at one-second ticks
+	pauseAllKey          = "pause"
+	pauseCategoryPrefix  = "pause:"
+	temporaryAllowPrefix = "allow:"
+)
+
+var (
+	pausedUntil      time.Time // Zero when blocking is not paused
+	pausedCategories = map[string]time.Time{}
+	temporaryAllows  = map[string]time.Time{} // Domain (and subdomains) -> deadline
+	pauseMutex       sync.RWMutex
+	pauseExpiryWheel = newTimerWheel(time.Second, expiryWheelSlots, expirePauseEntry)
+)
+
+// expirePauseEntry removes an override whose deadline has passed
+// Entries rescheduled since are left alone; the wheel holds the new deadline
+func expirePauseEntry(key string) {
+	pauseMutex.Lock()
+	defer pauseMutex.Unlock()
+
+	now := time.Now()
+	switch {
+	case key == pauseAllKey:
+		if !pausedUntil.IsZero() && !now.Before(pausedUntil) {
+			pausedUntil = time.Time{}
+			log.Println("▶️ Blocking pause expired, blocking resumed")
+		}
+	case strings.HasPrefix(key, pauseCategoryPrefix):
+		category := strings.TrimPrefix(key, pauseCategoryPrefix)
+		if deadline, ok := pausedCategories[category]; ok && !now.Before(deadline) {
+			delete(pausedCategories, category)
+			log.Printf("▶️ Pause of category %s expired", category)
+		}
+	case strings.HasPrefix(key, temporaryAllowPrefix):
+		domain := strings.TrimPrefix(key, temporaryAllowPrefix)
+		if deadline, ok := temporaryAllows[domain]; ok && !now.Before(deadline) {
+			delete(temporaryAllows, domain) // Domain never logged
+		}
+	}
+}
+
+// applyPauses unblocks a verdict while blocking or the domain is paused, or while
+// every category listing the domain is; an allowed verdict is marked Paused
+// Deadlines are checked here too so overrides end on time even between wheel ticks
+func applyPauses(domain string, verdict DomainVerdict) DomainVerdict {
+	if !verdict.Blocked {
+		return verdict
+	}
+	method := ""
+	enforced := []string{}
+	for _, category := range verdict.allCategories() {
+		if override := pauseOverride(domain, category); override != "" {
+			method = override
+		} else {
+			enforced = append(enforced, category)
+		}
+	}
+	if len(enforced) == 0 {
+		return DomainVerdict{Method: method, Paused: true}
+	}
+	// Report a category that is still enforced
+	verdict.Category, verdict.categories = enforced[0], enforced
+	return verdict
+}
+
+// pauseOverride returns "paused" or "temporary_allow" when blocking category for
+// domain is currently overridden, or "" when it is not
+// An empty category only matches a pause of all blocking; an empty domain never
+// matches a temporary allow
+func pauseOverride(domain, category string) string {
+	pauseMutex.RLock()
+	defer pauseMutex.RUnlock()
+
+	if pausedUntil.IsZero() && len(pausedCategories) == 0 && len(temporaryAllows) == 0 {
+		return ""
+	}
+	now := time.Now()
+
+	if now.Before(pausedUntil) {
+		return "paused"
+	}
+	if deadline, ok := pausedCategories[category]; ok && category != "" && now.Before(deadline) {
+		return "paused"
+	}
+
+	for suffix := normalizeDomain(domain); suffix != ""; {
+		if deadline, ok := temporaryAllows[suffix]; ok && now.Before(deadline) {
+			return "temporary_allow"
+		}
+		dot := strings.IndexByte(suffix, '.')
+		if dot < 0 {
+			break
+		}
+		suffix = suffix[dot+1:]
+	}
+
+	return ""
+}
+
+// pauseDeadline turns a duration or an RFC3339 deadline into an absolute time
+func pauseDeadline(durationSeconds int, until string) (time.Time, error) {
+	now := time.Now()
+
+	var deadline time.Time
+	switch {
+	case until != "":
+		parsed, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("until must be an RFC3339 time")
+		}
+		deadline = parsed
+	case durationSeconds > 0:
+		deadline = now.Add(time.Duration(durationSeconds) * time.Second)
+	default:
+		return time.Time{}, fmt.Errorf("duration_seconds or until is required")
+	}
+
+	if !deadline.After(now) {
+		return time.Time{}, fmt.Errorf("deadline must be in the future")
+	}
+	if deadline.Sub(now) > maxPauseDuration {
+		return time.Time{}, fmt.Errorf("deadline exceeds maximum of %v", maxPauseDuration)
+	}
+	return deadline, nil
+}
+
+// pauseStatus summarizes the active overrides for health and status endpoints
+// Privacy: Reports the number of temporary allows, not the domains
+func pauseStatus() gin.H {
+	pauseMutex.RLock()
+	defer pauseMutex.RUnlock()
+
+	now := time.Now()
+	until := ""
+	if now.Before(pausedUntil) {
+		until = pausedUntil.UTC().Format(time.RFC3339)
+	}
+
+	categories := gin.H{}
+	for category, deadline := range pausedCategories {
+		if now.Before(deadline) {
+			categories[category] = deadline.UTC().Format(time.RFC3339)
+		}
+	}
+
+	allows := 0
+	for _, deadline := range temporaryAllows {
+		if now.Before(deadline) {
+			allows++
+		}
+	}
+
+	return gin.H{
+		"paused":            until != "",
+		"paused_until":      until,
+		"paused_categories": categories,
+		"temporary_allows":  allows,
+	}
+}
+
+// ============================================================================
+// PAUSE API HANDLERS
+// Pause/resume all blocking or one category, and timed per-domain allows
+// ============================================================================
+
+// handlePause suspends all blocking, or one category, until a deadline
+func handlePause(c *gin.Context) {
+	var request struct {
+		DurationSeconds int    `json:"duration_seconds,omitempty"`
+		Until           string `json:"until,omitempty"`    // RFC3339, alternative to duration_seconds
+		Category        string `json:"category,omitempty"` // Empty pauses all blocking
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	deadline, err := pauseDeadline(request.DurationSeconds, request.Until)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pauseMutex.Lock()
+	if request.Category == "" {
+		pausedUntil = deadline
+		pauseExpiryWheel.Schedule(pauseAllKey, deadline)
+	} else {
+		pausedCategories[request.Category] = deadline
+		pauseExpiryWheel.Schedule(pauseCategoryPrefix+request.Category, deadline)
+	}
+	pauseMutex.Unlock()
+
+	scope := "all blocking"
+	if request.Category != "" {
+		scope = "category " + request.Category
+	}
+	log.Printf("⏸️ Paused %s until %s", scope, deadline.UTC().Format(time.RFC3339))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "paused",
+		"category":  request.Category,
+		"until":     deadline.UTC().Format(time.RFC3339),
+		"pause":     pauseStatus(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleResume ends a pause; without a category it ends every pause
+func handleResume(c *gin.Context) {
+	var request struct {
+		Category string `json:"category,omitempty"`
+	}
+
+	// An empty body resumes everything
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+			return
+		}
+	}
+
+	pauseMutex.Lock()
+	if request.Category == "" {
+		pausedUntil = time.Time{}
+		pauseExpiryWheel.Cancel(pauseAllKey)
+		for category := range pausedCategories {
+			pauseExpiryWheel.Cancel(pauseCategoryPrefix + category)
+		}
+		pausedCategories = map[string]time.Time{}
+	} else {
+		delete(pausedCategories, request.Category)
+		pauseExpiryWheel.Cancel(pauseCategoryPrefix + request.Category)
+	}
+	pauseMutex.Unlock()
+
+	log.Println("▶️ Blocking resumed")
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "resumed",
+		"category":  request.Category,
+		"pause":     pauseStatus(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleTemporaryAllowList returns the unexpired temporary allow entries
+func handleTemporaryAllowList(c *gin.Context) {
+	pauseMutex.RLock()
+	now := time.Now()
+	entries := make([]gin.H, 0, len(temporaryAllows))
+	for domain, deadline := range temporaryAllows {
+		if now.Before(deadline) {
+			entries = append(entries, gin.H{
+				"domain":            domain,
+				"until":             deadline.UTC().Format(time.RFC3339),
+				"remaining_seconds": int(deadline.Sub(now).Seconds()),
+			})
+		}
+	}
+	pauseMutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i]["domain"].(string) < entries[j]["domain"].(string)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":   entries,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleTemporaryAllowAdd allows a domain and its subdomains until a deadline
+// Privacy: The domain is kept in memory only and never logged
+func handleTemporaryAllowAdd(c *gin.Context) {
+	var request struct {
+		Domain          string `json:"domain"`
+		DurationSeconds int    `json:"duration_seconds,omitempty"`
+		Until           string `json:"until,omitempty"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	domain := normalizeDomain(request.Domain)
+	if !isValidDomain(domain) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid domain format"})
+		return
+	}
+
+	deadline, err := pauseDeadline(request.DurationSeconds, request.Until)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pauseMutex.Lock()
+	if _, exists := temporaryAllows[domain]; !exists && len(temporaryAllows) >= maxTemporaryAllows {
+		pauseMutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("temporary allow limit %d reached", maxTemporaryAllows)})
+		return
+	}
+	temporaryAllows[domain] = deadline
+	pauseExpiryWheel.Schedule(temporaryAllowPrefix+domain, deadline)
+	pauseMutex.Unlock()
+
+	log.Printf("✅ Temporary allow added until %s", deadline.UTC().Format(time.RFC3339))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "allowed",
+		"domain":    domain,
+		"until":     deadline.UTC().Format(time.RFC3339),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleTemporaryAllowRemove ends a temporary allow before its deadline
+func handleTemporaryAllowRemove(c *gin.Context) {
+	domain := normalizeDomain(c.Param("domain"))
+
+	pauseMutex.Lock()
+	_, exists := temporaryAllows[domain]
+	delete(temporaryAllows, domain)
+	pauseExpiryWheel.Cancel(temporaryAllowPrefix + domain)
+	pauseMutex.Unlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "temporary allow not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "removed",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setTestPause installs pause state for the duration of a test
+func setTestPause(t *testing.T, until time.Time, categories, allows map[string]time.Time) {
+	t.Helper()
+
+	pauseMutex.Lock()
+	pausedUntil, pausedCategories, temporaryAllows = until, categories, allows
+	pauseMutex.Unlock()
+
+	t.Cleanup(func() {
+		pauseMutex.Lock()
+		pausedUntil, pausedCategories, temporaryAllows = time.Time{}, map[string]time.Time{}, map[string]time.Time{}
+		pauseMutex.Unlock()
+	})
+}
+
+// checkIPs posts a request to handleIPCheck and returns the per-address results
+func checkIPs(t *testing.T, request map[string]interface{}) []struct {
+	Blocked      bool   `json:"blocked"`
+	LookupMethod string `json:"lookup_method"`
+} {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/check-ip", handleIPCheck)
+
+	body, _ := json.Marshal(request)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/check-ip", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		Results []struct {
+			Blocked      bool   `json:"blocked"`
+			LookupMethod string `json:"lookup_method"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Results
+}
+
+func TestIPCheckHonorsPauses(t *testing.T) {
+	sources := []BlocklistSource{{Name: "ranges", Category: "malware", Enabled: true}}
+	installTestVersion(t, sources, compileBlocklistVersion(sources, map[string][]string{},
+		map[string][]netip.Prefix{"ranges": {netip.MustParsePrefix("203.0.113.0/24")}}, map[string][]DNSTypeRule{}))
+
+	soon := time.Now().Add(time.Hour)
+	tests := []struct {
+		name       string
+		until      time.Time
+		categories map[string]time.Time
+		allows     map[string]time.Time
+		domain     string
+		blocked    bool
+		method     string
+	}{
+		{"not paused", time.Time{}, nil, nil, "site.example", true, "ip_tree"},
+		{"all blocking paused", soon, nil, nil, "site.example", false, "paused"},
+		{"category paused", time.Time{}, map[string]time.Time{"malware": soon}, nil, "site.example", false, "paused"},
+		{"other category paused", time.Time{}, map[string]time.Time{"ads": soon}, nil, "site.example", true, "ip_tree"},
+		{"queried name allowed", time.Time{}, nil, map[string]time.Time{"example": soon}, "www.site.example", false, "temporary_allow"},
+		{"allow needs the queried name", time.Time{}, nil, map[string]time.Time{"site.example": soon}, "", true, "ip_tree"},
+		{"expired pause", time.Now().Add(-time.Minute), nil, nil, "site.example", true, "ip_tree"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestPause(t, test.until, test.categories, test.allows)
+
+			results := checkIPs(t, map[string]interface{}{"ips": []string{"203.0.113.7"}, "domain": test.domain})
+			if results[0].Blocked != test.blocked || results[0].LookupMethod != test.method {
+				t.Errorf("result = %+v, want blocked %v via %s", results[0], test.blocked, test.method)
+			}
+		})
+	}
+}
+
+func TestCheckDomainReportsPaused(t *testing.T) {
+	installTestBlocklist(t,
+		[]BlocklistSource{{Name: "base", Category: "ads", Enabled: true}},
+		map[string][]string{"base": {"ads.example"}})
+	setTestPause(t, time.Time{}, map[string]time.Time{"ads": time.Now().Add(time.Hour)},
+		map[string]time.Time{"allowed.example": time.Now().Add(time.Hour)})
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	// A category pause that unblocks the name tells resolvers it was allowed
+	if verdict := checkDomain("ads.example", ""); verdict.Blocked || !verdict.Paused {
+		t.Errorf("ads.example = %+v, want unblocked and paused", verdict)
+	}
+	// A temporary allow covers the resolver's blocklist filters too
+	if verdict := checkDomain("cdn.allowed.example", ""); !verdict.Paused {
+		t.Errorf("cdn.allowed.example = %+v, want paused", verdict)
+	}
+	// Names no paused category lists are not affected
+	if verdict := checkDomain("clean.example", ""); verdict.Paused {
+		t.Errorf("clean.example = %+v, want not paused", verdict)
+	}
+}
+
+func TestCategoryPauseNeedsEveryCategory(t *testing.T) {
+	installTestBlocklist(t,
+		[]BlocklistSource{
+			{Name: "social", Category: "social", Enabled: true, Priority: 1},
+			{Name: "ads", Category: "ads", Enabled: true, Priority: 2},
+		},
+		map[string][]string{
+			"social": {"feed.example", "widgets.example"},
+			"ads":    {"widgets.example"},
+		})
+
+	tests := []struct {
+		name     string
+		paused   []string
+		domain   string
+		blocked  bool
+		category string
+	}{
+		{"social paused, only social lists it", []string{"social"}, "feed.example", false, ""},
+		{"social paused, ads still lists it", []string{"social"}, "widgets.example", true, "ads"},
+		{"both paused", []string{"social", "ads"}, "widgets.example", false, ""},
+		{"ads paused, social still lists it", []string{"ads"}, "widgets.example", true, "social"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			categories := map[string]time.Time{}
+			for _, category := range test.paused {
+				categories[category] = time.Now().Add(time.Hour)
+			}
+			setTestPause(t, time.Time{}, categories, nil)
+
+			mutex.RLock()
+			verdict := checkDomain(test.domain, "")
+			mutex.RUnlock()
+			if verdict.Blocked != test.blocked || verdict.Category != test.category || verdict.Paused == test.blocked {
+				t.Errorf("checkDomain(%s) = %+v, want blocked %v category %q", test.domain, verdict, test.blocked, test.category)
+			}
+		})
+	}
+}
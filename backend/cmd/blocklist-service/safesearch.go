@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// SAFE SEARCH ENFORCEMENT
+// Rewrites search engine and YouTube names to their safe-search CNAME targets
+// Privacy: Names are matched in memory only and never logged
+// ============================================================================
+
+// SafeSearchEngine maps an engine's hostnames to its safe-search target
+type SafeSearchEngine struct {
+	Name    string            `json:"name"`
+	Target  string            `json:"target"` // CNAME target enforcing safe search
+	Hosts   []string          `json:"hosts"`  // Exact hostnames rewritten to Target
+	matches func(string) bool // Extra matcher for hosts that cannot be listed (e.g. Google ccTLDs)
+	targets map[string]string // Alternative targets by level (YouTube moderate/strict)
+}
+
+// SafeSearchConfig holds the runtime safe-search settings
+type SafeSearchConfig struct {
+	Mode         string          `json:"mode"`          // "off" or "on"
+	Engines      map[string]bool `json:"engines"`       // Engine name -> enforced
+	YouTubeLevel string          `json:"youtube_level"` // "strict" or "moderate"
+}
+
+// safeSearchEngines is the per-engine mapping table
+var safeSearchEngines = []SafeSearchEngine{
+	{
+		Name:    "google",
+		Target:  "forcesafesearch.google.com",
+		Hosts:   []string{"google.com", "www.google.com"},
+		matches: isGoogleSearchHost,
+	},
+	{
+		Name:   "bing",
+		Target: "strict.bing.com",
+		Hosts:  []string{"bing.com", "www.bing.com"},
+	},
+	{
+		Name:   "duckduckgo",
+		Target: "safe.duckduckgo.com",
+		Hosts:  []string{"duckduckgo.com", "www.duckduckgo.com", "start.duckduckgo.com", "html.duckduckgo.com"},
+	},
+	{
+		Name:   "youtube",
+		Target: "restrict.youtube.com",
+		Hosts: []string{
+			"youtube.com", "www.youtube.com", "m.youtube.com",
+			"youtubei.googleapis.com", "youtube.googleapis.com", "www.youtube-nocookie.com",
+		},
+		targets: map[string]string{
+			"strict":   "restrict.youtube.com",
+			"moderate": "restrictmoderate.youtube.com",
+		},
+	},
+}
+
+var (
+	safeSearchConfig = SafeSearchConfig{
+		Mode:         safeSearchModeFromEnv(),
+		Engines:      map[string]bool{"google": true, "bing": true, "duckduckgo": true, "youtube": true},
+		YouTubeLevel: envOrDefault("SAFE_SEARCH_YOUTUBE", "strict"),
+	}
+	safeSearchMutex sync.RWMutex
+)
+
+// safeSearchModeFromEnv reads SAFE_SEARCH; like the dns-service, only "on" enables rewrites
+func safeSearchModeFromEnv() string {
+	if os.Getenv("SAFE_SEARCH") == "on" {
+		return "on"
+	}
+	return "off"
+}
+
+// isGoogleSearchHost matches Google's country domains: [www.]google.<tld>, google.co.<cc>, google.com.<cc>
+func isGoogleSearchHost(host string) bool {
+	host = strings.TrimPrefix(host, "www.")
+	rest, found := strings.CutPrefix(host, "google.")
+	if !found || rest == "" {
+		return false
+	}
+
+	labels := strings.Split(rest, ".")
+	switch len(labels) {
+	case 1:
+		return len(labels[0]) >= 2
+	case 2:
+		return (labels[0] == "co" || labels[0] == "com") && len(labels[1]) == 2
+	default:
+		return false
+	}
+}
+
+// safeSearchTarget returns the safe-search CNAME target and engine for a host, or "" when none applies
+func safeSearchTarget(host string) (string, string) {
+	safeSearchMutex.RLock()
+	defer safeSearchMutex.RUnlock()
+
+	if safeSearchConfig.Mode != "on" {
+		return "", ""
+	}
+
+	host = normalizeDomain(host)
+	for _, engine := range safeSearchEngines {
+		if !safeSearchConfig.Engines[engine.Name] || !engineMatches(engine, host) {
+			continue
+		}
+		if target, ok := engine.targets[safeSearchConfig.YouTubeLevel]; ok {
+			return target, engine.Name
+		}
+		return engine.Target, engine.Name
+	}
+	return "", ""
+}
+
+// engineMatches reports whether a host belongs to an engine
+func engineMatches(engine SafeSearchEngine, host string) bool {
+	for _, candidate := range engine.Hosts {
+		if host == candidate {
+			return true
+		}
+	}
+	return engine.matches != nil && engine.matches(host)
+}
+
+// currentSafeSearchConfig returns a copy of the safe-search settings
+func currentSafeSearchConfig() SafeSearchConfig {
+	safeSearchMutex.RLock()
+	defer safeSearchMutex.RUnlock()
+
+	config := safeSearchConfig
+	config.Engines = make(map[string]bool, len(safeSearchConfig.Engines))
+	for name, enabled := range safeSearchConfig.Engines {
+		config.Engines[name] = enabled
+	}
+	return config
+}
+
+// ============================================================================
+// SAFE SEARCH API HANDLERS
+// Mode, per-engine switches and the mapping table
+// ============================================================================
+
+// handleSafeSearchConfig returns the settings and the mapping table
+func handleSafeSearchConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"config":    currentSafeSearchConfig(),
+		"engines":   safeSearchEngines,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleSafeSearchConfigUpdate changes the mode, engine switches or YouTube level
+func handleSafeSearchConfigUpdate(c *gin.Context) {
+	config := currentSafeSearchConfig()
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if config.Mode != "off" && config.Mode != "on" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be off or on"})
+		return
+	}
+	if config.YouTubeLevel != "strict" && config.YouTubeLevel != "moderate" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "youtube_level must be strict or moderate"})
+		return
+	}
+	for name := range config.Engines {
+		known := false
+		for _, engine := range safeSearchEngines {
+			known = known || engine.Name == name
+		}
+		if !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown engine: " + name})
+			return
+		}
+	}
+
+	safeSearchMutex.Lock()
+	safeSearchConfig = config
+	safeSearchMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "updated",
+		"config":    config,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+// setTestSafeSearch installs safe-search settings for the duration of a test
+func setTestSafeSearch(t *testing.T, config SafeSearchConfig) {
+	t.Helper()
+
+	previous := currentSafeSearchConfig()
+	safeSearchMutex.Lock()
+	safeSearchConfig = config
+	safeSearchMutex.Unlock()
+
+	t.Cleanup(func() {
+		safeSearchMutex.Lock()
+		safeSearchConfig = previous
+		safeSearchMutex.Unlock()
+	})
+}
+
+func TestSafeSearchTargetPerEngine(t *testing.T) {
+	allEngines := map[string]bool{"google": true, "bing": true, "duckduckgo": true, "youtube": true}
+	setTestSafeSearch(t, SafeSearchConfig{Mode: "on", Engines: allEngines, YouTubeLevel: "strict"})
+
+	tests := []struct {
+		host, target, engine string
+	}{
+		{"google.com", "forcesafesearch.google.com", "google"},
+		{"www.google.com", "forcesafesearch.google.com", "google"},
+		{"www.google.de", "forcesafesearch.google.com", "google"},
+		{"google.co.uk", "forcesafesearch.google.com", "google"},
+		{"www.google.com.au", "forcesafesearch.google.com", "google"},
+		{"WWW.Google.com.", "forcesafesearch.google.com", "google"},
+		{"bing.com", "strict.bing.com", "bing"},
+		{"www.bing.com", "strict.bing.com", "bing"},
+		{"duckduckgo.com", "safe.duckduckgo.com", "duckduckgo"},
+		{"html.duckduckgo.com", "safe.duckduckgo.com", "duckduckgo"},
+		{"www.youtube.com", "restrict.youtube.com", "youtube"},
+		{"youtubei.googleapis.com", "restrict.youtube.com", "youtube"},
+		{"www.youtube-nocookie.com", "restrict.youtube.com", "youtube"},
+		// Not search front ends
+		{"mail.google.com", "", ""},
+		{"google.evil.example", "", ""},
+		{"api.bing.com", "", ""},
+		{"forcesafesearch.google.com", "", ""},
+	}
+	for _, test := range tests {
+		target, engine := safeSearchTarget(test.host)
+		if target != test.target || engine != test.engine {
+			t.Errorf("safeSearchTarget(%s) = %q, %q; want %q, %q", test.host, target, engine, test.target, test.engine)
+		}
+	}
+}
+
+func TestSafeSearchTargetSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SafeSearchConfig
+		host   string
+		target string
+	}{
+		{"mode off", SafeSearchConfig{Mode: "off", Engines: map[string]bool{"bing": true}}, "bing.com", ""},
+		{"engine disabled", SafeSearchConfig{Mode: "on", Engines: map[string]bool{"bing": false, "google": true}}, "bing.com", ""},
+		{"other engine still enforced", SafeSearchConfig{Mode: "on", Engines: map[string]bool{"bing": false, "google": true}}, "google.com", "forcesafesearch.google.com"},
+		{"youtube moderate", SafeSearchConfig{Mode: "on", Engines: map[string]bool{"youtube": true}, YouTubeLevel: "moderate"}, "m.youtube.com", "restrictmoderate.youtube.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestSafeSearch(t, test.config)
+			if target, _ := safeSearchTarget(test.host); target != test.target {
+				t.Errorf("safeSearchTarget(%s) = %q, want %q", test.host, target, test.target)
+			}
+		})
+	}
+}
+
+func TestSafeSearchModeFromEnv(t *testing.T) {
+	for value, want := range map[string]string{"": "off", "off": "off", "on": "on", "true": "off"} {
+		t.Setenv("SAFE_SEARCH", value)
+		if mode := safeSearchModeFromEnv(); mode != want {
+			t.Errorf("SAFE_SEARCH=%q gives mode %q, want %q", value, mode, want)
+		}
+	}
+}
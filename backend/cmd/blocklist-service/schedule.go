@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============================================================================
+// SCHEDULED BLOCKING
+// Categories or rule sets that are only enforced inside weekly time windows
+// ============================================================================
+
+const (
+	maxSchedules       = 64
+	maxScheduleDomains = 1000
+	categoryScheduled  = "scheduled"
+)
+
+// scheduleClock returns the current time; tests replace it to evaluate schedules at fixed instants
+var scheduleClock = time.Now
+
+// weekdayNames maps API day names to time.Weekday
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Schedule restricts blocking of categories or domains to weekly time windows
+// Example: social and video blocked Mon-Fri 09:00-17:00 Europe/Berlin
+type Schedule struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Days       []string `json:"days"`       // "mon".."sun"
+	Start      string   `json:"start"`      // "HH:MM", inclusive
+	End        string   `json:"end"`        // "HH:MM", exclusive; earlier than start crosses midnight
+	TimeZone   string   `json:"time_zone"`  // IANA name, e.g. "Europe/Berlin"
+	Categories []string `json:"categories"` // Blocklist categories only enforced inside the window
+	Domains    []string `json:"domains"`    // Extra domains (and subdomains) blocked inside the window
+}
+
+// compiledSchedule is a validated schedule ready for cheap evaluation
+type compiledSchedule struct {
+	Schedule
+	days     [7]bool
+	start    int // Minutes after midnight
+	end      int
+	location *time.Location
+}
+
+var (
+	// schedules by ID, plus indexes used on the lookup path
+	schedules           = map[string]*compiledSchedule{}
+	scheduledCategories = map[string][]*compiledSchedule{}
+	scheduledDomains    = map[string][]*compiledSchedule{}
+	scheduleMutex       sync.RWMutex
+)
+
+// parseClockTime parses "HH:MM" into minutes after midnight
+func parseClockTime(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// compileSchedule validates a schedule and resolves its time zone
+func compileSchedule(schedule Schedule) (*compiledSchedule, error) {
+	if schedule.ID == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+	if len(schedule.Categories) == 0 && len(schedule.Domains) == 0 {
+		return nil, fmt.Errorf("schedule needs categories or domains")
+	}
+	if len(schedule.Domains) > maxScheduleDomains {
+		return nil, fmt.Errorf("schedule exceeds %d domains", maxScheduleDomains)
+	}
+
+	compiled := &compiledSchedule{Schedule: schedule}
+	if len(schedule.Days) == 0 {
+		return nil, fmt.Errorf("days are required")
+	}
+	for _, day := range schedule.Days {
+		weekday, ok := weekdayNames[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", day)
+		}
+		compiled.days[weekday] = true
+	}
+
+	var err error
+	if compiled.start, err = parseClockTime(schedule.Start); err != nil {
+		return nil, err
+	}
+	if compiled.end, err = parseClockTime(schedule.End); err != nil {
+		return nil, err
+	}
+	if compiled.start == compiled.end {
+		return nil, fmt.Errorf("start and end must differ")
+	}
+
+	if schedule.TimeZone == "" {
+		schedule.TimeZone = "UTC"
+		compiled.TimeZone = "UTC"
+	}
+	if compiled.location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", schedule.TimeZone)
+	}
+
+	for i, domain := range schedule.Domains {
+		domain = normalizeDomain(domain)
+		if !isValidDomain(domain) {
+			return nil, fmt.Errorf("invalid domain at index %d", i)
+		}
+		compiled.Domains[i] = domain
+	}
+
+	return compiled, nil
+}
+
+// activeAt reports whether the schedule window contains t
+// Windows that cross midnight belong to the day they start on
+func (s *compiledSchedule) activeAt(t time.Time) bool {
+	local := t.In(s.location)
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+
+	if s.start < s.end {
+		return s.days[day] && minute >= s.start && minute < s.end
+	}
+	previous := (day + 6) % 7
+	return (s.days[day] && minute >= s.start) || (s.days[previous] && minute < s.end)
+}
+
+// rebuildScheduleIndexes recomputes the category and domain indexes
+// Caller must hold scheduleMutex for writing
+func rebuildScheduleIndexes() {
+	scheduledCategories = map[string][]*compiledSchedule{}
+	scheduledDomains = map[string][]*compiledSchedule{}
+	for _, schedule := range schedules {
+		for _, category := range schedule.Categories {
+			scheduledCategories[category] = append(scheduledCategories[category], schedule)
+		}
+		for _, domain := range schedule.Domains {
+			scheduledDomains[domain] = append(scheduledDomains[domain], schedule)
+		}
+	}
+}
+
+// firstActive returns the first schedule active at now, or nil
+func firstActive(candidates []*compiledSchedule, now time.Time) *compiledSchedule {
+	for _, schedule := range candidates {
+		if schedule.activeAt(now) {
+			return schedule
+		}
+	}
+	return nil
+}
+
+// applySchedules gates scheduled categories and adds scheduled domains to a verdict
+// Names and categories without schedules cost one map lookup per label
+func applySchedules(domain string, verdict DomainVerdict) DomainVerdict {
+	scheduleMutex.RLock()
+	defer scheduleMutex.RUnlock()
+
+	if len(schedules) == 0 {
+		return verdict
+	}
+	now := scheduleClock()
+
+	// Scheduled categories are only enforced inside their window; a name listed by
+	// several categories stays blocked while any of them is enforced
+	if verdict.Blocked {
+		enforced := []string{}
+		schedule, gatedBy := "", ""
+		for _, category := range verdict.allCategories() {
+			candidates, scheduled := scheduledCategories[category]
+			if !scheduled {
+				enforced = append(enforced, category)
+				continue
+			}
+			if active := firstActive(candidates, now); active != nil {
+				if len(enforced) == 0 {
+					schedule = active.ID
+				}
+				enforced = append(enforced, category)
+			} else if gatedBy == "" {
+				gatedBy = candidates[0].ID
+			}
+		}
+		if len(enforced) == 0 {
+			return DomainVerdict{Method: "schedule", Schedule: gatedBy}
+		}
+		verdict.Category, verdict.Schedule, verdict.categories = enforced[0], schedule, enforced
+		return verdict
+	}
+
+	// Scheduled rule sets block extra domains inside their window
+	if len(scheduledDomains) > 0 {
+		for suffix := domain; suffix != ""; {
+			if active := firstActive(scheduledDomains[suffix], now); active != nil {
+				return DomainVerdict{Blocked: true, Category: categoryScheduled, Method: "schedule", Schedule: active.ID}
+			}
+			dot := strings.IndexByte(suffix, '.')
+			if dot < 0 {
+				break
+			}
+			suffix = suffix[dot+1:]
+		}
+	}
+
+	return verdict
+}
+
+// activeScheduleIDs lists schedules active at t, sorted by ID
+// Caller must hold scheduleMutex for reading
+func activeScheduleIDs(t time.Time) []string {
+	active := []string{}
+	for id, schedule := range schedules {
+		if schedule.activeAt(t) {
+			active = append(active, id)
+		}
+	}
+	sort.Strings(active)
+	return active
+}
+
+// ============================================================================
+// SCHEDULE API HANDLERS
+// Schedule management and live state, system configuration only
+// ============================================================================
+
+// handleScheduleList returns all schedules and which are active now (or at ?at=RFC3339)
+func handleScheduleList(c *gin.Context) {
+	at := scheduleClock()
+	if value := c.Query("at"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at must be an RFC3339 time"})
+			return
+		}
+		at = parsed
+	}
+
+	scheduleMutex.RLock()
+	defer scheduleMutex.RUnlock()
+
+	list := make([]gin.H, 0, len(schedules))
+	for _, schedule := range schedules {
+		list = append(list, gin.H{
+			"schedule": schedule.Schedule,
+			"active":   schedule.activeAt(at),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["schedule"].(Schedule).ID < list[j]["schedule"].(Schedule).ID
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedules":    list,
+		"active_now":   activeScheduleIDs(at),
+		"evaluated_at": at.UTC().Format(time.RFC3339),
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleScheduleUpsert creates or replaces a schedule by ID
+func handleScheduleUpsert(c *gin.Context) {
+	var schedule Schedule
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	compiled, err := compileSchedule(schedule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scheduleMutex.Lock()
+	_, exists := schedules[compiled.ID]
+	if !exists && len(schedules) >= maxSchedules {
+		scheduleMutex.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("schedule limit %d reached", maxSchedules)})
+		return
+	}
+	schedules[compiled.ID] = compiled
+	rebuildScheduleIndexes()
+	active := compiled.activeAt(scheduleClock())
+	scheduleMutex.Unlock()
+
+	log.Printf("🗓️ Schedule %s saved: %d categories, %d domains", compiled.ID, len(compiled.Categories), len(compiled.Domains))
+
+	status := http.StatusCreated
+	if exists {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{
+		"schedule":  compiled.Schedule,
+		"active":    active,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleScheduleDelete removes a schedule; its categories are enforced around the clock again
+func handleScheduleDelete(c *gin.Context) {
+	id := c.Param("id")
+
+	scheduleMutex.Lock()
+	_, exists := schedules[id]
+	if exists {
+		delete(schedules, id)
+		rebuildScheduleIndexes()
+	}
+	scheduleMutex.Unlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("schedule %s not found", id)})
+		return
+	}
+
+	log.Printf("🗓️ Schedule %s deleted", id)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "deleted",
+		"id":        id,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
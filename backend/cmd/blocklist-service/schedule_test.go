@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// installTestSchedules compiles schedules into the live indexes and fixes the clock
+func installTestSchedules(t *testing.T, now *time.Time, list ...Schedule) {
+	t.Helper()
+
+	scheduleMutex.Lock()
+	previous := schedules
+	schedules = map[string]*compiledSchedule{}
+	for _, schedule := range list {
+		compiled, err := compileSchedule(schedule)
+		if err != nil {
+			scheduleMutex.Unlock()
+			t.Fatalf("compileSchedule(%s): %v", schedule.ID, err)
+		}
+		schedules[schedule.ID] = compiled
+	}
+	rebuildScheduleIndexes()
+	scheduleMutex.Unlock()
+	scheduleClock = func() time.Time { return *now }
+
+	t.Cleanup(func() {
+		scheduleMutex.Lock()
+		schedules = previous
+		rebuildScheduleIndexes()
+		scheduleMutex.Unlock()
+		scheduleClock = time.Now
+	})
+}
+
+func TestApplySchedules(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	var now time.Time
+	installTestSchedules(t, &now,
+		// Friday night window crossing midnight into Saturday
+		Schedule{ID: "night", Days: []string{"fri"}, Start: "22:00", End: "06:00", TimeZone: "Europe/Berlin", Categories: []string{"gaming"}},
+		// Weekday school hours for extra domains
+		Schedule{ID: "school", Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "15:00", TimeZone: "Europe/Berlin", Domains: []string{"games.example"}},
+	)
+
+	gaming := DomainVerdict{Blocked: true, Category: "gaming", Method: "hash_table"}
+	tests := []struct {
+		name     string
+		at       time.Time
+		domain   string
+		verdict  DomainVerdict
+		blocked  bool
+		schedule string
+	}{
+		// 2026-10-16 is a Friday
+		{"before night window", time.Date(2026, 10, 16, 21, 59, 0, 0, berlin), "play.example", gaming, false, "night"},
+		{"night window start inclusive", time.Date(2026, 10, 16, 22, 0, 0, 0, berlin), "play.example", gaming, true, "night"},
+		{"after midnight into saturday", time.Date(2026, 10, 17, 0, 30, 0, 0, berlin), "play.example", gaming, true, "night"},
+		{"night window end exclusive", time.Date(2026, 10, 17, 6, 0, 0, 0, berlin), "play.example", gaming, false, "night"},
+		{"saturday night not scheduled", time.Date(2026, 10, 17, 23, 0, 0, 0, berlin), "play.example", gaming, false, "night"},
+		{"thursday spill-over not scheduled", time.Date(2026, 10, 16, 1, 0, 0, 0, berlin), "play.example", gaming, false, "night"},
+		{"same instant in UTC", time.Date(2026, 10, 16, 20, 30, 0, 0, time.UTC), "play.example", gaming, true, "night"},
+		{"school hours friday", time.Date(2026, 10, 16, 8, 0, 0, 0, berlin), "www.games.example", DomainVerdict{}, true, "school"},
+		{"school over", time.Date(2026, 10, 16, 15, 0, 0, 0, berlin), "games.example", DomainVerdict{}, false, ""},
+		{"sunday no school", time.Date(2026, 10, 18, 10, 0, 0, 0, berlin), "games.example", DomainVerdict{}, false, ""},
+		{"monday school", time.Date(2026, 10, 19, 10, 0, 0, 0, berlin), "games.example", DomainVerdict{}, true, "school"},
+		{"unscheduled category untouched", time.Date(2026, 10, 18, 10, 0, 0, 0, berlin), "ads.example",
+			DomainVerdict{Blocked: true, Category: "ads", Method: "hash_table"}, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = test.at
+			verdict := applySchedules(test.domain, test.verdict)
+			if verdict.Blocked != test.blocked || verdict.Schedule != test.schedule {
+				t.Errorf("applySchedules = blocked %v schedule %q, want blocked %v schedule %q",
+					verdict.Blocked, verdict.Schedule, test.blocked, test.schedule)
+			}
+		})
+	}
+}
+
+func TestScheduledCategoryListedElsewhere(t *testing.T) {
+	var now time.Time
+	installTestSchedules(t, &now,
+		Schedule{ID: "evenings", Days: []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}, Start: "18:00", End: "22:00", TimeZone: "UTC", Categories: []string{"gaming"}},
+	)
+
+	// The scheduled list has priority, so shared names carry its category
+	installTestBlocklist(t,
+		[]BlocklistSource{
+			{Name: "games", Category: "gaming", Enabled: true, Priority: 1},
+			{Name: "ads", Category: "ads", Enabled: true, Priority: 2},
+		},
+		map[string][]string{
+			"games": {"play.example", "arcade.example"},
+			"ads":   {"play.example", "tracker.arcade.example"},
+		})
+
+	tests := []struct {
+		name     string
+		hour     int
+		domain   string
+		blocked  bool
+		category string
+		schedule string
+	}{
+		{"both enforced", 19, "play.example", true, "gaming", "evenings"},
+		{"ads list stays on outside the window", 10, "play.example", true, "ads", ""},
+		{"parent zone gated, listed subdomain enforced", 10, "tracker.arcade.example", true, "ads", ""},
+		{"only the scheduled list", 10, "arcade.example", false, "", "evenings"},
+		{"only the scheduled list inside the window", 19, "arcade.example", true, "gaming", "evenings"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = time.Date(2026, 10, 16, test.hour, 0, 0, 0, time.UTC)
+			mutex.RLock()
+			verdict := checkDomain(test.domain, "")
+			mutex.RUnlock()
+			if verdict.Blocked != test.blocked || verdict.Category != test.category || verdict.Schedule != test.schedule {
+				t.Errorf("checkDomain = blocked %v category %q schedule %q, want blocked %v category %q schedule %q",
+					verdict.Blocked, verdict.Category, verdict.Schedule, test.blocked, test.category, test.schedule)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// TIMER WHEEL
+// Hashed timing wheel for expiring in-memory entries with one ticker
+// ============================================================================
+
+// timerWheel schedules keys for expiry in fixed-size slots
+// Adding and removing are O(1); each tick only visits one slot
+type timerWheel struct {
+	tick     time.Duration
+	slots    []map[string]int // Key -> full wheel turns left before expiry
+	position int
+	index    map[string]int // Key -> slot, for O(1) removal and rescheduling
+	onExpire func(key string)
+	mutex    sync.Mutex
+}
+
+// newTimerWheel creates a wheel covering slots*tick per turn
+// onExpire runs outside the wheel lock and may take other locks
+func newTimerWheel(tick time.Duration, slots int, onExpire func(key string)) *timerWheel {
+	wheel := &timerWheel{
+		tick:     tick,
+		slots:    make([]map[string]int, slots),
+		index:    make(map[string]int),
+		onExpire: onExpire,
+	}
+	for i := range wheel.slots {
+		wheel.slots[i] = make(map[string]int)
+	}
+	return wheel
+}
+
+// Schedule sets (or moves) the expiry of key to deadline
+func (w *timerWheel) Schedule(key string, deadline time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.removeLocked(key)
+
+	// Round up, plus one for the partly elapsed current tick, so entries never expire early
+	ticks := int((time.Until(deadline)+w.tick-1)/w.tick) + 1
+	if ticks < 1 {
+		ticks = 1
+	}
+	slot := (w.position + ticks) % len(w.slots)
+	w.slots[slot][key] = (ticks - 1) / len(w.slots)
+	w.index[key] = slot
+}
+
+// Cancel removes a pending expiry
+func (w *timerWheel) Cancel(key string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.removeLocked(key)
+}
+
+// removeLocked drops key from its slot; caller holds w.mutex
+func (w *timerWheel) removeLocked(key string) {
+	if slot, ok := w.index[key]; ok {
+		delete(w.slots[slot], key)
+		delete(w.index, key)
+	}
+}
+
+// advance moves the wheel one slot and expires due entries
+func (w *timerWheel) advance() {
+	w.mutex.Lock()
+	w.position = (w.position + 1) % len(w.slots)
+	slot := w.slots[w.position]
+
+	var expired []string
+	for key, rounds := range slot {
+		if rounds > 0 {
+			slot[key] = rounds - 1
+			continue
+		}
+		delete(slot, key)
+		delete(w.index, key)
+		expired = append(expired, key)
+	}
+	w.mutex.Unlock()
+
+	for _, key := range expired {
+		w.onExpire(key)
+	}
+}
+
+// Run advances the wheel every tick for the lifetime of the service
+func (w *timerWheel) Run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		w.advance()
+	}
+}
+
+// Len returns the number of pending expiries
+func (w *timerWheel) Len() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.index)
+}
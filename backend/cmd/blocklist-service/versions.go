@@ -0,0 +1,587 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"shroudinger/backend/internal/models"
+)
+
+// ============================================================================
+// BLOCKLIST VERSION HISTORY
+// Compiled blocklists are immutable snapshots kept in memory for instant rollback
+// ============================================================================
+
+const (
+	defaultBlocklistVersions = 3    // Compiled versions kept when BLOCKLIST_VERSIONS is unset
+	versionLimitCeiling      = 10   // Upper bound on stored versions (memory target)
+	minBloomFilterEntries    = 1024 // Lower bound when sizing a version's bloom filter
+)
+
+// maxBlocklistVersions is how many compiled versions stay in memory for rollback
+// (BLOCKLIST_VERSIONS, 1 to versionLimitCeiling)
+var maxBlocklistVersions = func() int {
+	if count, err := strconv.Atoi(os.Getenv("BLOCKLIST_VERSIONS")); err == nil && count >= 1 && count <= versionLimitCeiling {
+		return count
+	}
+	return defaultBlocklistVersions
+}()
+
+// updateMutex serializes blocklist updates so versions are compiled one at a time
+var updateMutex sync.Mutex
+
+// UpdateProgress describes the running or most recent blocklist update
+type UpdateProgress struct {
+	Trigger      string
+	Running      bool
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	SourcesDone  int
+	SourcesTotal int
+	Version      int // Version compiled by the update, 0 while running
+}
+
+var (
+	updateProgress    UpdateProgress
+	updateProgressMux sync.Mutex
+)
+
+// beginUpdateProgress records the start of an update over total sources
+func beginUpdateProgress(trigger string, total int) {
+	updateProgressMux.Lock()
+	defer updateProgressMux.Unlock()
+	updateProgress = UpdateProgress{Trigger: trigger, Running: true, StartedAt: time.Now(), SourcesTotal: total}
+}
+
+// advanceUpdateProgress counts one more source as fetched
+func advanceUpdateProgress() {
+	updateProgressMux.Lock()
+	defer updateProgressMux.Unlock()
+	updateProgress.SourcesDone++
+}
+
+// finishUpdateProgress records the version an update produced
+func finishUpdateProgress(version int) {
+	updateProgressMux.Lock()
+	defer updateProgressMux.Unlock()
+	updateProgress.Running = false
+	updateProgress.FinishedAt = time.Now()
+	updateProgress.Version = version
+}
+
+// updateProgressStatus reports the current update for the status endpoint
+func updateProgressStatus() gin.H {
+	updateProgressMux.Lock()
+	defer updateProgressMux.Unlock()
+
+	if updateProgress.StartedAt.IsZero() {
+		return gin.H{"running": false}
+	}
+	status := gin.H{
+		"trigger":       updateProgress.Trigger,
+		"running":       updateProgress.Running,
+		"started_at":    updateProgress.StartedAt.Format(time.RFC3339),
+		"sources_done":  updateProgress.SourcesDone,
+		"sources_total": updateProgress.SourcesTotal,
+	}
+	if !updateProgress.Running {
+		status["finished_at"] = updateProgress.FinishedAt.Format(time.RFC3339)
+		status["version"] = updateProgress.Version
+	}
+	return status
+}
+
+// BlocklistVersion is one compiled, immutable blocklist snapshot
+// Rollback swaps the active data structures to a stored version without re-fetching
+type BlocklistVersion struct {
+	ID             int
+	CreatedAt      time.Time
+	Trigger        string // "startup", "scheduled", "reload", "subscribe", ...
+	Sources        []SourceRevision
+	Results        []models.BlocklistUpdateResult
+	TotalDomains   int64
+	TotalIPRanges  int
+	TotalTypeRules int
+
+	// Compiled data structures, never mutated after compilation
+	domainTrie      *DomainTrie
+	bloomFilter     *BloomFilter
+	exactDomains    map[string]string   // Domain -> category of the highest priority source listing it
+	extraCategories map[string][]string // Domain -> other categories listing it, in priority order
+	ipTree          *IPPrefixTree
+	typeRules       map[string][]typedRuleEntry // Domain -> $dnstype rules, one per source in priority order
+	sourceDomains   map[string][]string       // Source name -> parsed domains, reused when a source is unchanged
+	sourcePrefixes  map[string][]netip.Prefix // Source name -> parsed IP prefixes (cidr sources)
+	sourceTypeRules map[string][]DNSTypeRule  // Source name -> parsed $dnstype rules (adblock sources)
+}
+
+// SourceRevision identifies the content a source contributed to a version
+type SourceRevision struct {
+	Name         string
+	Revision     string // SHA-256 of the raw list content
+	ETag         string
+	LastModified string
+	EntryCount   int
+	FetchedAt    time.Time
+}
+
+// countEnabledSources returns how many sources are enabled
+func countEnabledSources(sources []BlocklistSource) int {
+	enabled := 0
+	for _, source := range sources {
+		if source.Enabled {
+			enabled++
+		}
+	}
+	return enabled
+}
+
+// sourcesByPriority returns a copy of sources ordered by catalog priority (1 wins first)
+// Sources with equal priority keep their configured order
+func sourcesByPriority(sources []BlocklistSource) []BlocklistSource {
+	ordered := append([]BlocklistSource(nil), sources...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority < ordered[j].Priority
+	})
+	return ordered
+}
+
+// compileBlocklistVersion builds fresh data structures from per-source domains, prefixes and typed rules
+// Entries listed by several sources take the category of the highest priority source;
+// the other categories are kept for schedules and pauses
+func compileBlocklistVersion(sources []BlocklistSource, sourceDomains map[string][]string, sourcePrefixes map[string][]netip.Prefix, sourceTypeRules map[string][]DNSTypeRule) *BlocklistVersion {
+	sources = sourcesByPriority(sources)
+
+	total := 0
+	for _, source := range sources {
+		if source.Enabled {
+			total += len(sourceDomains[source.Name])
+		}
+	}
+
+	expected := total
+	if expected < minBloomFilterEntries {
+		expected = minBloomFilterEntries
+	}
+
+	version := &BlocklistVersion{
+		CreatedAt:       time.Now(),
+		domainTrie:      NewDomainTrie(),
+		bloomFilter:     NewBloomFilter(expected, bloomFilterFalsePositiveRate),
+		exactDomains:    make(map[string]string, total),
+		extraCategories: make(map[string][]string),
+		ipTree:          NewIPPrefixTree(),
+		typeRules:       make(map[string][]typedRuleEntry),
+		sourceDomains:   sourceDomains,
+		sourcePrefixes:  sourcePrefixes,
+		sourceTypeRules: sourceTypeRules,
+	}
+
+	for _, source := range sources {
+		if !source.Enabled {
+			continue
+		}
+		for _, domain := range sourceDomains[source.Name] {
+			if primary, exists := version.exactDomains[domain]; exists {
+				// Already added by a higher priority source; schedules and pauses
+				// still need to know this category lists the domain too
+				if primary != source.Category {
+					version.extraCategories[domain] = appendCategory(version.extraCategories[domain], source.Category)
+				}
+				continue
+			}
+			version.exactDomains[domain] = source.Category
+			version.domainTrie.Add(domain, source.Category)
+			version.bloomFilter.Add(domain)
+		}
+		for _, prefix := range sourcePrefixes[source.Name] {
+			version.ipTree.Add(prefix, source.Category)
+		}
+		for _, rule := range sourceTypeRules[source.Name] {
+			version.typeRules[rule.Domain] = append(version.typeRules[rule.Domain], typedRuleEntry{rule: rule, category: source.Category})
+			version.TotalTypeRules++
+		}
+	}
+
+	version.TotalDomains = int64(len(version.exactDomains))
+	version.TotalIPRanges = version.ipTree.Len()
+	return version
+}
+
+// matcher returns the compiled structures of a version for checkDomainWith
+func (version *BlocklistVersion) matcher() blocklistMatcher {
+	return blocklistMatcher{
+		domainTrie:      version.domainTrie,
+		bloomFilter:     version.bloomFilter,
+		exactDomains:    version.exactDomains,
+		extraCategories: version.extraCategories,
+		typeRules:       version.typeRules,
+	}
+}
+
+// activateVersion makes a compiled version the live blocklist
+// Caller must hold mutex for writing
+func activateVersion(version *BlocklistVersion) {
+	blocklistManager.domainTrie = version.domainTrie
+	blocklistManager.bloomFilter = version.bloomFilter
+	blocklistManager.exactDomains = version.exactDomains
+	blocklistManager.extraCategories = version.extraCategories
+	blocklistManager.ipTree = version.ipTree
+	blocklistManager.typeRules = version.typeRules
+	blocklistManager.activeVersion = version.ID
+	blocklistManager.stats.TotalDomains = version.TotalDomains
+
+	revisions := make(map[string]SourceRevision, len(version.Sources))
+	for _, revision := range version.Sources {
+		revisions[revision.Name] = revision
+	}
+	for i := range blocklistManager.sources {
+		if revision, ok := revisions[blocklistManager.sources[i].Name]; ok {
+			blocklistManager.sources[i].EntryCount = revision.EntryCount
+			blocklistManager.sources[i].LastUpdate = revision.FetchedAt
+		}
+	}
+}
+
+// storeVersion appends a version to the history and evicts the oldest beyond the limit
+// Caller must hold mutex for writing
+func storeVersion(version *BlocklistVersion) {
+	blocklistManager.nextVersionID++
+	version.ID = blocklistManager.nextVersionID
+
+	blocklistManager.versions = append(blocklistManager.versions, version)
+	if len(blocklistManager.versions) > maxBlocklistVersions {
+		blocklistManager.versions = blocklistManager.versions[len(blocklistManager.versions)-maxBlocklistVersions:]
+	}
+}
+
+// findVersion returns a stored version by ID
+// Caller must hold mutex
+func findVersion(id int) *BlocklistVersion {
+	for _, version := range blocklistManager.versions {
+		if version.ID == id {
+			return version
+		}
+	}
+	return nil
+}
+
+// currentVersion returns the active version, nil before the first compile
+// Caller must hold mutex
+func currentVersion() *BlocklistVersion {
+	return findVersion(blocklistManager.activeVersion)
+}
+
+// buildBlocklistVersion fetches all enabled sources and compiles a new version
+// Sources that fail or are unchanged keep the entries of the active version
+func buildBlocklistVersion(trigger string) *BlocklistVersion {
+	mutex.RLock()
+	sources := make([]BlocklistSource, len(blocklistManager.sources))
+	copy(sources, blocklistManager.sources)
+	previous := currentVersion()
+	mutex.RUnlock()
+
+	previousRevisions := make(map[string]*SourceRevision)
+	previousDomains := make(map[string][]string)
+	previousPrefixes := make(map[string][]netip.Prefix)
+	previousTypeRules := make(map[string][]DNSTypeRule)
+	if previous != nil {
+		for i := range previous.Sources {
+			previousRevisions[previous.Sources[i].Name] = &previous.Sources[i]
+		}
+		previousDomains = previous.sourceDomains
+		previousPrefixes = previous.sourcePrefixes
+		previousTypeRules = previous.sourceTypeRules
+	}
+
+	sourceDomains := make(map[string][]string, len(sources))
+	sourcePrefixes := make(map[string][]netip.Prefix)
+	sourceTypeRules := make(map[string][]DNSTypeRule)
+	var revisions []SourceRevision
+	var results []models.BlocklistUpdateResult
+
+	beginUpdateProgress(trigger, countEnabledSources(sources))
+
+	for _, source := range sources {
+		if !source.Enabled {
+			continue
+		}
+
+		log.Printf("🔄 Updating %s...", source.Name)
+
+		start := time.Now()
+		prevRevision := previousRevisions[source.Name]
+		oldCount := len(previousDomains[source.Name]) + len(previousPrefixes[source.Name])
+
+		result := models.BlocklistUpdateResult{
+			Source:    source.Name,
+			UpdatedAt: time.Now(),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeoutSeconds*time.Second)
+		fetched, err := fetchBlocklistSource(ctx, source, prevRevision)
+		cancel()
+
+		switch {
+		case err != nil:
+			// Keep serving the previous entries for this source
+			result.Status = "error"
+			result.ErrorMessage = err.Error()
+			sourceDomains[source.Name] = previousDomains[source.Name]
+			sourcePrefixes[source.Name] = previousPrefixes[source.Name]
+			sourceTypeRules[source.Name] = previousTypeRules[source.Name]
+			if prevRevision != nil {
+				revisions = append(revisions, *prevRevision)
+			}
+			log.Printf("❌ Failed to update %s: %v", source.Name, err)
+
+		case fetched.NotModified && prevRevision != nil:
+			result.Status = "success"
+			sourceDomains[source.Name] = previousDomains[source.Name]
+			sourcePrefixes[source.Name] = previousPrefixes[source.Name]
+			sourceTypeRules[source.Name] = previousTypeRules[source.Name]
+			revisions = append(revisions, *prevRevision)
+
+		default:
+			if fetched.Parsed == nil {
+				// 304 without a previous revision, nothing to reuse
+				result.Status = "error"
+				result.ErrorMessage = "source not modified but no previous entries available"
+				break
+			}
+
+			domains := fetched.Parsed.Domains
+			prefixes := fetched.Parsed.Prefixes
+			sourceDomains[source.Name] = domains
+			sourcePrefixes[source.Name] = prefixes
+			sourceTypeRules[source.Name] = fetched.Parsed.TypeRules
+			revisions = append(revisions, SourceRevision{
+				Name:         source.Name,
+				Revision:     fetched.Parsed.Revision,
+				ETag:         fetched.ETag,
+				LastModified: fetched.LastModified,
+				EntryCount:   len(domains) + len(prefixes) + len(fetched.Parsed.TypeRules),
+				FetchedAt:    time.Now(),
+			})
+
+			result.Status = "success"
+			if fetched.Parsed.Rejected > 0 {
+				result.Status = "partial"
+			}
+			result.EntriesAdded, result.EntriesRemoved = diffDomainCounts(previousDomains[source.Name], domains)
+			prefixesAdded, prefixesRemoved := diffPrefixCounts(previousPrefixes[source.Name], prefixes)
+			result.EntriesAdded += prefixesAdded
+			result.EntriesRemoved += prefixesRemoved
+		}
+
+		result.Duration = time.Since(start)
+		results = append(results, result)
+		advanceUpdateProgress()
+
+		log.Printf("✅ %s: %s (%d -> %d domains) in %v",
+			source.Name, result.Status, oldCount, len(sourceDomains[source.Name])+len(sourcePrefixes[source.Name]), result.Duration)
+	}
+
+	version := compileBlocklistVersion(sources, sourceDomains, sourcePrefixes, sourceTypeRules)
+	version.Trigger = trigger
+	version.Sources = revisions
+	version.Results = results
+
+	return version
+}
+
+// recompileBlocklists rebuilds the active entries into a new version without fetching
+// Used when the source set changes but no source content needs refreshing
+func recompileBlocklists(trigger string) *BlocklistVersion {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	mutex.RLock()
+	sources := make([]BlocklistSource, len(blocklistManager.sources))
+	copy(sources, blocklistManager.sources)
+	previous := currentVersion()
+	mutex.RUnlock()
+
+	sourceDomains := make(map[string][]string, len(sources))
+	sourcePrefixes := make(map[string][]netip.Prefix)
+	sourceTypeRules := make(map[string][]DNSTypeRule)
+	var revisions []SourceRevision
+	if previous != nil {
+		enabled := make(map[string]bool, len(sources))
+		for _, source := range sources {
+			if source.Enabled {
+				enabled[source.Name] = true
+				sourceDomains[source.Name] = previous.sourceDomains[source.Name]
+				sourcePrefixes[source.Name] = previous.sourcePrefixes[source.Name]
+				sourceTypeRules[source.Name] = previous.sourceTypeRules[source.Name]
+			}
+		}
+		for _, revision := range previous.Sources {
+			if enabled[revision.Name] {
+				revisions = append(revisions, revision)
+			}
+		}
+	}
+
+	version := compileBlocklistVersion(sources, sourceDomains, sourcePrefixes, sourceTypeRules)
+	version.Trigger = trigger
+	version.Sources = revisions
+
+	mutex.Lock()
+	storeVersion(version)
+	activateVersion(version)
+	mutex.Unlock()
+
+	log.Printf("✅ Recompiled version %d (%s): %d domains", version.ID, trigger, version.TotalDomains)
+
+	return version
+}
+
+// diffDomainCounts counts entries added and removed between two domain lists
+func diffDomainCounts(before, after []string) (added, removed int) {
+	previous := make(map[string]bool, len(before))
+	for _, domain := range before {
+		previous[domain] = true
+	}
+
+	for _, domain := range after {
+		if previous[domain] {
+			delete(previous, domain)
+		} else {
+			added++
+		}
+	}
+
+	return added, len(previous)
+}
+
+// diffPrefixCounts counts prefixes added and removed between two prefix lists
+func diffPrefixCounts(before, after []netip.Prefix) (added, removed int) {
+	previous := make(map[netip.Prefix]bool, len(before))
+	for _, prefix := range before {
+		previous[prefix] = true
+	}
+
+	for _, prefix := range after {
+		if previous[prefix] {
+			delete(previous, prefix)
+		} else {
+			added++
+		}
+	}
+
+	return added, len(previous)
+}
+
+// ============================================================================
+// VERSION API HANDLERS
+// Version listing and atomic rollback, system data only
+// ============================================================================
+
+// handleBlocklistVersions lists the stored blocklist versions
+// Privacy: Source revisions and update results only, no user data
+func handleBlocklistVersions(c *gin.Context) {
+	start := time.Now()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if blocklistManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "blocklist manager not initialized"})
+		return
+	}
+
+	versions := make([]gin.H, 0, len(blocklistManager.versions))
+	for i := len(blocklistManager.versions) - 1; i >= 0; i-- {
+		version := blocklistManager.versions[i]
+
+		sources := make([]gin.H, len(version.Sources))
+		for j, revision := range version.Sources {
+			sources[j] = gin.H{
+				"name":        revision.Name,
+				"revision":    revision.Revision,
+				"etag":        revision.ETag,
+				"entry_count": revision.EntryCount,
+				"fetched_at":  revision.FetchedAt.Format(time.RFC3339),
+			}
+		}
+
+		versions = append(versions, gin.H{
+			"version":          version.ID,
+			"active":           version.ID == blocklistManager.activeVersion,
+			"trigger":          version.Trigger,
+			"total_domains":    version.TotalDomains,
+			"total_ip_ranges":  version.TotalIPRanges,
+			"total_type_rules": version.TotalTypeRules,
+			"created_at":       version.CreatedAt.Format(time.RFC3339),
+			"sources":          sources,
+			"results":          version.Results,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"versions":       versions,
+		"active_version": blocklistManager.activeVersion,
+		"max_versions":   maxBlocklistVersions,
+		"response_time":  time.Since(start).String(),
+		"timestamp":      time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleBlocklistRollback atomically switches the live blocklist to a stored version
+// No data is fetched; the compiled structures are swapped under the write lock
+func handleBlocklistRollback(c *gin.Context) {
+	var request struct {
+		Version int `json:"version"` // Version ID to activate
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	start := time.Now()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if blocklistManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "blocklist manager not initialized"})
+		return
+	}
+
+	version := findVersion(request.Version)
+	if version == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("version %d not found", request.Version),
+		})
+		return
+	}
+
+	previousVersion := blocklistManager.activeVersion
+	activateVersion(version)
+
+	rollbackTime := time.Since(start)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":           "rollback_complete",
+		"active_version":   version.ID,
+		"previous_version": previousVersion,
+		"total_domains":    version.TotalDomains,
+		"rollback_time":    rollbackTime.String(),
+		"timestamp":        time.Now().UTC().Format(time.RFC3339),
+	})
+
+	log.Printf("⏪ Blocklist rolled back from version %d to %d in %v",
+		previousVersion, version.ID, rollbackTime)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompileBlocklistVersionPriority(t *testing.T) {
+	// Slice order disagrees with priority; priority 1 must win shared entries
+	sources := []BlocklistSource{
+		{Name: "low", Category: "tracking", Enabled: true, Priority: 2},
+		{Name: "high", Category: "ads", Enabled: true, Priority: 1},
+		{Name: "off", Category: "malware", Enabled: false, Priority: 0},
+	}
+	domains := map[string][]string{
+		"low":  {"shared.example", "low.example"},
+		"high": {"shared.example"},
+		"off":  {"shared.example", "off.example"},
+	}
+
+	version := compileBlocklistVersion(sources, domains, map[string][]netip.Prefix{}, map[string][]DNSTypeRule{})
+
+	if category := version.exactDomains["shared.example"]; category != "ads" {
+		t.Errorf("shared.example category = %q, want ads", category)
+	}
+	if _, ok := version.exactDomains["off.example"]; ok {
+		t.Error("disabled source contributed off.example")
+	}
+	if version.TotalDomains != 2 {
+		t.Errorf("TotalDomains = %d, want 2", version.TotalDomains)
+	}
+	if sources[0].Name != "low" {
+		t.Error("compileBlocklistVersion reordered the caller's sources")
+	}
+}
+
+func TestCountEnabledSources(t *testing.T) {
+	sources := []BlocklistSource{{Enabled: true}, {Enabled: false}, {Enabled: true}}
+	if got := countEnabledSources(sources); got != 2 {
+		t.Errorf("countEnabledSources = %d, want 2", got)
+	}
+}
+
+func TestStoreVersionEviction(t *testing.T) {
+	sources := []BlocklistSource{{Name: "base", Category: "ads", Enabled: true}}
+	installTestBlocklist(t, sources, map[string][]string{"base": {"v1.example"}})
+
+	mutex.Lock()
+	for i := 0; i < maxBlocklistVersions+1; i++ {
+		storeVersion(compileBlocklistVersion(sources, map[string][]string{}, map[string][]netip.Prefix{}, map[string][]DNSTypeRule{}))
+	}
+	ids := []int{}
+	for _, version := range blocklistManager.versions {
+		ids = append(ids, version.ID)
+	}
+	first := findVersion(1)
+	mutex.Unlock()
+
+	if len(ids) != maxBlocklistVersions {
+		t.Fatalf("stored versions %v, want %d", ids, maxBlocklistVersions)
+	}
+	if first != nil {
+		t.Error("oldest version was not evicted")
+	}
+	if newest := maxBlocklistVersions + 2; ids[len(ids)-1] != newest {
+		t.Errorf("newest version = %d, want %d", ids[len(ids)-1], newest)
+	}
+}
+
+// postRollback posts a rollback request and returns the status code
+func postRollback(t *testing.T, body string) int {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/rollback", handleBlocklistRollback)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/rollback", strings.NewReader(body)))
+	return recorder.Code
+}
+
+func TestHandleBlocklistRollback(t *testing.T) {
+	sources := []BlocklistSource{{Name: "base", Category: "ads", Enabled: true}}
+	installTestBlocklist(t, sources, map[string][]string{"base": {"old.example"}})
+
+	mutex.Lock()
+	newer := compileBlocklistVersion(sources, map[string][]string{"base": {"new.example"}}, map[string][]netip.Prefix{}, map[string][]DNSTypeRule{})
+	storeVersion(newer)
+	activateVersion(newer)
+	mutex.Unlock()
+
+	if !checkDomain("new.example", "").Blocked || checkDomain("old.example", "").Blocked {
+		t.Fatal("version 2 is not live")
+	}
+
+	if code := postRollback(t, `{"version": 1}`); code != http.StatusOK {
+		t.Fatalf("rollback status = %d, want 200", code)
+	}
+	if checkDomain("new.example", "").Blocked || !checkDomain("old.example", "").Blocked {
+		t.Error("rollback did not restore version 1")
+	}
+	mutex.RLock()
+	active := blocklistManager.activeVersion
+	mutex.RUnlock()
+	if active != 1 {
+		t.Errorf("active version = %d, want 1", active)
+	}
+
+	if code := postRollback(t, `{"version": 42}`); code != http.StatusNotFound {
+		t.Errorf("unknown version status = %d, want 404", code)
+	}
+	if code := postRollback(t, `not json`); code != http.StatusBadRequest {
+		t.Errorf("malformed request status = %d, want 400", code)
+	}
+}
+
+func TestBuildBlocklistVersionReusesUnchangedSources(t *testing.T) {
+	var fetches, conditional int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		switch r.URL.Path {
+		case "/stable.txt":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				conditional++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte("stable.example\n"))
+		case "/flaky.txt":
+			if fetches > 2 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("flaky.example\n"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	sources := []BlocklistSource{
+		{Name: "stable", URL: server.URL + "/stable.txt", Format: "domains", Category: "ads", Enabled: true},
+		{Name: "flaky", URL: server.URL + "/flaky.txt", Format: "domains", Category: "tracking", Enabled: true},
+	}
+	installTestBlocklist(t, sources, map[string][]string{})
+
+	first := buildBlocklistVersion("startup")
+	mutex.Lock()
+	storeVersion(first)
+	activateVersion(first)
+	mutex.Unlock()
+
+	second := buildBlocklistVersion("scheduled")
+	if conditional != 1 {
+		t.Errorf("conditional requests = %d, want 1", conditional)
+	}
+	for _, name := range []string{"stable", "flaky"} {
+		before, after := first.sourceDomains[name], second.sourceDomains[name]
+		if len(after) != 1 || &before[0] != &after[0] {
+			t.Errorf("%s: entries %v not reused from the previous version", name, after)
+		}
+	}
+	if second.TotalDomains != 2 {
+		t.Errorf("TotalDomains = %d, want 2", second.TotalDomains)
+	}
+	statuses := map[string]string{}
+	for _, result := range second.Results {
+		statuses[result.Source] = result.Status
+	}
+	if statuses["stable"] != "success" || statuses["flaky"] != "error" {
+		t.Errorf("results = %v, want stable success and flaky error", statuses)
+	}
+	if len(second.Sources) != 2 || second.Sources[0] != first.Sources[0] {
+		t.Errorf("revisions = %+v, want the previous revisions kept", second.Sources)
+	}
+}
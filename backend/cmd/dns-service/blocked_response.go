@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// BLOCKED RESPONSES
+// Defines the answer sent for a blocked query, globally and per category
+// ============================================================================
+
+const maxBlockedTTL = 86400
+
+// blockingModes are the supported answers for blocked queries
+var blockingModes = map[string]bool{
+	"nxdomain":  true, // Name does not exist
+	"nodata":    true, // Name exists, no records of this type
+	"refused":   true, // Server refuses to answer
+	"zero_ip":   true, // 0.0.0.0 / ::
+	"custom_ip": true, // Configured addresses, e.g. a local block page
+}
+
+// BlockedResponse describes how blocked queries are answered
+type BlockedResponse struct {
+	Mode string `json:"mode"`
+	IPv4 string `json:"ipv4,omitempty"` // custom_ip answer for A queries
+	IPv6 string `json:"ipv6,omitempty"` // custom_ip answer for AAAA queries
+}
+
+// BlockingConfig is the global mode, per-category overrides and the blocked-answer TTL
+type BlockingConfig struct {
+	BlockedResponse
+	TTL        uint32                     `json:"ttl"`        // TTL of blocked answers and negative caching
+	Categories map[string]BlockedResponse `json:"categories"` // Category -> override
+}
+
+var (
+	blockingConfig = BlockingConfig{
+		BlockedResponse: BlockedResponse{
+			Mode: envOrDefault("BLOCKING_MODE", "zero_ip"),
+			IPv4: os.Getenv("BLOCKING_IPV4"),
+			IPv6: os.Getenv("BLOCKING_IPV6"),
+		}, // Checked at startup by checkBlockingConfig
+		TTL: func() uint32 {
+			if ttl, err := strconv.ParseUint(os.Getenv("BLOCKED_TTL"), 10, 32); err == nil && ttl <= maxBlockedTTL {
+				return uint32(ttl)
+			}
+			return 10
+		}(),
+		Categories: map[string]BlockedResponse{},
+	}
+	blockingMutex sync.RWMutex
+)
+
+// envOrDefault returns an environment variable or a fallback value
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// validateBlockedResponse checks a mode and the addresses it needs
+func validateBlockedResponse(response BlockedResponse) error {
+	if !blockingModes[response.Mode] {
+		return fmt.Errorf("mode must be nxdomain, nodata, refused, zero_ip or custom_ip")
+	}
+	if response.IPv4 != "" {
+		if addr, err := netip.ParseAddr(response.IPv4); err != nil || !addr.Is4() {
+			return fmt.Errorf("ipv4 is not a valid IPv4 address")
+		}
+	}
+	if response.IPv6 != "" {
+		if addr, err := netip.ParseAddr(response.IPv6); err != nil || !addr.Is6() || addr.Is4In6() {
+			return fmt.Errorf("ipv6 is not a valid IPv6 address")
+		}
+	}
+	if response.Mode == "custom_ip" && response.IPv4 == "" && response.IPv6 == "" {
+		return fmt.Errorf("custom_ip needs ipv4 or ipv6")
+	}
+	return nil
+}
+
+// checkBlockingConfig rejects an invalid BLOCKING_MODE, BLOCKING_IPV4 or BLOCKING_IPV6 at startup
+// A typo would otherwise answer blocked queries differently than configured
+func checkBlockingConfig() error {
+	if err := validateBlockedResponse(currentBlockingConfig().BlockedResponse); err != nil {
+		return fmt.Errorf("invalid BLOCKING_MODE configuration: %w", err)
+	}
+	return nil
+}
+
+// currentBlockingConfig returns a copy of the blocking settings
+func currentBlockingConfig() BlockingConfig {
+	blockingMutex.RLock()
+	defer blockingMutex.RUnlock()
+
+	config := blockingConfig
+	config.Categories = make(map[string]BlockedResponse, len(blockingConfig.Categories))
+	for category, response := range blockingConfig.Categories {
+		config.Categories[category] = response
+	}
+	return config
+}
+
+// blockedSOA is the authority record that lets clients cache negative answers for the blocked TTL
+func blockedSOA(owner dnsmessage.Name, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: owner, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body: &dnsmessage.SOAResource{
+			NS:      dnsmessage.MustNewName("blocked.shroudinger."),
+			MBox:    dnsmessage.MustNewName("hostmaster.shroudinger."),
+			Serial:  1,
+			Refresh: 1800,
+			Retry:   900,
+			Expire:  604800,
+			MinTTL:  ttl,
+		},
+	}
+}
+
+// buildBlockedResponse answers a blocked query using the mode for its category
+// refuse forces REFUSED regardless of mode (RR-type policy, rebinding "refuse")
+func buildBlockedResponse(query dnsmessage.Message, category string, refuse bool) *dnsmessage.Message {
+	config := currentBlockingConfig()
+	response := config.BlockedResponse
+	if override, ok := config.Categories[category]; ok {
+		response = override
+	}
+	if refuse {
+		response = BlockedResponse{Mode: "refused"}
+	}
+
+	resp := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.Header.ID,
+			Response:           true,
+			RecursionDesired:   query.Header.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              dnsmessage.RCodeSuccess,
+		},
+		Questions: query.Questions,
+	}
+	if len(query.Questions) == 0 {
+		resp.Header.RCode = dnsmessage.RCodeFormatError
+		return resp
+	}
+	question := query.Questions[0]
+
+	// Addresses for the IP modes; anything without an address becomes NODATA
+	var addr netip.Addr
+	switch response.Mode {
+	case "nxdomain":
+		resp.Header.RCode = dnsmessage.RCodeNameError
+	case "refused":
+		resp.Header.RCode = dnsmessage.RCodeRefused
+		return resp
+	case "zero_ip":
+		if question.Type == dnsmessage.TypeA {
+			addr = netip.IPv4Unspecified()
+		} else if question.Type == dnsmessage.TypeAAAA {
+			addr = netip.IPv6Unspecified()
+		}
+	case "custom_ip":
+		if question.Type == dnsmessage.TypeA && response.IPv4 != "" {
+			addr = netip.MustParseAddr(response.IPv4)
+		} else if question.Type == dnsmessage.TypeAAAA && response.IPv6 != "" {
+			addr = netip.MustParseAddr(response.IPv6)
+		}
+	}
+
+	if !addr.IsValid() {
+		resp.Authorities = []dnsmessage.Resource{blockedSOA(question.Name, config.TTL)}
+		return resp
+	}
+
+	header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: config.TTL}
+	if addr.Is4() {
+		resp.Answers = []dnsmessage.Resource{{Header: header, Body: &dnsmessage.AResource{A: addr.As4()}}}
+	} else {
+		resp.Answers = []dnsmessage.Resource{{Header: header, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}}}
+	}
+	return resp
+}
+
+// ============================================================================
+// BLOCKING CONFIG API HANDLERS
+// Global mode, category overrides and TTL for blocked answers
+// ============================================================================
+
+// handleBlockingConfig returns the blocked-response settings
+func handleBlockingConfig(c *gin.Context) {
+	modes := make([]string, 0, len(blockingModes))
+	for mode := range blockingModes {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+
+	c.JSON(http.StatusOK, gin.H{
+		"config":    currentBlockingConfig(),
+		"modes":     modes,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleBlockingConfigUpdate replaces the blocked-response settings
+func handleBlockingConfigUpdate(c *gin.Context) {
+	config := currentBlockingConfig()
+	config.Categories = nil // A request without categories clears the overrides
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if err := validateBlockedResponse(config.BlockedResponse); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if config.TTL > maxBlockedTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ttl must be at most %d", maxBlockedTTL)})
+		return
+	}
+	if config.Categories == nil {
+		config.Categories = map[string]BlockedResponse{}
+	}
+	for category, response := range config.Categories {
+		if err := validateBlockedResponse(response); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("category %s: %v", category, err)})
+			return
+		}
+	}
+
+	blockingMutex.Lock()
+	blockingConfig = config
+	blockingMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "updated",
+		"config":    config,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// setBlockingConfig replaces the blocking settings for the duration of a test
+func setBlockingConfig(t *testing.T, config BlockingConfig) {
+	t.Helper()
+
+	blockingMutex.Lock()
+	previous := blockingConfig
+	blockingConfig = config
+	blockingMutex.Unlock()
+
+	t.Cleanup(func() {
+		blockingMutex.Lock()
+		blockingConfig = previous
+		blockingMutex.Unlock()
+	})
+}
+
+func TestCheckBlockingConfig(t *testing.T) {
+	tests := []struct {
+		response BlockedResponse
+		valid    bool
+	}{
+		{BlockedResponse{Mode: "zero_ip"}, true},
+		{BlockedResponse{Mode: "nxdomain"}, true},
+		{BlockedResponse{Mode: "custom_ip", IPv4: "192.0.2.1"}, true},
+		{BlockedResponse{Mode: "custom_ip", IPv6: "2001:db8::1"}, true},
+		{BlockedResponse{Mode: "zeroip"}, false},
+		{BlockedResponse{Mode: "NXDOMAIN"}, false},
+		{BlockedResponse{Mode: "custom_ip"}, false},
+		{BlockedResponse{Mode: "custom_ip", IPv4: "2001:db8::1"}, false},
+		{BlockedResponse{Mode: "custom_ip", IPv6: "::ffff:192.0.2.1"}, false},
+	}
+	for _, test := range tests {
+		setBlockingConfig(t, BlockingConfig{BlockedResponse: test.response, TTL: 10})
+		if err := checkBlockingConfig(); (err == nil) != test.valid {
+			t.Errorf("checkBlockingConfig with %+v = %v, want valid %v", test.response, err, test.valid)
+		}
+	}
+}
+
+func TestBuildBlockedResponse(t *testing.T) {
+	setBlockingConfig(t, BlockingConfig{
+		BlockedResponse: BlockedResponse{Mode: "zero_ip"},
+		TTL:             42,
+		Categories: map[string]BlockedResponse{
+			"malware":  {Mode: "nxdomain"},
+			"tracking": {Mode: "nodata"},
+			"gambling": {Mode: "refused"},
+			"adult":    {Mode: "custom_ip", IPv4: "192.0.2.1"},
+			"social":   {Mode: "custom_ip", IPv4: "192.0.2.2", IPv6: "2001:db8::2"},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		category string
+		qtype    dnsmessage.Type
+		refuse   bool
+		rcode    dnsmessage.RCode
+		answers  string
+		soa      bool
+	}{
+		{"zero_ip A", "ads", dnsmessage.TypeA, false, dnsmessage.RCodeSuccess, "[0.0.0.0]", false},
+		{"zero_ip AAAA", "ads", dnsmessage.TypeAAAA, false, dnsmessage.RCodeSuccess, "[::]", false},
+		{"zero_ip without an address type", "ads", dnsmessage.TypeMX, false, dnsmessage.RCodeSuccess, "[]", true},
+		{"nxdomain override", "malware", dnsmessage.TypeA, false, dnsmessage.RCodeNameError, "[]", true},
+		{"nodata override", "tracking", dnsmessage.TypeAAAA, false, dnsmessage.RCodeSuccess, "[]", true},
+		{"refused override", "gambling", dnsmessage.TypeA, false, dnsmessage.RCodeRefused, "[]", false},
+		{"custom IPv4", "adult", dnsmessage.TypeA, false, dnsmessage.RCodeSuccess, "[192.0.2.1]", false},
+		{"custom without IPv6", "adult", dnsmessage.TypeAAAA, false, dnsmessage.RCodeSuccess, "[]", true},
+		{"custom IPv6", "social", dnsmessage.TypeAAAA, false, dnsmessage.RCodeSuccess, "[2001:db8::2]", false},
+		{"refuse wins over the category", "malware", dnsmessage.TypeA, true, dnsmessage.RCodeRefused, "[]", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := mustQuery(t, "blocked.example", test.qtype)
+			resp := buildBlockedResponse(query, test.category, test.refuse)
+
+			if resp.Header.ID != query.Header.ID || !resp.Header.Response || resp.Header.RCode != test.rcode {
+				t.Errorf("header %+v, want ID %d and rcode %v", resp.Header, query.Header.ID, test.rcode)
+			}
+			if got := fmt.Sprint(answerAddrs(resp)); got != test.answers {
+				t.Errorf("answers %s, want %s", got, test.answers)
+			}
+			for _, answer := range resp.Answers {
+				if answer.Header.TTL != 42 || answer.Header.Name != query.Questions[0].Name {
+					t.Errorf("answer header %+v, want the queried name with TTL 42", answer.Header)
+				}
+			}
+
+			if !test.soa {
+				if len(resp.Authorities) != 0 {
+					t.Errorf("authorities %+v, want none", resp.Authorities)
+				}
+				return
+			}
+			// Negative answers carry an SOA so clients cache them for the blocked TTL
+			if len(resp.Authorities) != 1 {
+				t.Fatalf("authorities %+v, want one SOA", resp.Authorities)
+			}
+			soa, ok := resp.Authorities[0].Body.(*dnsmessage.SOAResource)
+			if !ok || resp.Authorities[0].Header.TTL != 42 || soa.MinTTL != 42 {
+				t.Errorf("authority %+v, want an SOA with TTL and minimum 42", resp.Authorities[0])
+			}
+		})
+	}
+}
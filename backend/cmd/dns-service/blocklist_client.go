@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// ============================================================================
+// BLOCKLIST SERVICE CLIENT
+// Local HTTP client for domain and IP checks against the blocklist-service
+// ============================================================================
+
+const (
+	defaultBlocklistServiceURL = "http://localhost:8081"
+	blocklistCheckTimeoutMs    = 250 // Local service, keep well under the resolution budget
+	blocklistBatchLimit        = 100 // Largest batch the blocklist-service accepts
+)
+
+// blocklistServiceURL is resolved once at startup (BLOCKLIST_SERVICE_URL overrides)
+var blocklistServiceURL = func() string {
+	if url := os.Getenv("BLOCKLIST_SERVICE_URL"); url != "" {
+		return url
+	}
+	return defaultBlocklistServiceURL
+}()
+
+// blocklistHTTPClient keeps connections to the local blocklist-service alive
+var blocklistHTTPClient = &http.Client{
+	Timeout: blocklistCheckTimeoutMs * time.Millisecond,
+}
+
+// IPCheckResult is the blocklist verdict for one answer address
+type IPCheckResult struct {
+	Blocked       bool   `json:"blocked"`
+	Category      string `json:"category"`
+	MatchedPrefix string `json:"matched_prefix,omitempty"`
+}
+
+// DomainCheckResult is the blocklist verdict for one domain
+type DomainCheckResult struct {
+	Blocked  bool   `json:"blocked"`
+	Category string `json:"category"`
+	Refuse   bool   `json:"refuse"`  // RR-type policy asks for REFUSED
+	Rewrite  string `json:"rewrite"` // Safe-search CNAME target, see buildSafeSearchAnswer
+	Paused   bool   `json:"paused"`  // Blocking is paused or temporarily allowed for the name
+}
+
+// postBlocklistJSON sends a JSON request to the blocklist-service and decodes the reply
+// Privacy: Request bodies carry names or addresses and are never logged
+func postBlocklistJSON(ctx context.Context, path string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, blocklistServiceURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := blocklistHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("blocklist-service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("blocklist-service returned status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// checkBlockedIPs checks answer addresses against the blocklist-service CIDR lists
+// domain is the queried name so temporary allows apply; results follow the order of addrs
+// Large answers are split into batches of at most blocklistBatchLimit
+func checkBlockedIPs(ctx context.Context, domain string, addrs []netip.Addr) ([]IPCheckResult, error) {
+	results := make([]IPCheckResult, 0, len(addrs))
+	for start := 0; start < len(addrs); start += blocklistBatchLimit {
+		batch := addrs[start:min(start+blocklistBatchLimit, len(addrs))]
+		ips := make([]string, len(batch))
+		for i, addr := range batch {
+			ips[i] = addr.String()
+		}
+
+		var response struct {
+			Results []IPCheckResult `json:"results"`
+		}
+		if err := postBlocklistJSON(ctx, "/api/v1/blocklist/check-ip", map[string]interface{}{"ips": ips, "domain": domain}, &response); err != nil {
+			return nil, err
+		}
+		if len(response.Results) != len(batch) {
+			return nil, fmt.Errorf("blocklist-service returned %d results for %d ips", len(response.Results), len(batch))
+		}
+		results = append(results, response.Results...)
+	}
+
+	return results, nil
+}
+
+// checkBlockedDomains checks names against the blocklist-service in one batch
+// qtype (e.g. "AAAA") enables $dnstype rules and the RR-type policy; results follow the order of domains
+func checkBlockedDomains(ctx context.Context, domains []string, qtype string) ([]DomainCheckResult, error) {
+	return checkDomainBatches(ctx, domains, qtype, false)
+}
+
+// checkBlockedCNAMETargets checks CNAME targets, matching subdomains of listed zones too
+// Long chains are split into several batches
+func checkBlockedCNAMETargets(ctx context.Context, targets []string, qtype string) ([]DomainCheckResult, error) {
+	return checkDomainBatches(ctx, targets, qtype, true)
+}
+
+// checkDomainBatches sends domains in batches of at most blocklistBatchLimit
+func checkDomainBatches(ctx context.Context, domains []string, qtype string, matchParents bool) ([]DomainCheckResult, error) {
+	results := make([]DomainCheckResult, 0, len(domains))
+	for start := 0; start < len(domains); start += blocklistBatchLimit {
+		batch := domains[start:min(start+blocklistBatchLimit, len(domains))]
+
+		var response struct {
+			Results []DomainCheckResult `json:"results"`
+		}
+		request := map[string]interface{}{"domains": batch, "qtype": qtype, "match_parents": matchParents}
+		if err := postBlocklistJSON(ctx, "/api/v1/blocklist/batch", request, &response); err != nil {
+			return nil, err
+		}
+		if len(response.Results) != len(batch) {
+			return nil, fmt.Errorf("blocklist-service returned %d results for %d domains", len(response.Results), len(batch))
+		}
+		results = append(results, response.Results...)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// ANONYMOUS CACHE
+// Sharded LRU of upstream answers keyed by HMAC(name, type, class) under a salt
+// generated at boot, bounded by entry count and a memory budget. Negative answers
+// are cached (RFC 2308), expired answers served while they refresh (RFC 8767) and
+// popular answers prefetched shortly before they expire
+// Privacy: Keys cannot be reversed with a dictionary; payloads are sealed under
+// rotating in-memory keys so a memory scrape does not reveal browsing history
+// ============================================================================
+
+const (
+	cacheShardCount     = 16
+	cacheMemoryBudgetMB = 16  // Cache share of the service memory target
+	cacheEntryOverhead  = 160 // Map slot, list element and entry struct, approximately
+
+	staleAnswerTTL      = 30               // Seconds; TTL of stale answers (RFC 8767 §4)
+	staleRefreshBackoff = 30 * time.Second // Failure recheck timer between refresh attempts
+
+	prefetchMinHits  = 3               // Lookups since the last refresh that make an entry hot
+	prefetchInterval = 5 * time.Second // How often the prefetcher scans for candidates
+)
+
+// cacheLookup is the outcome of a cache lookup
+type cacheLookup int
+
+const (
+	cacheMiss         cacheLookup = iota
+	cacheHit                      // Within TTL
+	cacheStale                    // Expired but within the maximum staleness
+	cacheStaleRefresh             // Stale, and the caller should refresh it upstream
+)
+
+var (
+	// cacheMaxStale is how long past expiry an answer may be served (CACHE_MAX_STALE, 0 disables)
+	cacheMaxStale = func() time.Duration {
+		if stale, err := time.ParseDuration(os.Getenv("CACHE_MAX_STALE")); err == nil && stale >= 0 {
+			return stale
+		}
+		return time.Hour
+	}()
+
+	// cachePrefetchWindow is how long before expiry hot entries are refreshed
+	// (CACHE_PREFETCH_WINDOW, 0 disables prefetching)
+	cachePrefetchWindow = func() time.Duration {
+		if window, err := time.ParseDuration(os.Getenv("CACHE_PREFETCH_WINDOW")); err == nil && window >= 0 {
+			return window
+		}
+		return 30 * time.Second
+	}()
+
+	// cachePrefetchRate caps prefetch queries per second (CACHE_PREFETCH_RATE)
+	cachePrefetchRate = func() int {
+		if rate, err := strconv.Atoi(os.Getenv("CACHE_PREFETCH_RATE")); err == nil && rate > 0 {
+			return rate
+		}
+		return 10
+	}()
+
+	// cacheKeyRotation retires payload keys. An entry is dropped on the second rotation
+	// after it was sealed, so it lives at least one interval: the TTL cap plus the staleness
+	cacheKeyRotation = cacheTTLMinutes*time.Minute + cacheMaxStale
+)
+
+// errCacheKeyRetired means an entry was sealed under a key that has been discarded
+var errCacheKeyRetired = errors.New("cache payload key retired")
+
+// cacheKey is an AES-256-GCM payload key that exists only in memory
+type cacheKey struct {
+	generation uint64
+	aead       cipher.AEAD
+}
+
+// cacheKeyring holds the sealing key and the one before it
+type cacheKeyring struct {
+	mu       sync.RWMutex
+	current  *cacheKey
+	previous *cacheKey
+}
+
+// cacheShard is an independently locked LRU partition
+type cacheShard struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element // HashedKey -> element holding *CacheEntry
+	lru        *list.List               // Front is most recently used
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+}
+
+// newCacheSalt returns a random HMAC key for this process
+func newCacheSalt() []byte {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return salt
+}
+
+// newCacheKey generates a random payload key
+func newCacheKey(generation uint64) *cacheKey {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	block, err := aes.NewCipher(raw)
+	clear(raw) // Only the expanded key schedule stays in memory
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &cacheKey{generation: generation, aead: aead}
+}
+
+// newCacheShards splits the entry and memory limits evenly across shards
+func newCacheShards(maxSize int, maxBytes int64) [cacheShardCount]*cacheShard {
+	var shards [cacheShardCount]*cacheShard
+	for i := range shards {
+		shards[i] = &cacheShard{
+			entries:    make(map[string]*list.Element),
+			lru:        list.New(),
+			maxEntries: max(1, maxSize/cacheShardCount),
+			maxBytes:   maxBytes / cacheShardCount,
+		}
+	}
+	return shards
+}
+
+// key hashes a question with the per-boot salt; names are compared case-insensitively
+func (c *AnonymousCache) key(question dnsmessage.Question) string {
+	mac := hmac.New(sha256.New, c.salt)
+	mac.Write([]byte(strings.ToLower(question.Name.String())))
+	var typeClass [4]byte
+	binary.BigEndian.PutUint16(typeClass[0:2], uint16(question.Type))
+	binary.BigEndian.PutUint16(typeClass[2:4], uint16(question.Class))
+	mac.Write(typeClass[:])
+	return string(mac.Sum(nil))
+}
+
+// shard picks the partition of a key; HMAC output is uniform so the first byte suffices
+func (c *AnonymousCache) shard(key string) *cacheShard {
+	return c.shards[int(key[0])%cacheShardCount]
+}
+
+// entrySize estimates the memory held by an entry
+func entrySize(entry *CacheEntry) int64 {
+	return int64(len(entry.HashedKey) + len(entry.ResponseData) + cacheEntryOverhead)
+}
+
+// cacheableTTL returns how long a response may be cached, or 0 when it must not be
+// Positive answers use their lowest TTL; NXDOMAIN and NODATA use the SOA TTL capped
+// by the SOA minimum (RFC 2308 §5), and are not cached without an SOA
+func cacheableTTL(resp *dnsmessage.Message) time.Duration {
+	if resp.Header.Truncated {
+		return 0
+	}
+
+	minTTL := uint32(cacheTTLMinutes * 60)
+	switch {
+	case resp.Header.RCode == dnsmessage.RCodeSuccess && len(resp.Answers) > 0:
+		for _, answer := range resp.Answers {
+			minTTL = min(minTTL, answer.Header.TTL)
+		}
+	case resp.Header.RCode == dnsmessage.RCodeSuccess || resp.Header.RCode == dnsmessage.RCodeNameError:
+		soa := negativeSOA(resp)
+		if soa == nil {
+			return 0
+		}
+		minTTL = min(minTTL, soa.Header.TTL, soa.Body.(*dnsmessage.SOAResource).MinTTL)
+	default:
+		return 0 // SERVFAIL, REFUSED and the like are never cached
+	}
+	return time.Duration(minTTL) * time.Second
+}
+
+// negativeSOA returns the SOA record of a negative answer's authority section
+func negativeSOA(resp *dnsmessage.Message) *dnsmessage.Resource {
+	for i := range resp.Authorities {
+		if _, ok := resp.Authorities[i].Body.(*dnsmessage.SOAResource); ok {
+			return &resp.Authorities[i]
+		}
+	}
+	return nil
+}
+
+// ageRecords lowers every record TTL by the time spent in the cache, down to floor
+func ageRecords(resp *dnsmessage.Message, age, floor uint32) {
+	for _, section := range [][]dnsmessage.Resource{resp.Answers, resp.Authorities, resp.Additionals} {
+		for i := range section {
+			if section[i].Header.Type == dnsmessage.TypeOPT {
+				continue // OPT "TTL" carries EDNS flags, not a lifetime
+			}
+			if section[i].Header.TTL > age && section[i].Header.TTL-age > floor {
+				section[i].Header.TTL -= age
+			} else {
+				section[i].Header.TTL = floor
+			}
+		}
+	}
+}
+
+// Get returns a cached answer for the question with TTLs aged and the ID of the query
+// Expired answers within cacheMaxStale are returned with staleAnswerTTL; one caller
+// per staleRefreshBackoff is told to refresh them
+func (c *AnonymousCache) Get(query dnsmessage.Message) (*dnsmessage.Message, cacheLookup) {
+	key := c.key(query.Questions[0])
+	shard := c.shard(key)
+	now := time.Now()
+
+	shard.mu.Lock()
+	element, ok := shard.entries[key]
+	if !ok {
+		shard.mu.Unlock()
+		return nil, cacheMiss
+	}
+	entry := element.Value.(*CacheEntry)
+	if !now.Before(entry.ExpiresAt.Add(cacheMaxStale)) {
+		shard.remove(element)
+		shard.mu.Unlock()
+		return nil, cacheMiss
+	}
+	data, err := c.open(entry)
+	if err != nil {
+		shard.remove(element) // Sealed under a retired key
+		shard.mu.Unlock()
+		return nil, cacheMiss
+	}
+	lookup := cacheHit
+	if !now.Before(entry.ExpiresAt) {
+		lookup = cacheStale
+		if !now.Before(entry.RefreshAfter) {
+			entry.RefreshAfter = now.Add(staleRefreshBackoff)
+			lookup = cacheStaleRefresh
+		}
+	}
+	shard.lru.MoveToFront(element)
+	entry.AccessCount++
+	createdAt := entry.CreatedAt
+	shard.mu.Unlock()
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(data); err != nil {
+		return nil, cacheMiss
+	}
+	resp.Header.ID = query.Header.ID
+	if lookup == cacheHit {
+		ageRecords(&resp, uint32(now.Sub(createdAt)/time.Second), 0)
+	} else {
+		ageRecords(&resp, ^uint32(0), staleAnswerTTL)
+		atomic.AddInt64(&c.staleServed, 1)
+	}
+	return &resp, lookup
+}
+
+// Put stores an upstream answer for its cacheable TTL, evicting least recently
+// used entries until the shard fits its limits
+func (c *AnonymousCache) Put(query dnsmessage.Message, resp *dnsmessage.Message) {
+	ttl := cacheableTTL(resp)
+	if ttl <= 0 {
+		return
+	}
+	data, err := resp.Pack()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	entry := &CacheEntry{
+		HashedKey: c.key(query.Questions[0]),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	c.seal(entry, data)
+	size := entrySize(entry)
+	shard := c.shard(entry.HashedKey)
+	if size > shard.maxBytes {
+		return
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if element, ok := shard.entries[entry.HashedKey]; ok {
+		// Popularity carries over a refresh at half weight so cold entries stop prefetching
+		entry.AccessCount = element.Value.(*CacheEntry).AccessCount / 2
+		shard.remove(element)
+	}
+	shard.entries[entry.HashedKey] = shard.lru.PushFront(entry)
+	shard.bytes += size
+
+	for len(shard.entries) > shard.maxEntries || shard.bytes > shard.maxBytes {
+		shard.remove(shard.lru.Back())
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
+
+// remove drops an element from a shard; the caller holds the shard lock
+func (s *cacheShard) remove(element *list.Element) {
+	entry := s.lru.Remove(element).(*CacheEntry)
+	delete(s.entries, entry.HashedKey)
+	s.bytes -= entrySize(entry)
+}
+
+// Clear drops every entry and returns how many were removed
+func (c *AnonymousCache) Clear() int {
+	cleared := 0
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		cleared += len(shard.entries)
+		shard.entries = make(map[string]*list.Element)
+		shard.lru.Init()
+		shard.bytes = 0
+		shard.mu.Unlock()
+	}
+	return cleared
+}
+
+// Usage returns the current entry count and estimated memory use
+func (c *AnonymousCache) Usage() (int64, int64) {
+	var entries, bytes int64
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		entries += int64(len(shard.entries))
+		bytes += shard.bytes
+		shard.mu.Unlock()
+	}
+	return entries, bytes
+}
+
+// ============================================================================
+// CACHE PAYLOAD ENCRYPTION
+// Responses are sealed with AES-256-GCM; the entry's hashed key is the associated
+// data, so a payload cannot be moved to another entry. Keys rotate on a schedule
+// and entries sealed under a retired key are dropped
+// ============================================================================
+
+// seal encrypts a packed response into the entry as nonce || ciphertext
+func (c *AnonymousCache) seal(entry *CacheEntry, plaintext []byte) {
+	c.keys.mu.RLock()
+	key := c.keys.current
+	c.keys.mu.RUnlock()
+
+	entry.KeyGeneration = key.generation
+	if c.unsealed {
+		entry.ResponseData = plaintext
+		return
+	}
+
+	nonce := make([]byte, key.aead.NonceSize(), key.aead.NonceSize()+len(plaintext)+key.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	entry.ResponseData = key.aead.Seal(nonce, nonce, plaintext, []byte(entry.HashedKey))
+}
+
+// open decrypts an entry with the current or previous key
+func (c *AnonymousCache) open(entry *CacheEntry) ([]byte, error) {
+	c.keys.mu.RLock()
+	var key *cacheKey
+	for _, candidate := range []*cacheKey{c.keys.current, c.keys.previous} {
+		if candidate != nil && candidate.generation == entry.KeyGeneration {
+			key = candidate
+		}
+	}
+	c.keys.mu.RUnlock()
+	if key == nil {
+		return nil, errCacheKeyRetired
+	}
+	if c.unsealed {
+		return entry.ResponseData, nil
+	}
+
+	size := key.aead.NonceSize()
+	if len(entry.ResponseData) < size {
+		return nil, errors.New("cache payload too short")
+	}
+	return key.aead.Open(nil, entry.ResponseData[:size], entry.ResponseData[size:], []byte(entry.HashedKey))
+}
+
+// keyGeneration returns the generation of the sealing key
+func (c *AnonymousCache) keyGeneration() uint64 {
+	c.keys.mu.RLock()
+	defer c.keys.mu.RUnlock()
+	return c.keys.current.generation
+}
+
+// rotateKey starts sealing with a fresh key, retires the one before the current
+// key and drops the entries it sealed; it returns how many were dropped
+func (c *AnonymousCache) rotateKey() int {
+	c.keys.mu.Lock()
+	c.keys.previous = c.keys.current
+	c.keys.current = newCacheKey(c.keys.previous.generation + 1)
+	oldest := c.keys.previous.generation
+	c.keys.mu.Unlock()
+
+	dropped := 0
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		for element := shard.lru.Front(); element != nil; {
+			next := element.Next()
+			if element.Value.(*CacheEntry).KeyGeneration < oldest {
+				shard.remove(element)
+				dropped++
+			}
+			element = next
+		}
+		shard.mu.Unlock()
+	}
+	return dropped
+}
+
+// startKeyRotation rotates the payload key every interval until ctx is done
+func (c *AnonymousCache) startKeyRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.rotateKey()
+		}
+	}
+}
+
+// ============================================================================
+// PREFETCHING
+// Hot entries are refreshed within cachePrefetchWindow of expiry so clients keep
+// hitting the cache. Popularity is only the access counter on each hashed key;
+// the question is recovered from the sealed payload when a prefetch is due
+// ============================================================================
+
+// prefetchCandidate is a hot entry close to expiry
+type prefetchCandidate struct {
+	question dnsmessage.Question
+	hits     int64
+}
+
+// prefetchCandidates returns hot entries expiring within the window, most popular
+// first, and marks them so stale lookups do not refresh them a second time
+func (c *AnonymousCache) prefetchCandidates(now time.Time) []prefetchCandidate {
+	var candidates []prefetchCandidate
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		for _, element := range shard.entries {
+			entry := element.Value.(*CacheEntry)
+			remaining := entry.ExpiresAt.Sub(now)
+			if entry.AccessCount < prefetchMinHits || remaining <= 0 || remaining > cachePrefetchWindow ||
+				entry.ExpiresAt.Sub(entry.CreatedAt) <= cachePrefetchWindow || now.Before(entry.RefreshAfter) {
+				continue
+			}
+			data, err := c.open(entry)
+			if err != nil {
+				continue
+			}
+			var parser dnsmessage.Parser
+			if _, err := parser.Start(data); err != nil {
+				continue
+			}
+			question, err := parser.Question()
+			if err != nil {
+				continue
+			}
+			entry.RefreshAfter = now.Add(staleRefreshBackoff)
+			candidates = append(candidates, prefetchCandidate{question: question, hits: entry.AccessCount})
+		}
+		shard.mu.Unlock()
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].hits > candidates[j].hits })
+	return candidates
+}
+
+// startPrefetching refreshes hot entries through refresh, at most cachePrefetchRate
+// queries per second, until ctx is done; candidates beyond one scan's budget are skipped
+func (c *AnonymousCache) startPrefetching(ctx context.Context, refresh func(dnsmessage.Question) error) {
+	if cachePrefetchWindow <= 0 {
+		return
+	}
+	ticker := time.NewTicker(prefetchInterval)
+	defer ticker.Stop()
+	pace := time.NewTicker(time.Second / time.Duration(cachePrefetchRate))
+	defer pace.Stop()
+
+	budget := cachePrefetchRate * int(prefetchInterval/time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		candidates := c.prefetchCandidates(time.Now())
+		if len(candidates) > budget {
+			atomic.AddInt64(&c.prefetchSkipped, int64(len(candidates)-budget))
+			candidates = candidates[:budget]
+		}
+
+		for _, candidate := range candidates {
+			select {
+			case <-ctx.Done():
+				return
+			case <-pace.C:
+			}
+			go func(question dnsmessage.Question) {
+				atomic.AddInt64(&c.prefetched, 1)
+				if err := refresh(question); err != nil {
+					atomic.AddInt64(&c.prefetchFailed, 1)
+				}
+			}(candidate.question)
+		}
+	}
+}
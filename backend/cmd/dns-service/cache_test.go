@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// cacheTestExchange builds a query for domain and a cacheable A answer to it
+func cacheTestExchange(tb testing.TB, domain string) (dnsmessage.Message, *dnsmessage.Message) {
+	tb.Helper()
+
+	query, err := newQuery(domain, dnsmessage.TypeA)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	resp := &dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.Header.ID, Response: true, RecursionAvailable: true},
+		Questions: query.Questions,
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+			Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+		}},
+	}
+	return query, resp
+}
+
+func TestCachePayloadSealed(t *testing.T) {
+	cache := NewAnonymousCache(100)
+	query, resp := cacheTestExchange(t, "secret-site.example")
+	cache.Put(query, resp)
+
+	for _, shard := range cache.shards {
+		for _, element := range shard.entries {
+			entry := element.Value.(*CacheEntry)
+			if bytes.Contains(entry.ResponseData, []byte("secret-site")) {
+				t.Error("cached payload contains the queried name in the clear")
+			}
+		}
+	}
+	if got, lookup := cache.Get(query); lookup != cacheHit || len(got.Answers) != 1 {
+		t.Errorf("Get = %v with %d answers, want a hit", lookup, len(got.Answers))
+	}
+}
+
+func TestCacheKeyRotationLifetime(t *testing.T) {
+	if cacheKeyRotation < cacheTTLMinutes*time.Minute+cacheMaxStale {
+		t.Errorf("cacheKeyRotation %v is shorter than the TTL cap plus staleness", cacheKeyRotation)
+	}
+
+	cache := NewAnonymousCache(100)
+	oldQuery, oldResp := cacheTestExchange(t, "old.example")
+	cache.Put(oldQuery, oldResp)
+
+	// Sealed just before a rotation: still readable with the previous key
+	cache.rotateKey()
+	if _, lookup := cache.Get(oldQuery); lookup != cacheHit {
+		t.Errorf("after one rotation: lookup %v, want a hit", lookup)
+	}
+
+	freshQuery, freshResp := cacheTestExchange(t, "new.example")
+	cache.Put(freshQuery, freshResp)
+
+	// Two rotations retire the key; its entries are dropped
+	if dropped := cache.rotateKey(); dropped != 1 {
+		t.Errorf("second rotation dropped %d entries, want 1", dropped)
+	}
+	if _, lookup := cache.Get(oldQuery); lookup != cacheMiss {
+		t.Errorf("after two rotations: lookup %v, want a miss", lookup)
+	}
+	if _, lookup := cache.Get(freshQuery); lookup != cacheHit {
+		t.Errorf("entry sealed after the first rotation: lookup %v, want a hit", lookup)
+	}
+}
+
+func TestCacheKeyRotationStops(t *testing.T) {
+	cache := NewAnonymousCache(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.startKeyRotation(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cache.keyGeneration() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if cache.keyGeneration() < 3 {
+		t.Error("key was not rotated")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("key rotation did not stop")
+	}
+}
+
+func TestCachePrefetchCandidates(t *testing.T) {
+	cache := NewAnonymousCache(100)
+	hotQuery, hotResp := cacheTestExchange(t, "hot.example")
+	coldQuery, coldResp := cacheTestExchange(t, "cold.example")
+	cache.Put(hotQuery, hotResp)
+	cache.Put(coldQuery, coldResp)
+	for i := 0; i < prefetchMinHits; i++ {
+		cache.Get(hotQuery)
+	}
+	cache.Get(coldQuery)
+
+	if candidates := cache.prefetchCandidates(time.Now()); len(candidates) != 0 {
+		t.Errorf("%d candidates long before expiry, want none", len(candidates))
+	}
+
+	nearExpiry := time.Now().Add(300*time.Second - cachePrefetchWindow/2)
+	candidates := cache.prefetchCandidates(nearExpiry)
+	if len(candidates) != 1 || candidates[0].question.Name != hotQuery.Questions[0].Name {
+		t.Fatalf("candidates = %v, want hot.example only", candidates)
+	}
+	if again := cache.prefetchCandidates(nearExpiry); len(again) != 0 {
+		t.Errorf("hot.example offered again within the refresh backoff")
+	}
+}
+
+func TestCachePrefetchingStops(t *testing.T) {
+	cache := NewAnonymousCache(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.startPrefetching(ctx, func(dnsmessage.Question) error { return nil })
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("prefetching did not stop")
+	}
+}
+
+// cachedEntry returns the entry stored for a query, or nil
+func cachedEntry(cache *AnonymousCache, query dnsmessage.Message) *CacheEntry {
+	key := cache.key(query.Questions[0])
+	shard := cache.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if element, ok := shard.entries[key]; ok {
+		return element.Value.(*CacheEntry)
+	}
+	return nil
+}
+
+// backdateEntry moves an entry's lifetime into the past as if it was cached age ago
+func backdateEntry(cache *AnonymousCache, query dnsmessage.Message, age time.Duration) {
+	key := cache.key(query.Questions[0])
+	shard := cache.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry := shard.entries[key].Value.(*CacheEntry)
+	entry.CreatedAt = entry.CreatedAt.Add(-age)
+	entry.ExpiresAt = entry.ExpiresAt.Add(-age)
+	entry.RefreshAfter = entry.RefreshAfter.Add(-age)
+}
+
+// sameShardQueries returns count queries whose keys fall in one shard
+func sameShardQueries(t *testing.T, cache *AnonymousCache, count int) ([]dnsmessage.Message, []*dnsmessage.Message, *cacheShard) {
+	t.Helper()
+
+	byShard := map[*cacheShard][]int{}
+	var queries []dnsmessage.Message
+	var responses []*dnsmessage.Message
+	for i := 0; ; i++ {
+		query, resp := cacheTestExchange(t, fmt.Sprintf("host%04d.example", i)) // Equal lengths give equal entry sizes
+		queries, responses = append(queries, query), append(responses, resp)
+		shard := cache.shard(cache.key(query.Questions[0]))
+		byShard[shard] = append(byShard[shard], i)
+		if indexes := byShard[shard]; len(indexes) == count {
+			var sameQueries []dnsmessage.Message
+			var sameResponses []*dnsmessage.Message
+			for _, index := range indexes {
+				sameQueries, sameResponses = append(sameQueries, queries[index]), append(sameResponses, responses[index])
+			}
+			return sameQueries, sameResponses, shard
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	cache := NewAnonymousCache(100)
+	question := func(name string, qtype dnsmessage.Type, class dnsmessage.Class) dnsmessage.Question {
+		return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: qtype, Class: class}
+	}
+	www := question("www.example.", dnsmessage.TypeA, dnsmessage.ClassINET)
+
+	if cache.key(www) != cache.key(www) {
+		t.Error("the same question hashed to different keys")
+	}
+	if cache.key(question("WWW.Example.", dnsmessage.TypeA, dnsmessage.ClassINET)) != cache.key(www) {
+		t.Error("keys differ by name case")
+	}
+	for _, other := range []dnsmessage.Question{
+		question("www.example.", dnsmessage.TypeAAAA, dnsmessage.ClassINET),
+		question("www.example.", dnsmessage.TypeA, dnsmessage.ClassCHAOS),
+		question("ww.example.", dnsmessage.TypeA, dnsmessage.ClassINET),
+	} {
+		if cache.key(other) == cache.key(www) {
+			t.Errorf("%v shares the key of %v", other, www)
+		}
+	}
+
+	// The salt is per cache, so keys do not carry over between boots
+	if NewAnonymousCache(100).key(www) == cache.key(www) {
+		t.Error("two caches produced the same key")
+	}
+
+	// A query in another case is answered from the same entry
+	query, resp := cacheTestExchange(t, "www.example")
+	cache.Put(query, resp)
+	upper := mustQuery(t, "WWW.EXAMPLE", dnsmessage.TypeA)
+	if _, lookup := cache.Get(upper); lookup != cacheHit {
+		t.Errorf("upper-case lookup = %v, want a hit", lookup)
+	}
+}
+
+func TestCacheShardEvictsByEntryCount(t *testing.T) {
+	cache := NewAnonymousCache(2 * cacheShardCount) // Two entries per shard
+	queries, responses, shard := sameShardQueries(t, cache, 3)
+	if shard.maxEntries != 2 {
+		t.Fatalf("shard holds %d entries, want 2", shard.maxEntries)
+	}
+
+	cache.Put(queries[0], responses[0])
+	cache.Put(queries[1], responses[1])
+	cache.Get(queries[0]) // Most recently used now
+	cache.Put(queries[2], responses[2])
+
+	for i, want := range []cacheLookup{cacheHit, cacheMiss, cacheHit} {
+		if _, lookup := cache.Get(queries[i]); lookup != want {
+			t.Errorf("%s: lookup %v, want %v", queries[i].Questions[0].Name, lookup, want)
+		}
+	}
+	if evictions := atomic.LoadInt64(&cache.evictions); evictions != 1 {
+		t.Errorf("evictions = %d, want 1", evictions)
+	}
+	if entries, _ := cache.Usage(); entries != 2 {
+		t.Errorf("entries = %d, want 2", entries)
+	}
+}
+
+func TestCacheShardEvictsByBytes(t *testing.T) {
+	cache := NewAnonymousCache(1000)
+	queries, responses, shard := sameShardQueries(t, cache, 3)
+
+	cache.Put(queries[0], responses[0])
+	size := entrySize(cachedEntry(cache, queries[0]))
+	shard.mu.Lock()
+	shard.maxBytes = 2*size + size/2 // Room for two entries
+	shard.mu.Unlock()
+
+	cache.Put(queries[1], responses[1])
+	cache.Put(queries[2], responses[2])
+	for i, want := range []cacheLookup{cacheMiss, cacheHit, cacheHit} {
+		if _, lookup := cache.Get(queries[i]); lookup != want {
+			t.Errorf("%s: lookup %v, want %v", queries[i].Questions[0].Name, lookup, want)
+		}
+	}
+	if _, bytes := cache.Usage(); bytes != 2*size {
+		t.Errorf("usage = %d bytes, want %d", bytes, 2*size)
+	}
+
+	// An answer larger than the whole shard is not stored, and evicts nothing
+	big, bigResp := cacheTestExchange(t, "big.example")
+	for i := 0; i < 40; i++ {
+		bigResp.Answers = append(bigResp.Answers, bigResp.Answers[0])
+	}
+	bigShard := cache.shard(cache.key(big.Questions[0]))
+	bigShard.mu.Lock()
+	bigShard.maxBytes = size
+	bigShard.mu.Unlock()
+	cache.Put(big, bigResp)
+	if _, lookup := cache.Get(big); lookup != cacheMiss {
+		t.Errorf("oversized answer: lookup %v, want a miss", lookup)
+	}
+}
+
+func TestCacheMinimumTTL(t *testing.T) {
+	cache := NewAnonymousCache(100)
+	query, resp := cacheTestExchange(t, "www.example")
+	resp.Answers = append(resp.Answers, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+		Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}},
+	})
+	cache.Put(query, resp)
+
+	// The entry lives as long as the shortest record
+	entry := cachedEntry(cache, query)
+	if lifetime := entry.ExpiresAt.Sub(entry.CreatedAt); lifetime != 60*time.Second {
+		t.Errorf("entry lifetime = %v, want 60s", lifetime)
+	}
+
+	// Every record's TTL drops by the time spent in the cache
+	backdateEntry(cache, query, 20*time.Second)
+	got, lookup := cache.Get(query)
+	if lookup != cacheHit || got.Header.ID != query.Header.ID {
+		t.Fatalf("after 20s: lookup %v ID %d, want a hit with ID %d", lookup, got.Header.ID, query.Header.ID)
+	}
+	if got.Answers[0].Header.TTL != 280 || got.Answers[1].Header.TTL != 40 {
+		t.Errorf("after 20s: TTLs %d and %d, want 280 and 40", got.Answers[0].Header.TTL, got.Answers[1].Header.TTL)
+	}
+
+	// Past the shortest TTL the answer is no longer fresh
+	backdateEntry(cache, query, 41*time.Second)
+	if _, lookup := cache.Get(query); lookup == cacheHit {
+		t.Error("answer still fresh past its shortest TTL")
+	}
+
+	// TTLs above the cap are cached for the cap only
+	long, longResp := cacheTestExchange(t, "long.example")
+	longResp.Answers[0].Header.TTL = 7 * 24 * 3600
+	cache.Put(long, longResp)
+	entry = cachedEntry(cache, long)
+	if lifetime := entry.ExpiresAt.Sub(entry.CreatedAt); lifetime != cacheTTLMinutes*time.Minute {
+		t.Errorf("long TTL lifetime = %v, want %v", lifetime, cacheTTLMinutes*time.Minute)
+	}
+}
+
+// negativeTestAnswer builds an NXDOMAIN or NODATA answer, with an SOA when soaTTL is non-zero
+func negativeTestAnswer(t *testing.T, domain string, rcode dnsmessage.RCode, soaTTL, soaMinimum uint32) (dnsmessage.Message, *dnsmessage.Message) {
+	t.Helper()
+
+	query, resp := cacheTestExchange(t, domain)
+	resp.Header.RCode = rcode
+	resp.Answers = nil
+	if soaTTL > 0 {
+		resp.Authorities = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("example."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: soaTTL},
+			Body: &dnsmessage.SOAResource{
+				NS:     dnsmessage.MustNewName("ns.example."),
+				MBox:   dnsmessage.MustNewName("hostmaster.example."),
+				Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, MinTTL: soaMinimum,
+			},
+		}}
+	}
+	return query, resp
+}
+
+func TestCacheableTTL(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() *dnsmessage.Message
+		ttl   time.Duration
+	}{
+		{"positive", func() *dnsmessage.Message {
+			_, resp := cacheTestExchange(t, "www.example")
+			return resp
+		}, 300 * time.Second},
+		{"NXDOMAIN uses the SOA minimum", func() *dnsmessage.Message {
+			_, resp := negativeTestAnswer(t, "missing.example", dnsmessage.RCodeNameError, 3600, 900)
+			return resp
+		}, 900 * time.Second},
+		{"NXDOMAIN uses the SOA TTL", func() *dnsmessage.Message {
+			_, resp := negativeTestAnswer(t, "missing.example", dnsmessage.RCodeNameError, 120, 900)
+			return resp
+		}, 120 * time.Second},
+		{"NODATA uses the SOA minimum", func() *dnsmessage.Message {
+			_, resp := negativeTestAnswer(t, "www.example", dnsmessage.RCodeSuccess, 3600, 300)
+			return resp
+		}, 300 * time.Second},
+		{"NODATA uses the SOA TTL", func() *dnsmessage.Message {
+			_, resp := negativeTestAnswer(t, "www.example", dnsmessage.RCodeSuccess, 60, 300)
+			return resp
+		}, 60 * time.Second},
+		{"negative TTL capped", func() *dnsmessage.Message {
+			_, resp := negativeTestAnswer(t, "missing.example", dnsmessage.RCodeNameError, 86400, 86400)
+			return resp
+		}, cacheTTLMinutes * time.Minute},
+		{"NXDOMAIN without an SOA", func() *dnsmessage.Message {
+			_, resp := negativeTestAnswer(t, "missing.example", dnsmessage.RCodeNameError, 0, 0)
+			return resp
+		}, 0},
+		{"NODATA without an SOA", func() *dnsmessage.Message {
+			_, resp := negativeTestAnswer(t, "www.example", dnsmessage.RCodeSuccess, 0, 0)
+			return resp
+		}, 0},
+		{"SERVFAIL", func() *dnsmessage.Message {
+			_, resp := negativeTestAnswer(t, "www.example", dnsmessage.RCodeServerFailure, 3600, 300)
+			return resp
+		}, 0},
+		{"truncated", func() *dnsmessage.Message {
+			_, resp := cacheTestExchange(t, "www.example")
+			resp.Header.Truncated = true
+			return resp
+		}, 0},
+	}
+	for _, test := range tests {
+		if ttl := cacheableTTL(test.build()); ttl != test.ttl {
+			t.Errorf("%s: cacheableTTL = %v, want %v", test.name, ttl, test.ttl)
+		}
+	}
+}
+
+func TestCacheNegativeAnswers(t *testing.T) {
+	cache := NewAnonymousCache(100)
+
+	query, resp := negativeTestAnswer(t, "missing.example", dnsmessage.RCodeNameError, 3600, 900)
+	cache.Put(query, resp)
+	got, lookup := cache.Get(query)
+	if lookup != cacheHit || got.Header.RCode != dnsmessage.RCodeNameError || len(got.Authorities) != 1 {
+		t.Fatalf("NXDOMAIN: lookup %v, %+v; want a cached NXDOMAIN with its SOA", lookup, got)
+	}
+	if entry := cachedEntry(cache, query); entry.ExpiresAt.Sub(entry.CreatedAt) != 900*time.Second {
+		t.Errorf("NXDOMAIN lifetime = %v, want the SOA minimum", entry.ExpiresAt.Sub(entry.CreatedAt))
+	}
+
+	// Without an SOA there is no negative TTL to honour
+	bare, bareResp := negativeTestAnswer(t, "bare.example", dnsmessage.RCodeNameError, 0, 0)
+	cache.Put(bare, bareResp)
+	if _, lookup := cache.Get(bare); lookup != cacheMiss {
+		t.Errorf("NXDOMAIN without an SOA: lookup %v, want a miss", lookup)
+	}
+}
+
+func TestCacheServeStale(t *testing.T) {
+	cache := NewAnonymousCache(100)
+	query, resp := cacheTestExchange(t, "www.example")
+	cache.Put(query, resp)
+
+	// Expired within cacheMaxStale: served with the stale TTL, and refreshed
+	backdateEntry(cache, query, 301*time.Second)
+	got, lookup := cache.Get(query)
+	if lookup != cacheStaleRefresh {
+		t.Fatalf("expired answer: lookup %v, want stale with a refresh", lookup)
+	}
+	if got.Header.ID != query.Header.ID || got.Answers[0].Header.TTL != staleAnswerTTL {
+		t.Errorf("stale answer ID %d TTL %d, want ID %d TTL %d", got.Header.ID, got.Answers[0].Header.TTL, query.Header.ID, staleAnswerTTL)
+	}
+	if served := atomic.LoadInt64(&cache.staleServed); served != 1 {
+		t.Errorf("stale served = %d, want 1", served)
+	}
+
+	// Past the maximum staleness the entry is gone
+	backdateEntry(cache, query, cacheMaxStale)
+	if _, lookup := cache.Get(query); lookup != cacheMiss {
+		t.Errorf("past cacheMaxStale: lookup %v, want a miss", lookup)
+	}
+	if entries, _ := cache.Usage(); entries != 0 {
+		t.Errorf("%d entries left, want the expired one removed", entries)
+	}
+}
+
+func TestCacheStaleRefreshOnce(t *testing.T) {
+	cache := NewAnonymousCache(100)
+	query, resp := cacheTestExchange(t, "www.example")
+	cache.Put(query, resp)
+	backdateEntry(cache, query, 301*time.Second)
+
+	// Of many concurrent lookups of a stale answer, exactly one refreshes it
+	const lookups = 32
+	var refreshes, stale atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch _, lookup := cache.Get(query); lookup {
+			case cacheStaleRefresh:
+				refreshes.Add(1)
+			case cacheStale:
+				stale.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if refreshes.Load() != 1 || stale.Load() != lookups-1 {
+		t.Errorf("%d refreshes and %d stale answers, want 1 and %d", refreshes.Load(), stale.Load(), lookups-1)
+	}
+
+	// A failed refresh is retried once the backoff has passed
+	backdateEntry(cache, query, staleRefreshBackoff)
+	if _, lookup := cache.Get(query); lookup != cacheStaleRefresh {
+		t.Errorf("after the backoff: lookup %v, want another refresh", lookup)
+	}
+
+	// A successful refresh replaces the entry with a fresh answer
+	cache.Put(query, resp)
+	if _, lookup := cache.Get(query); lookup != cacheHit {
+		t.Errorf("after the refresh: lookup %v, want a hit", lookup)
+	}
+}
+
+// benchmarkCache fills a cache with entries and returns their queries
+func benchmarkCache(b *testing.B, sealed bool) (*AnonymousCache, []dnsmessage.Message, []*dnsmessage.Message) {
+	b.Helper()
+
+	cache := NewAnonymousCache(10000)
+	cache.unsealed = !sealed
+	queries := make([]dnsmessage.Message, 1000)
+	responses := make([]*dnsmessage.Message, len(queries))
+	for i := range queries {
+		queries[i], responses[i] = cacheTestExchange(b, fmt.Sprintf("host%d.example", i))
+		cache.Put(queries[i], responses[i])
+	}
+	return cache, queries, responses
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	for _, sealed := range []bool{true, false} {
+		b.Run(fmt.Sprintf("sealed=%v", sealed), func(b *testing.B) {
+			cache, queries, _ := benchmarkCache(b, sealed)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.Get(queries[i%len(queries)])
+			}
+		})
+	}
+}
+
+func BenchmarkCachePut(b *testing.B) {
+	for _, sealed := range []bool{true, false} {
+		b.Run(fmt.Sprintf("sealed=%v", sealed), func(b *testing.B) {
+			cache, queries, responses := benchmarkCache(b, sealed)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.Put(queries[i%len(queries)], responses[i%len(responses)])
+			}
+		})
+	}
+}
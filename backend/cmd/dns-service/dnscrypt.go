@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// DNSCRYPT V2
+// sdns:// stamps, signed resolver certificates and X25519-XSalsa20Poly1305
+// encrypted queries over UDP (TCP when truncated)
+// Privacy: A fresh client key pair per query; only the provider name is sent in clear
+// ============================================================================
+
+const (
+	dnscryptStampProtocol  = 0x01   // sdns:// protocol identifier for DNSCrypt
+	dnscryptESXSalsa20     = 0x0001 // es-version: X25519-XSalsa20Poly1305
+	dnscryptCertSize       = 124    // Certificate without extensions
+	dnscryptMinUDPQuery    = 256    // Minimum padded query size over UDP
+	dnscryptPaddingBlock   = 64
+	dnscryptMaxPacket      = 65535
+	dnscryptDefaultPort    = "443"
+	dnscryptCertRefresh    = time.Hour // Certificates are re-fetched at least this often
+	dnscryptClientNonceLen = 12
+)
+
+var (
+	dnscryptCertMagic     = []byte("DNSC")
+	dnscryptResolverMagic = []byte{0x72, 0x36, 0x66, 0x6e, 0x76, 0x57, 0x6a, 0x38} // "r6fnvWj8"
+)
+
+// DNSCryptStamp is a decoded sdns:// stamp for a DNSCrypt resolver
+type DNSCryptStamp struct {
+	Props        uint64            // Bit 0: DNSSEC, bit 1: no logs, bit 2: no filter
+	Address      string            // "ip:port"
+	ProviderKey  ed25519.PublicKey // Signs the resolver certificates
+	ProviderName string            // e.g. "2.dnscrypt-cert.example.com"
+}
+
+// dnscryptCert is a verified resolver certificate
+type dnscryptCert struct {
+	resolverKey [32]byte
+	clientMagic [8]byte
+	serial      uint32
+	notAfter    time.Time
+	fetchedAt   time.Time
+}
+
+var (
+	dnscryptCerts    = map[string]*dnscryptCert{} // Stamp -> current certificate
+	dnscryptCertsMux sync.Mutex
+)
+
+// parseDNSCryptStamp decodes an sdns:// stamp: protocol, props, LP(addr), LP(pk), LP(provider name)
+func parseDNSCryptStamp(stamp string) (*DNSCryptStamp, error) {
+	encoded, found := strings.CutPrefix(stamp, "sdns://")
+	if !found {
+		return nil, fmt.Errorf("stamp must start with sdns://")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, fmt.Errorf("stamp is not base64url: %w", err)
+	}
+	if len(raw) < 9 || raw[0] != dnscryptStampProtocol {
+		return nil, fmt.Errorf("stamp is not a DNSCrypt stamp")
+	}
+
+	parsed := &DNSCryptStamp{Props: binary.LittleEndian.Uint64(raw[1:9])}
+	rest := raw[9:]
+	var fields [3][]byte
+	for i := range fields {
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, fmt.Errorf("stamp is truncated")
+		}
+		fields[i], rest = rest[1:1+int(rest[0])], rest[1+int(rest[0]):]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("stamp has trailing data")
+	}
+
+	parsed.Address = string(fields[0])
+	if _, _, err := net.SplitHostPort(parsed.Address); err != nil {
+		parsed.Address = net.JoinHostPort(strings.Trim(parsed.Address, "[]"), dnscryptDefaultPort)
+	}
+	if len(fields[1]) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("stamp provider key must be %d bytes", ed25519.PublicKeySize)
+	}
+	parsed.ProviderKey = ed25519.PublicKey(fields[1])
+	parsed.ProviderName = strings.TrimSuffix(string(fields[2]), ".")
+	if parsed.ProviderName == "" {
+		return nil, fmt.Errorf("stamp has no provider name")
+	}
+	return parsed, nil
+}
+
+// parseDNSCryptCert verifies one certificate: magic, version, signature and validity window
+// Layout: magic(4) es-version(2) minor(2) signature(64) | resolver-pk(32) client-magic(8) serial(4) ts-start(4) ts-end(4) [extensions]
+func parseDNSCryptCert(raw []byte, providerKey ed25519.PublicKey, now time.Time) (*dnscryptCert, error) {
+	if len(raw) < dnscryptCertSize || !bytes.Equal(raw[:4], dnscryptCertMagic) {
+		return nil, fmt.Errorf("not a DNSCrypt certificate")
+	}
+	if binary.BigEndian.Uint16(raw[4:6]) != dnscryptESXSalsa20 {
+		return nil, fmt.Errorf("unsupported es-version %d", binary.BigEndian.Uint16(raw[4:6]))
+	}
+	if !ed25519.Verify(providerKey, raw[72:], raw[8:72]) {
+		return nil, fmt.Errorf("certificate signature invalid")
+	}
+
+	cert := &dnscryptCert{
+		serial:    binary.BigEndian.Uint32(raw[112:116]),
+		notAfter:  time.Unix(int64(binary.BigEndian.Uint32(raw[120:124])), 0),
+		fetchedAt: now,
+	}
+	notBefore := time.Unix(int64(binary.BigEndian.Uint32(raw[116:120])), 0)
+	if now.Before(notBefore) || !now.Before(cert.notAfter) {
+		return nil, fmt.Errorf("certificate not valid at this time")
+	}
+	copy(cert.resolverKey[:], raw[72:104])
+	copy(cert.clientMagic[:], raw[104:112])
+	return cert, nil
+}
+
+// fetchDNSCryptCert asks the resolver for its certificates (TXT for the provider name)
+// and keeps the valid one with the highest serial
+func fetchDNSCryptCert(ctx context.Context, stamp *DNSCryptStamp) (*dnscryptCert, error) {
+	query, err := newQuery(stamp.ProviderName, dnsmessage.TypeTXT)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provider name")
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := packetExchange(ctx, "udp", stamp.Address, packed)
+	if err == nil && isTruncated(raw) {
+		raw, err = packetExchange(ctx, "tcp", stamp.Address, packed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("certificate request failed: %w", err)
+	}
+	resp, err := parseUpstreamResponse(query, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *dnscryptCert
+	lastErr := fmt.Errorf("resolver returned no certificates")
+	now := time.Now()
+	for _, answer := range resp.Answers {
+		txt, ok := answer.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		cert, err := parseDNSCryptCert([]byte(strings.Join(txt.TXT, "")), stamp.ProviderKey, now)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if best == nil || cert.serial > best.serial {
+			best = cert
+		}
+	}
+	if best == nil {
+		return nil, lastErr
+	}
+	return best, nil
+}
+
+// dnscryptCertificate returns the cached certificate for a stamp, re-fetching it when stale
+func dnscryptCertificate(ctx context.Context, stampText string, stamp *DNSCryptStamp) (*dnscryptCert, error) {
+	dnscryptCertsMux.Lock()
+	cert, ok := dnscryptCerts[stampText]
+	dnscryptCertsMux.Unlock()
+
+	now := time.Now()
+	if ok && now.Before(cert.notAfter) && now.Sub(cert.fetchedAt) < dnscryptCertRefresh {
+		return cert, nil
+	}
+
+	cert, err := fetchDNSCryptCert(ctx, stamp)
+	if err != nil {
+		return nil, err
+	}
+
+	dnscryptCertsMux.Lock()
+	dnscryptCerts[stampText] = cert
+	dnscryptCertsMux.Unlock()
+	return cert, nil
+}
+
+// packetExchange sends one packet over UDP or length-prefixed TCP and returns the reply
+// Carries certificate requests (no user query) and encrypted DNSCrypt queries
+func packetExchange(ctx context.Context, network, address string, msg []byte) ([]byte, error) {
+	dialer := &net.Dialer{Timeout: connectionTimeoutSeconds * time.Second}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(defaultTimeoutSeconds * time.Second)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, dnscryptMaxPacket)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	if _, err := conn.Write(frame); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// isTruncated reports whether a packed DNS message has the TC flag set
+func isTruncated(msg []byte) bool {
+	return len(msg) > 2 && msg[2]&0x02 != 0
+}
+
+// dnscryptPad applies ISO/IEC 7816-4 padding (0x80 then zeros) up to a multiple
+// of the padding block and at least minLength bytes
+func dnscryptPad(msg []byte, minLength int) []byte {
+	length := (len(msg) + 1 + dnscryptPaddingBlock - 1) / dnscryptPaddingBlock * dnscryptPaddingBlock
+	if length < minLength {
+		length = minLength
+	}
+	padded := make([]byte, length)
+	copy(padded, msg)
+	padded[len(msg)] = 0x80
+	return padded
+}
+
+// dnscryptUnpad removes ISO/IEC 7816-4 padding
+func dnscryptUnpad(padded []byte) ([]byte, error) {
+	end := bytes.LastIndexByte(padded, 0x80)
+	if end < 0 || len(bytes.Trim(padded[end+1:], "\x00")) != 0 {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return padded[:end], nil
+}
+
+// dnscryptRoundTrip encrypts a query, sends it and decrypts the answer
+// Query:    client-magic(8) client-pk(32) client-nonce(12) box(padded query)
+// Response: resolver-magic(8) nonce(24) box(padded answer)
+func dnscryptRoundTrip(ctx context.Context, network, address string, cert *dnscryptCert, query []byte) ([]byte, error) {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var sharedKey [32]byte
+	box.Precompute(&sharedKey, &cert.resolverKey, privateKey)
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:dnscryptClientNonceLen]); err != nil {
+		return nil, err
+	}
+
+	minLength := 0
+	if network == "udp" {
+		minLength = dnscryptMinUDPQuery
+	}
+	packet := make([]byte, 0, 8+32+dnscryptClientNonceLen+dnscryptMinUDPQuery+box.Overhead)
+	packet = append(packet, cert.clientMagic[:]...)
+	packet = append(packet, publicKey[:]...)
+	packet = append(packet, nonce[:dnscryptClientNonceLen]...)
+	packet = box.SealAfterPrecomputation(packet, dnscryptPad(query, minLength), &nonce, &sharedKey)
+
+	resp, err := packetExchange(ctx, network, address, packet)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp) < 8+24+box.Overhead || !bytes.Equal(resp[:8], dnscryptResolverMagic) {
+		return nil, fmt.Errorf("not a DNSCrypt response")
+	}
+	var responseNonce [24]byte
+	copy(responseNonce[:], resp[8:32])
+	if !bytes.Equal(responseNonce[:dnscryptClientNonceLen], nonce[:dnscryptClientNonceLen]) {
+		return nil, fmt.Errorf("DNSCrypt response nonce mismatch")
+	}
+	padded, ok := box.OpenAfterPrecomputation(nil, resp[32:], &responseNonce, &sharedKey)
+	if !ok {
+		return nil, fmt.Errorf("DNSCrypt response failed authentication")
+	}
+	return dnscryptUnpad(padded)
+}
+
+// exchangeDNSCrypt sends a packed query to a DNSCrypt server and returns the packed answer
+// UDP is tried first; truncated answers are repeated over TCP
+func exchangeDNSCrypt(ctx context.Context, server DNSServer, query []byte) ([]byte, error) {
+	stamp, err := parseDNSCryptStamp(server.Stamp)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := dnscryptCertificate(ctx, server.Stamp, stamp)
+	if err != nil {
+		return nil, fmt.Errorf("DNSCrypt certificate: %w", err)
+	}
+
+	resp, err := dnscryptRoundTrip(ctx, "udp", stamp.Address, cert, query)
+	if err == nil && isTruncated(resp) {
+		resp, err = dnscryptRoundTrip(ctx, "tcp", stamp.Address, cert, query)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("DNSCrypt exchange failed: %w", err)
+	}
+	return resp, nil
+}
+
+// dnscryptServer builds a DNSCrypt-only server from a stamp
+func dnscryptServer(stampText string) (DNSServer, error) {
+	stamp, err := parseDNSCryptStamp(stampText)
+	if err != nil {
+		return DNSServer{}, err
+	}
+	host, port, err := net.SplitHostPort(stamp.Address)
+	if err != nil {
+		return DNSServer{}, err
+	}
+	portNumber, err := net.LookupPort("udp", port)
+	if err != nil {
+		return DNSServer{}, err
+	}
+
+	return DNSServer{
+		Name:       strings.TrimPrefix(stamp.ProviderName, "2.dnscrypt-cert."),
+		Address:    host,
+		ServerName: stamp.ProviderName,
+		Port:       portNumber,
+		Stamp:      stampText,
+		Protocols:  []string{"DNSCrypt"},
+		Healthy:    true,
+	}, nil
+}
+
+// dnscryptInfo describes a server's stamp and certificate for the servers listing
+func dnscryptInfo(server DNSServer) gin.H {
+	stamp, err := parseDNSCryptStamp(server.Stamp)
+	if err != nil {
+		return nil
+	}
+
+	info := gin.H{
+		"provider_name": stamp.ProviderName,
+		"dnssec":        stamp.Props&1 != 0,
+		"no_logs":       stamp.Props&2 != 0,
+		"no_filter":     stamp.Props&4 != 0,
+	}
+
+	dnscryptCertsMux.Lock()
+	if cert, ok := dnscryptCerts[server.Stamp]; ok {
+		info["certificate_serial"] = cert.serial
+		info["certificate_expires"] = cert.notAfter.UTC().Format(time.RFC3339)
+	}
+	dnscryptCertsMux.Unlock()
+	return info
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnscryptTestProvider is the provider name served by the DNSCrypt stub
+const dnscryptTestProvider = "2.dnscrypt-cert.stub.test"
+
+// encodeDNSCryptStamp builds an sdns:// DNSCrypt stamp
+func encodeDNSCryptStamp(props uint64, address string, providerKey []byte, providerName string) string {
+	raw := []byte{dnscryptStampProtocol}
+	raw = binary.LittleEndian.AppendUint64(raw, props)
+	for _, field := range [][]byte{[]byte(address), providerKey, []byte(providerName)} {
+		raw = append(raw, byte(len(field)))
+		raw = append(raw, field...)
+	}
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// signDNSCryptCert builds a certificate for a resolver key, signed by the provider key
+func signDNSCryptCert(providerKey ed25519.PrivateKey, resolverKey *[32]byte, clientMagic [8]byte, serial uint32, notBefore, notAfter time.Time) []byte {
+	signed := append([]byte(nil), resolverKey[:]...)
+	signed = append(signed, clientMagic[:]...)
+	signed = binary.BigEndian.AppendUint32(signed, serial)
+	signed = binary.BigEndian.AppendUint32(signed, uint32(notBefore.Unix()))
+	signed = binary.BigEndian.AppendUint32(signed, uint32(notAfter.Unix()))
+
+	raw := append([]byte(nil), dnscryptCertMagic...)
+	raw = binary.BigEndian.AppendUint16(raw, dnscryptESXSalsa20)
+	raw = binary.BigEndian.AppendUint16(raw, 0)
+	raw = append(raw, ed25519.Sign(providerKey, signed)...)
+	return append(raw, signed...)
+}
+
+// dnscryptStub is an in-process DNSCrypt resolver on one UDP and TCP port
+type dnscryptStub struct {
+	server      DNSServer
+	providerKey ed25519.PrivateKey
+	resolverPub *[32]byte
+	resolverKey *[32]byte
+	clientMagic [8]byte
+
+	mu          sync.Mutex
+	certs       [][]byte // Served for the provider name; one valid certificate by default
+	truncateUDP bool     // Answer encrypted UDP queries with TC set and no records
+	badNonce    bool     // Echo a different client nonce
+	queries     []string // Network and padded size of each decrypted query, e.g. "udp/256"
+}
+
+// startDNSCryptStub serves certificates and encrypted queries for records
+func startDNSCryptStub(t *testing.T, records stubRecords) *dnscryptStub {
+	t.Helper()
+
+	providerPub, providerKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolverPub, resolverKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &dnscryptStub{providerKey: providerKey, resolverPub: resolverPub, resolverKey: resolverKey}
+	copy(stub.clientMagic[:], resolverPub[:8])
+	now := time.Now()
+	stub.certs = [][]byte{signDNSCryptCert(providerKey, resolverPub, stub.clientMagic, 1, now.Add(-time.Hour), now.Add(time.Hour))}
+
+	// TCP on the UDP port, retried in the unlikely case it is taken
+	var packetConn net.PacketConn
+	var listener net.Listener
+	for attempt := 0; listener == nil; attempt++ {
+		if packetConn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if listener, err = net.Listen("tcp", packetConn.LocalAddr().String()); err != nil {
+			packetConn.Close()
+			if attempt == 4 {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		packetConn.Close()
+		listener.Close()
+		wg.Wait()
+		dnscryptCertsMux.Lock()
+		delete(dnscryptCerts, stub.server.Stamp)
+		dnscryptCertsMux.Unlock()
+	})
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, dnscryptMaxPacket)
+		for {
+			n, addr, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := stub.reply(t, "udp", buf[:n], records); reply != nil {
+				packetConn.WriteTo(reply, addr)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err == nil {
+				packet := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, packet); err == nil {
+					reply := stub.reply(t, "tcp", packet, records)
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	address := packetConn.LocalAddr().String()
+	stub.server, err = dnscryptServer(encodeDNSCryptStamp(1, address, providerPub, dnscryptTestProvider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stub
+}
+
+// reply answers a plain certificate request or an encrypted query
+func (stub *dnscryptStub) reply(t *testing.T, network string, packet []byte, records stubRecords) []byte {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	if !bytes.HasPrefix(packet, stub.clientMagic[:]) {
+		return stub.certificates(t, packet)
+	}
+
+	var clientKey, sharedKey [32]byte
+	var nonce [24]byte
+	copy(clientKey[:], packet[8:40])
+	copy(nonce[:], packet[40:40+dnscryptClientNonceLen])
+	box.Precompute(&sharedKey, &clientKey, stub.resolverKey)
+	padded, ok := box.OpenAfterPrecomputation(nil, packet[40+dnscryptClientNonceLen:], &nonce, &sharedKey)
+	if !ok {
+		t.Error("DNSCrypt stub could not decrypt the query")
+		return nil
+	}
+	stub.queries = append(stub.queries, network+"/"+strconv.Itoa(len(padded)))
+	query, err := dnscryptUnpad(padded)
+	if err != nil {
+		t.Errorf("DNSCrypt stub got a badly padded query: %v", err)
+		return nil
+	}
+
+	answer := records.answer(t, query)
+	if network == "udp" && stub.truncateUDP {
+		var truncated dnsmessage.Message
+		truncated.Unpack(answer)
+		truncated.Header.Truncated, truncated.Answers = true, nil
+		answer, _ = truncated.Pack()
+	}
+
+	rand.Read(nonce[dnscryptClientNonceLen:])
+	if stub.badNonce {
+		nonce[0] ^= 0xFF
+	}
+	resp := append(append([]byte(nil), dnscryptResolverMagic...), nonce[:]...)
+	return box.SealAfterPrecomputation(resp, dnscryptPad(answer, 0), &nonce, &sharedKey)
+}
+
+// certificates answers the TXT query for the provider name with every configured certificate
+func (stub *dnscryptStub) certificates(t *testing.T, packet []byte) []byte {
+	var query dnsmessage.Message
+	if err := query.Unpack(packet); err != nil || query.Questions[0].Type != dnsmessage.TypeTXT ||
+		query.Questions[0].Name.String() != dnscryptTestProvider+"." {
+		t.Errorf("DNSCrypt stub got an unexpected plain query: %v", err)
+		return nil
+	}
+
+	resp := dnsmessage.Message{Header: dnsmessage.Header{ID: query.Header.ID, Response: true}, Questions: query.Questions}
+	for _, cert := range stub.certs {
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 3600},
+			Body:   &dnsmessage.TXTResource{TXT: []string{string(cert)}},
+		})
+	}
+	raw, _ := resp.Pack()
+	return raw
+}
+
+// dnscryptExchange resolves a name through exchangeDNSCrypt
+func dnscryptExchange(server DNSServer, domain string) (*dnsmessage.Message, error) {
+	query, _ := newQuery(domain, dnsmessage.TypeA)
+	packed, _ := query.Pack()
+	raw, err := exchangeDNSCrypt(context.Background(), server, packed)
+	if err != nil {
+		return nil, err
+	}
+	return parseUpstreamResponse(query, raw)
+}
+
+func TestParseDNSCryptStamp(t *testing.T) {
+	key := make([]byte, ed25519.PublicKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	stamp, err := parseDNSCryptStamp(encodeDNSCryptStamp(3, "192.0.2.53:8443", key, "2.dnscrypt-cert.example.com."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stamp.Props != 3 || stamp.Address != "192.0.2.53:8443" || !bytes.Equal(stamp.ProviderKey, key) || stamp.ProviderName != "2.dnscrypt-cert.example.com" {
+		t.Errorf("stamp = %+v", stamp)
+	}
+
+	for address, want := range map[string]string{"192.0.2.53": "192.0.2.53:443", "[2001:db8::53]": "[2001:db8::53]:443"} {
+		stamp, err := parseDNSCryptStamp(encodeDNSCryptStamp(0, address, key, "2.dnscrypt-cert.example.com"))
+		if err != nil || stamp.Address != want {
+			t.Errorf("address %s: got %v, %v; want %s", address, stamp, err, want)
+		}
+	}
+
+	valid := encodeDNSCryptStamp(0, "192.0.2.53", key, "2.dnscrypt-cert.example.com")
+	raw, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(valid, "sdns://"))
+	doh := append([]byte{0x02}, raw[1:]...)
+	invalid := map[string]string{
+		"no scheme":      strings.TrimPrefix(valid, "sdns://"),
+		"not base64":     "sdns://!!!",
+		"DoH stamp":      "sdns://" + base64.RawURLEncoding.EncodeToString(doh),
+		"truncated":      "sdns://" + base64.RawURLEncoding.EncodeToString(raw[:len(raw)-3]),
+		"trailing data":  "sdns://" + base64.RawURLEncoding.EncodeToString(append(raw, 0)),
+		"short key":      encodeDNSCryptStamp(0, "192.0.2.53", key[:31], "2.dnscrypt-cert.example.com"),
+		"empty provider": encodeDNSCryptStamp(0, "192.0.2.53", key, ""),
+	}
+	for name, stamp := range invalid {
+		if _, err := parseDNSCryptStamp(stamp); err == nil {
+			t.Errorf("%s: stamp accepted", name)
+		}
+	}
+}
+
+func TestParseDNSCryptCert(t *testing.T) {
+	providerPub, providerKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	resolverPub, _, _ := box.GenerateKey(rand.Reader)
+	magic := [8]byte{'c', 'l', 'i', 'e', 'n', 't', '0', '1'}
+	now := time.Unix(1_800_000_000, 0)
+
+	valid := signDNSCryptCert(providerKey, resolverPub, magic, 7, now.Add(-time.Hour), now.Add(time.Hour))
+	cert, err := parseDNSCryptCert(valid, providerPub, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.serial != 7 || cert.resolverKey != *resolverPub || cert.clientMagic != magic || !cert.notAfter.Equal(now.Add(time.Hour)) {
+		t.Errorf("cert = %+v", cert)
+	}
+
+	tampered := append([]byte(nil), valid...)
+	tampered[len(tampered)-10] ^= 1
+	badVersion := append([]byte(nil), valid...)
+	badVersion[5] = 2
+	invalid := map[string][]byte{
+		"bad magic":     append([]byte("XXXX"), valid[4:]...),
+		"short":         valid[:dnscryptCertSize-1],
+		"es-version 2":  badVersion,
+		"tampered":      tampered,
+		"other signer":  signDNSCryptCert(otherKey, resolverPub, magic, 7, now.Add(-time.Hour), now.Add(time.Hour)),
+		"expired":       signDNSCryptCert(providerKey, resolverPub, magic, 7, now.Add(-2*time.Hour), now.Add(-time.Hour)),
+		"not yet valid": signDNSCryptCert(providerKey, resolverPub, magic, 7, now.Add(time.Hour), now.Add(2*time.Hour)),
+	}
+	for name, raw := range invalid {
+		if _, err := parseDNSCryptCert(raw, providerPub, now); err == nil {
+			t.Errorf("%s: certificate accepted", name)
+		}
+	}
+}
+
+func TestDNSCryptPadding(t *testing.T) {
+	tests := []struct {
+		size, minLength, want int
+	}{
+		{0, 0, 64},
+		{63, 0, 64},
+		{64, 0, 128},
+		{100, dnscryptMinUDPQuery, 256},
+		{300, dnscryptMinUDPQuery, 320},
+	}
+	for _, test := range tests {
+		msg := bytes.Repeat([]byte{0x80}, test.size) // Marker bytes in the message must survive unpadding
+		padded := dnscryptPad(msg, test.minLength)
+		if len(padded) != test.want {
+			t.Errorf("pad(%d, min %d) = %d bytes, want %d", test.size, test.minLength, len(padded), test.want)
+		}
+		unpadded, err := dnscryptUnpad(padded)
+		if err != nil || !bytes.Equal(unpadded, msg) {
+			t.Errorf("unpad(pad(%d bytes)) = %d bytes, %v", test.size, len(unpadded), err)
+		}
+	}
+
+	for _, padded := range [][]byte{{1, 2, 0, 0}, {1, 0x80, 0, 1}} {
+		if _, err := dnscryptUnpad(padded); err == nil {
+			t.Errorf("unpad(%x) accepted invalid padding", padded)
+		}
+	}
+}
+
+func TestExchangeDNSCrypt(t *testing.T) {
+	stub := startDNSCryptStub(t, testZone)
+
+	// A newer valid certificate wins; an expired one with a higher serial is ignored
+	now := time.Now()
+	stub.mu.Lock()
+	stub.certs = append(stub.certs,
+		signDNSCryptCert(stub.providerKey, stub.resolverPub, stub.clientMagic, 2, now.Add(-time.Hour), now.Add(time.Hour)),
+		signDNSCryptCert(stub.providerKey, stub.resolverPub, stub.clientMagic, 9, now.Add(-2*time.Hour), now.Add(-time.Hour)))
+	stub.mu.Unlock()
+
+	resp, err := dnscryptExchange(stub.server, "www.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Errorf("%d answers, want 1", len(resp.Answers))
+	}
+	if info := dnscryptInfo(stub.server); info["certificate_serial"] != uint32(2) {
+		t.Errorf("certificate serial = %v, want 2", info["certificate_serial"])
+	}
+
+	// Truncated UDP answers are repeated over TCP, which has no minimum padding
+	stub.mu.Lock()
+	stub.truncateUDP = true
+	stub.mu.Unlock()
+	if resp, err = dnscryptExchange(stub.server, "www.example"); err != nil || len(resp.Answers) != 1 || resp.Header.Truncated {
+		t.Errorf("TCP fallback: %v, %v", resp, err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	want := []string{"udp/256", "udp/256", "tcp/64"}
+	if strings.Join(stub.queries, " ") != strings.Join(want, " ") {
+		t.Errorf("queries = %v, want %v", stub.queries, want)
+	}
+}
+
+func TestExchangeDNSCryptRejects(t *testing.T) {
+	now := time.Now()
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name      string
+		configure func(stub *dnscryptStub)
+		want      string
+	}{
+		{"foreign signature", func(stub *dnscryptStub) {
+			stub.certs = [][]byte{signDNSCryptCert(otherKey, stub.resolverPub, stub.clientMagic, 1, now.Add(-time.Hour), now.Add(time.Hour))}
+		}, "certificate signature invalid"},
+		{"expired certificate", func(stub *dnscryptStub) {
+			stub.certs = [][]byte{signDNSCryptCert(stub.providerKey, stub.resolverPub, stub.clientMagic, 1, now.Add(-2*time.Hour), now.Add(-time.Hour))}
+		}, "certificate not valid at this time"},
+		{"nonce mismatch", func(stub *dnscryptStub) {
+			stub.badNonce = true
+		}, "nonce mismatch"},
+	}
+	for _, test := range tests {
+		stub := startDNSCryptStub(t, testZone)
+		stub.mu.Lock()
+		test.configure(stub)
+		stub.mu.Unlock()
+		if _, err := dnscryptExchange(stub.server, "www.example"); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// DNS OVER HTTPS (RFC 8484)
+// Wire-format application/dns-message by POST or base64url GET over HTTP/2
+// Privacy: No cookies, no JSON dialect, certificates always verified
+// ============================================================================
+
+const (
+	dohContentType = "application/dns-message"
+	defaultDoHPath = "/dns-query"
+	maxDoHGetURL   = 2048  // Longer GET URLs fall back to POST
+	maxDoHResponse = 65535 // Largest possible DNS message
+	dohIdleTimeout = keepaliveIntervalSeconds * time.Second
+)
+
+// dohMethod selects the request method (DOH_METHOD=GET enables cacheable GET requests)
+var dohMethod = func() string {
+	if strings.EqualFold(os.Getenv("DOH_METHOD"), http.MethodGet) {
+		return http.MethodGet
+	}
+	return http.MethodPost
+}()
+
+// dohHTTPClient shares HTTP/2 connections across all DoH queries to a server
+var dohHTTPClient = &http.Client{
+	Timeout: defaultTimeoutSeconds * time.Second,
+	Transport: &http.Transport{
+		ForceAttemptHTTP2:   true,
+		TLSClientConfig:     &tls.Config{RootCAs: upstreamRootCAs, MinVersion: tls.VersionTLS12},
+		MaxIdleConnsPerHost: maxConnectionsPerServer,
+		IdleConnTimeout:     dohIdleTimeout,
+		TLSHandshakeTimeout: connectionTimeoutSeconds * time.Second,
+	},
+}
+
+// dohURL returns the server's DoH endpoint, defaulting to https://<name>/dns-query
+func dohURL(server DNSServer) string {
+	if server.URL != "" {
+		return server.URL
+	}
+	host := server.ServerName
+	if host == "" {
+		host = server.Address
+	}
+	return "https://" + host + defaultDoHPath
+}
+
+// newDoHRequest builds the RFC 8484 request; GET carries the query as unpadded base64url
+func newDoHRequest(ctx context.Context, endpoint, method string, query []byte) (*http.Request, error) {
+	if method == http.MethodGet {
+		separator := "?"
+		if strings.Contains(endpoint, "?") {
+			separator = "&"
+		}
+		getURL := endpoint + separator + "dns=" + base64.RawURLEncoding.EncodeToString(query)
+		if len(getURL) <= maxDoHGetURL {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, getURL, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Accept", dohContentType)
+			return req, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	return req, nil
+}
+
+// exchangeDoH sends a packed query to a server's DoH endpoint and returns the packed answer
+// GET queries carry message ID 0 so identical questions share one HTTP cache
+// entry (RFC 8484 section 4.1); the caller's ID is restored on the answer
+func exchangeDoH(ctx context.Context, server DNSServer, query []byte, method string) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, fmt.Errorf("DoH query too short")
+	}
+	wire := query
+	id := binary.BigEndian.Uint16(query)
+	if method == http.MethodGet {
+		wire = append([]byte(nil), query...)
+		binary.BigEndian.PutUint16(wire, 0)
+	}
+
+	req, err := newDoHRequest(ctx, dohURL(server), method, wire)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := dohHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DoH request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned status %d", resp.StatusCode)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediaType != dohContentType {
+		return nil, fmt.Errorf("DoH server returned content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponse+1))
+	if err != nil {
+		return nil, fmt.Errorf("DoH response read failed: %w", err)
+	}
+	if len(body) > maxDoHResponse {
+		return nil, fmt.Errorf("DoH response too large")
+	}
+
+	if method == http.MethodGet {
+		if len(body) < 2 || binary.BigEndian.Uint16(body) != 0 {
+			return nil, fmt.Errorf("DoH response has a non-zero message ID")
+		}
+		binary.BigEndian.PutUint16(body, id)
+	}
+	return body, nil
+}
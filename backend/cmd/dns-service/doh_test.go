@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohRequest records what a DoH stub received
+type dohRequest struct {
+	method      string
+	contentType string
+	encoded     string
+	id          uint16
+}
+
+// dohStub answers from testZone and lets a test alter the reply
+type dohStub struct {
+	mu          sync.Mutex
+	requests    []dohRequest
+	contentType string
+	rewrite     func(resp *dnsmessage.Message)
+}
+
+// startRecordingDoHStub serves RFC 8484 queries over HTTP/2 and records each request
+func startRecordingDoHStub(t *testing.T, cert tls.Certificate) (*dohStub, string) {
+	t.Helper()
+
+	stub := &dohStub{contentType: dohContentType}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := dohRequest{method: r.Method, contentType: r.Header.Get("Content-Type")}
+		var packed []byte
+		var err error
+		if r.Method == http.MethodGet {
+			request.encoded = r.URL.Query().Get("dns")
+			packed, err = base64.RawURLEncoding.DecodeString(request.encoded)
+		} else {
+			packed, err = io.ReadAll(r.Body)
+		}
+		if err != nil || len(packed) < 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		request.id = binary.BigEndian.Uint16(packed)
+
+		stub.mu.Lock()
+		stub.requests = append(stub.requests, request)
+		contentType, rewrite := stub.contentType, stub.rewrite
+		stub.mu.Unlock()
+
+		answer := testZone.answer(t, packed)
+		if rewrite != nil {
+			var resp dnsmessage.Message
+			if err := resp.Unpack(answer); err != nil {
+				t.Error(err)
+				return
+			}
+			rewrite(&resp)
+			if answer, err = resp.Pack(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(answer)
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return stub, server.URL + defaultDoHPath
+}
+
+// lastRequest returns the most recent request the stub received
+func (stub *dohStub) lastRequest(t *testing.T) dohRequest {
+	t.Helper()
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.requests) == 0 {
+		t.Fatal("DoH stub received no request")
+	}
+	return stub.requests[len(stub.requests)-1]
+}
+
+// exchangeDoHQuery sends a query for domain and parses the answer against it
+func exchangeDoHQuery(t *testing.T, server DNSServer, domain, method string) (dnsmessage.Message, *dnsmessage.Message, error) {
+	t.Helper()
+
+	query := mustQuery(t, domain, dnsmessage.TypeA)
+	packed, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := exchangeDoH(context.Background(), server, packed, method)
+	if err != nil {
+		return query, nil, err
+	}
+	resp, err := parseUpstreamResponse(query, raw)
+	return query, resp, err
+}
+
+func TestExchangeDoHPost(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	stub, url := startRecordingDoHStub(t, cert)
+	server := DNSServer{Name: "stub", URL: url}
+	installTestResolver(t, "DoH", roots, server)
+
+	query, resp, err := exchangeDoHQuery(t, server, "www.example", http.MethodPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Errorf("answers = %+v, want one A record", resp.Answers)
+	}
+
+	request := stub.lastRequest(t)
+	if request.method != http.MethodPost || request.contentType != dohContentType {
+		t.Errorf("request %+v, want a POST of %s", request, dohContentType)
+	}
+	// POST bodies are not cached, so the query keeps its ID on the wire
+	if request.id != query.Header.ID {
+		t.Errorf("wire ID = %d, want %d", request.id, query.Header.ID)
+	}
+}
+
+func TestExchangeDoHGet(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	stub, url := startRecordingDoHStub(t, cert)
+	server := DNSServer{Name: "stub", URL: url}
+	installTestResolver(t, "DoH", roots, server)
+
+	var encoded string
+	for i := 0; i < 2; i++ {
+		query, resp, err := exchangeDoHQuery(t, server, "www.example", http.MethodGet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.ID != query.Header.ID || len(resp.Answers) != 1 {
+			t.Errorf("answer ID %d with %d answers, want ID %d and one A record", resp.Header.ID, len(resp.Answers), query.Header.ID)
+		}
+
+		request := stub.lastRequest(t)
+		if request.method != http.MethodGet || request.id != 0 {
+			t.Errorf("request %+v, want a GET with ID 0", request)
+		}
+		// Unpadded base64url, identical for the same question
+		if strings.ContainsAny(request.encoded, "=+/") {
+			t.Errorf("dns parameter %q is not unpadded base64url", request.encoded)
+		}
+		if i == 1 && request.encoded != encoded {
+			t.Errorf("repeated question encoded as %q, then %q", encoded, request.encoded)
+		}
+		encoded = request.encoded
+	}
+
+	// A server answering a GET with another ID is not echoing our query
+	stub.mu.Lock()
+	stub.rewrite = func(resp *dnsmessage.Message) { resp.Header.ID = 4242 }
+	stub.mu.Unlock()
+	if _, _, err := exchangeDoHQuery(t, server, "www.example", http.MethodGet); err == nil {
+		t.Error("GET answer with a non-zero ID was accepted")
+	}
+}
+
+func TestExchangeDoHContentType(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	stub, url := startRecordingDoHStub(t, cert)
+	server := DNSServer{Name: "stub", URL: url}
+	installTestResolver(t, "DoH", roots, server)
+
+	for contentType, valid := range map[string]bool{
+		"application/dns-message":                true,
+		"application/dns-message; charset=utf-8": true,
+		"application/dns-json":                   false,
+		"text/html":                              false,
+		"":                                       false,
+	} {
+		stub.mu.Lock()
+		stub.contentType = contentType
+		stub.mu.Unlock()
+		if _, _, err := exchangeDoHQuery(t, server, "www.example", http.MethodPost); (err == nil) != valid {
+			t.Errorf("content type %q: %v, want valid %v", contentType, err, valid)
+		}
+	}
+}
+
+func TestExchangeDoHRejectsMismatchedAnswer(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	stub, url := startRecordingDoHStub(t, cert)
+	server := DNSServer{Name: "stub", URL: url}
+	installTestResolver(t, "DoH", roots, server)
+
+	tests := []struct {
+		name    string
+		rewrite func(resp *dnsmessage.Message)
+	}{
+		{"ID", func(resp *dnsmessage.Message) { resp.Header.ID++ }},
+		{"question name", func(resp *dnsmessage.Message) {
+			resp.Questions[0].Name = dnsmessage.MustNewName("ads.example.")
+		}},
+		{"question type", func(resp *dnsmessage.Message) { resp.Questions[0].Type = dnsmessage.TypeAAAA }},
+		{"no question", func(resp *dnsmessage.Message) { resp.Questions = nil }},
+	}
+	for _, test := range tests {
+		stub.mu.Lock()
+		stub.rewrite = test.rewrite
+		stub.mu.Unlock()
+		for _, method := range []string{http.MethodPost, http.MethodGet} {
+			if _, _, err := exchangeDoHQuery(t, server, "www.example", method); err == nil {
+				t.Errorf("%s answer with a mismatched %s was accepted", method, test.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ============================================================================
+// DNS OVER QUIC (RFC 9250)
+// One query per bidirectional stream over a reused QUIC connection (ALPN "doq"),
+// with 0-RTT on resumed sessions
+// Privacy: Certificates always verified; message IDs are zero on the wire
+// ============================================================================
+
+const (
+	doqALPN        = "doq"
+	doqIdleTimeout = keepaliveIntervalSeconds * time.Second
+
+	doqNoError       quic.ApplicationErrorCode = 0x0 // DOQ_NO_ERROR
+	doqProtocolError quic.ApplicationErrorCode = 0x2 // DOQ_PROTOCOL_ERROR
+)
+
+var (
+	doqConnections    = map[string]*quic.Conn{}    // "address:port" -> open connection
+	doqDialing        = map[string]chan struct{}{} // "address:port" -> held while a connection is dialed
+	doqConnectionsMux sync.Mutex
+
+	// doqSessionCache keeps TLS session tickets so reconnects can resume and use 0-RTT
+	doqSessionCache = newDoQTicketCache(64)
+
+	// doqLastZeroRTT records whether the most recently dialed connection had its 0-RTT data accepted
+	doqLastZeroRTT atomic.Bool
+)
+
+// doqTicketCache is a TLS session cache that remembers which keys hold a
+// ticket, so the status endpoint can tell whether a reconnect may use 0-RTT
+type doqTicketCache struct {
+	tls.ClientSessionCache
+
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newDoQTicketCache(capacity int) *doqTicketCache {
+	return &doqTicketCache{ClientSessionCache: tls.NewLRUClientSessionCache(capacity), keys: map[string]struct{}{}}
+}
+
+// Put stores or (with a nil session) removes a ticket
+func (cache *doqTicketCache) Put(key string, session *tls.ClientSessionState) {
+	cache.ClientSessionCache.Put(key, session)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if session == nil {
+		delete(cache.keys, key)
+	} else {
+		cache.keys[key] = struct{}{}
+	}
+}
+
+// hasTicket reports whether any server has a session ticket cached
+// Keys the LRU cache has evicted are forgotten on the way
+func (cache *doqTicketCache) hasTicket() bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for key := range cache.keys {
+		if session, ok := cache.ClientSessionCache.Get(key); ok && session != nil {
+			return true
+		}
+		delete(cache.keys, key)
+	}
+	return false
+}
+
+// liveDoQConnection returns the open connection to address, if any
+func liveDoQConnection(address string) (*quic.Conn, bool) {
+	doqConnectionsMux.Lock()
+	conn, ok := doqConnections[address]
+	doqConnectionsMux.Unlock()
+	return conn, ok && conn.Context().Err() == nil
+}
+
+// doqConnection returns the open connection to a server, dialing one when needed
+// fresh skips the open connection check (after a failure on a reused or 0-RTT
+// connection, which the caller has already dropped). Only one dial per server
+// runs at a time; queries waiting on it share the connection it opens
+func doqConnection(ctx context.Context, server DNSServer, fresh bool) (*quic.Conn, bool, error) {
+	address := serverAddress(server)
+
+	if conn, ok := liveDoQConnection(address); ok && !fresh {
+		return conn, true, nil
+	}
+
+	doqConnectionsMux.Lock()
+	dialing, ok := doqDialing[address]
+	if !ok {
+		dialing = make(chan struct{}, 1)
+		doqDialing[address] = dialing
+	}
+	doqConnectionsMux.Unlock()
+
+	select {
+	case dialing <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false, fmt.Errorf("DoQ connection failed: %w", ctx.Err())
+	}
+	defer func() { <-dialing }()
+
+	// Another query may have connected while this one waited
+	if conn, ok := liveDoQConnection(address); ok {
+		return conn, true, nil
+	}
+
+	tlsConfig := upstreamTLSConfig(server, doqALPN)
+	tlsConfig.ClientSessionCache = doqSessionCache
+
+	// DialAddrEarly sends queries as 0-RTT data when a session ticket is available;
+	// DNS queries are replayable transactions, so this is allowed (RFC 9250 §4.5)
+	conn, err := quic.DialAddrEarly(ctx, address, tlsConfig, &quic.Config{
+		MaxIdleTimeout:       doqIdleTimeout,
+		HandshakeIdleTimeout: connectionTimeoutSeconds * time.Second,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("DoQ connection failed: %w", err)
+	}
+
+	doqConnectionsMux.Lock()
+	doqConnections[address] = conn
+	doqConnectionsMux.Unlock()
+
+	return conn, false, nil
+}
+
+// dropDoQConnection forgets and closes a connection that failed
+func dropDoQConnection(server DNSServer, conn *quic.Conn, code quic.ApplicationErrorCode) {
+	address := serverAddress(server)
+
+	doqConnectionsMux.Lock()
+	if doqConnections[address] == conn {
+		delete(doqConnections, address)
+	}
+	doqConnectionsMux.Unlock()
+
+	conn.CloseWithError(code, "")
+}
+
+// openDoQConnections returns the number of live DoQ connections
+func openDoQConnections() int {
+	doqConnectionsMux.Lock()
+	defer doqConnectionsMux.Unlock()
+
+	open := 0
+	for _, conn := range doqConnections {
+		if conn.Context().Err() == nil {
+			open++
+		}
+	}
+	return open
+}
+
+// doqRoundTrip sends one length-prefixed query on a new stream, closes the
+// sending side, and reads the length-prefixed answer
+func doqRoundTrip(ctx context.Context, conn *quic.Conn, query []byte) ([]byte, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CancelRead(quic.StreamErrorCode(doqNoError))
+
+	deadline := time.Now().Add(defaultTimeoutSeconds * time.Second)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := stream.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(frame, uint16(len(query)))
+	copy(frame[2:], query)
+	if _, err := stream.Write(frame); err != nil {
+		return nil, err
+	}
+	if err := stream.Close(); err != nil { // FIN: no more queries on this stream
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// exchangeDoQ sends a packed query to a server and returns the packed answer
+// The message ID is sent as 0 (RFC 9250 §4.2.1) and restored on the answer so
+// callers can match it like any other transport
+func exchangeDoQ(ctx context.Context, server DNSServer, query []byte) ([]byte, error) {
+	if len(query) < 2 || len(query) > 0xFFFF {
+		return nil, fmt.Errorf("query size not valid for DoQ framing")
+	}
+
+	wire := append([]byte(nil), query...)
+	id := binary.BigEndian.Uint16(wire)
+	binary.BigEndian.PutUint16(wire, 0)
+
+	conn, reused, err := doqConnection(ctx, server, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doqRoundTrip(ctx, conn, wire)
+
+	// A reused connection may have been closed by the server and 0-RTT may be
+	// rejected; either way retry once on a fresh connection
+	if err != nil && ctx.Err() == nil && (reused || errors.Is(err, quic.Err0RTTRejected)) {
+		dropDoQConnection(server, conn, doqNoError)
+		if conn, reused, err = doqConnection(ctx, server, true); err != nil {
+			return nil, err
+		}
+		resp, err = doqRoundTrip(ctx, conn, wire)
+	}
+	if err != nil {
+		dropDoQConnection(server, conn, doqNoError)
+		return nil, fmt.Errorf("DoQ exchange failed: %w", err)
+	}
+
+	if len(resp) < 2 || binary.BigEndian.Uint16(resp) != 0 {
+		dropDoQConnection(server, conn, doqProtocolError)
+		return nil, fmt.Errorf("DoQ response has a non-zero message ID")
+	}
+	binary.BigEndian.PutUint16(resp, id)
+
+	// The handshake has completed once an answer arrives, so 0-RTT acceptance is known
+	if !reused {
+		doqLastZeroRTT.Store(conn.ConnectionState().Used0RTT)
+	}
+	return resp, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quic-go/quic-go"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// doqZeroRTTStatus reads zero_rtt and zero_rtt_supported from handleDoQStatus
+func doqZeroRTTStatus(t *testing.T) (bool, bool) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/doq", handleDoQStatus)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/doq", nil))
+
+	var status struct {
+		ZeroRTT          bool `json:"zero_rtt"`
+		ZeroRTTSupported bool `json:"zero_rtt_supported"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	return status.ZeroRTT, status.ZeroRTTSupported
+}
+
+// installDoQSessionCache starts a test with no session tickets cached
+func installDoQSessionCache(t *testing.T) {
+	t.Helper()
+
+	previous := doqSessionCache
+	doqSessionCache = newDoQTicketCache(64)
+	t.Cleanup(func() { doqSessionCache = previous })
+}
+
+func TestExchangeDoQ(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	port := startDoQStub(t, cert, testZone)
+	server := DNSServer{Name: "stub", Address: "127.0.0.1", Port: port, Protocols: []string{"DoQ"}, Healthy: true}
+	installTestResolver(t, "DoQ", roots, server)
+	installDoQSessionCache(t)
+	if _, supported := doqZeroRTTStatus(t); supported {
+		t.Error("zero_rtt_supported reported before any session ticket was cached")
+	}
+
+	exchange := func(domain string) (dnsmessage.Message, uint16) {
+		t.Helper()
+		query := mustQuery(t, domain, dnsmessage.TypeA)
+		packed, err := query.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := exchangeDoQ(context.Background(), server, packed)
+		if err != nil {
+			t.Fatalf("exchangeDoQ(%s): %v", domain, err)
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(raw); err != nil {
+			t.Fatal(err)
+		}
+		return resp, binary.BigEndian.Uint16(packed)
+	}
+
+	// First connection: full handshake, no session ticket yet
+	resp, id := exchange("www.example")
+	if resp.Header.ID != id || len(resp.Answers) != 1 {
+		t.Errorf("answer ID %d with %d records, want ID %d with 1 record", resp.Header.ID, len(resp.Answers), id)
+	}
+	if zeroRTT, _ := doqZeroRTTStatus(t); zeroRTT {
+		t.Error("zero_rtt reported for a connection without a session ticket")
+	}
+
+	// Reused connection: one stream per query on the same connection
+	if resp, _ := exchange("missing.example"); resp.Header.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("missing.example rcode %v, want NXDOMAIN", resp.Header.RCode)
+	}
+	if open := openDoQConnections(); open != 1 {
+		t.Errorf("open connections = %d, want 1", open)
+	}
+	if _, supported := doqZeroRTTStatus(t); !supported {
+		t.Error("zero_rtt_supported not reported once the server issued a session ticket")
+	}
+
+	// Reconnect: the session ticket lets the query ride in 0-RTT data
+	dropDoQConnectionTo(server)
+	exchange("www.example")
+	if zeroRTT, _ := doqZeroRTTStatus(t); !zeroRTT {
+		t.Error("zero_rtt not reported for a resumed connection")
+	}
+
+	// The whole pipeline over DoQ
+	startBlocklistStub(t).blocked["tracker.cdn.example"] = true
+	resolution, err := resolveQuery(context.Background(), mustQuery(t, "cloaked.example", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatalf("resolveQuery over DoQ: %v", err)
+	}
+	if resolution.Protocol != "DoQ" || resolution.Decision.Policy != "cname_blocklist" {
+		t.Errorf("resolveQuery over DoQ: protocol %q policy %q, want DoQ cname_blocklist", resolution.Protocol, resolution.Decision.Policy)
+	}
+}
+
+func TestExchangeDoQUntrusted(t *testing.T) {
+	cert, _ := newTestCertificate(t)
+	_, otherRoots := newTestCertificate(t)
+	port := startDoQStub(t, cert, testZone)
+	server := DNSServer{Name: "stub", Address: "127.0.0.1", Port: port, Protocols: []string{"DoQ"}, Healthy: true}
+	installTestResolver(t, "DoQ", otherRoots, server)
+
+	query := mustQuery(t, "www.example", dnsmessage.TypeA)
+	packed, _ := query.Pack()
+	if _, err := exchangeDoQ(context.Background(), server, packed); err == nil {
+		t.Error("exchanged with a server whose certificate is not trusted")
+	}
+}
+
+func TestDoQConnectionConcurrentDials(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	port := startDoQStub(t, cert, testZone)
+	server := DNSServer{Name: "stub", Address: "127.0.0.1", Port: port, Protocols: []string{"DoQ"}, Healthy: true}
+	installTestResolver(t, "DoQ", roots, server)
+	installDoQSessionCache(t)
+	t.Cleanup(func() { dropDoQConnectionTo(server) })
+
+	// Queries arriving together share one dial instead of closing each other's connection
+	const queries = 8
+	conns := make(chan *quic.Conn, queries)
+	var wg sync.WaitGroup
+	for i := 0; i < queries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, _, err := doqConnection(context.Background(), server, false)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conns <- conn
+		}()
+	}
+	wg.Wait()
+	close(conns)
+
+	var first *quic.Conn
+	for conn := range conns {
+		if first == nil {
+			first = conn
+		}
+		if conn != first || conn.Context().Err() != nil {
+			t.Error("concurrent dials returned different or closed connections")
+		}
+	}
+	if open := openDoQConnections(); open != 1 {
+		t.Errorf("open connections = %d, want 1", open)
+	}
+
+	query := mustQuery(t, "www.example", dnsmessage.TypeA)
+	packed, _ := query.Pack()
+	if _, err := exchangeDoQ(context.Background(), server, packed); err != nil {
+		t.Errorf("exchange on the shared connection: %v", err)
+	}
+
+	// A waiting dial gives up with its context
+	doqConnectionsMux.Lock()
+	dialing := doqDialing[serverAddress(server)]
+	doqConnectionsMux.Unlock()
+	dialing <- struct{}{}
+	defer func() { <-dialing }()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := doqConnection(ctx, server, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("dial behind a running one = %v, want the context deadline", err)
+	}
+}
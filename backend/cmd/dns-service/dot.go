@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// DNS OVER TLS (RFC 7858)
+// Wire-format queries framed with a two-byte length over pooled TLS connections
+// Privacy: Queries travel encrypted only; certificates are always verified
+// ============================================================================
+
+// dotIdleTimeout closes pooled connections before typical server idle limits
+const dotIdleTimeout = keepaliveIntervalSeconds * time.Second
+
+// upstreamRootCAs adds UPSTREAM_CA_FILE (PEM) to the system roots for self-hosted resolvers
+var upstreamRootCAs = func() *x509.CertPool {
+	path := os.Getenv("UPSTREAM_CA_FILE")
+	if path == "" {
+		return nil // System roots
+	}
+
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("⚠️ UPSTREAM_CA_FILE not loaded: %v", err)
+		return nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		log.Printf("⚠️ UPSTREAM_CA_FILE contains no certificates")
+	}
+	return pool
+}()
+
+// dotConn is an idle pooled connection
+type dotConn struct {
+	conn     *tls.Conn
+	lastUsed time.Time
+}
+
+var (
+	dotPools    = map[string]*ConnectionPool{} // "address:port" -> idle connections
+	dotPoolsMux sync.Mutex
+)
+
+// serverAddress returns the dialable host:port of a server
+func serverAddress(server DNSServer) string {
+	return net.JoinHostPort(server.Address, strconv.Itoa(server.Port))
+}
+
+// upstreamTLSConfig verifies the server certificate against its TLS name (or its address)
+func upstreamTLSConfig(server DNSServer, nextProtos ...string) *tls.Config {
+	serverName := server.ServerName
+	if serverName == "" {
+		serverName = server.Address
+	}
+	return &tls.Config{
+		ServerName: serverName,
+		RootCAs:    upstreamRootCAs,
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+	}
+}
+
+// dotPool returns the idle-connection pool of a server, creating it on first use
+func dotPool(address string) *ConnectionPool {
+	dotPoolsMux.Lock()
+	defer dotPoolsMux.Unlock()
+
+	pool, ok := dotPools[address]
+	if !ok {
+		pool = NewConnectionPool("DoT", maxConnectionsPerServer)
+		dotPools[address] = pool
+	}
+	return pool
+}
+
+// takeIdleDoT returns a pooled connection that is still fresh, or nil
+func takeIdleDoT(pool *ConnectionPool) *tls.Conn {
+	for {
+		select {
+		case item := <-pool.Connections:
+			idle := item.(*dotConn)
+			if time.Since(idle.lastUsed) < dotIdleTimeout {
+				return idle.conn
+			}
+			closeDoT(pool, idle.conn)
+		default:
+			return nil
+		}
+	}
+}
+
+// releaseDoT returns a healthy connection to the pool, closing it when the pool is full
+func releaseDoT(pool *ConnectionPool, conn *tls.Conn) {
+	select {
+	case pool.Connections <- &dotConn{conn: conn, lastUsed: time.Now()}:
+	default:
+		closeDoT(pool, conn)
+	}
+}
+
+// closeDoT closes a connection and updates the pool counters
+func closeDoT(pool *ConnectionPool, conn *tls.Conn) {
+	conn.Close()
+	atomic.AddInt32(&pool.ActiveCount, -1)
+	atomic.StoreInt32(&pool.Stats.ActiveConnections, atomic.LoadInt32(&pool.ActiveCount))
+}
+
+// dialDoT opens and handshakes a new TLS connection to a server
+func dialDoT(ctx context.Context, pool *ConnectionPool, server DNSServer) (*tls.Conn, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{
+			Timeout:   connectionTimeoutSeconds * time.Second,
+			KeepAlive: keepaliveIntervalSeconds * time.Second,
+		},
+		Config: upstreamTLSConfig(server),
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", serverAddress(server))
+	if err != nil {
+		atomic.AddInt64(&pool.Stats.ErrorCount, 1)
+		return nil, fmt.Errorf("DoT connection failed: %w", err)
+	}
+
+	atomic.AddInt32(&pool.ActiveCount, 1)
+	atomic.StoreInt32(&pool.Stats.ActiveConnections, atomic.LoadInt32(&pool.ActiveCount))
+	atomic.AddInt64(&pool.Stats.TotalConnections, 1)
+	return conn.(*tls.Conn), nil
+}
+
+// dotRoundTrip writes one length-prefixed query and reads the length-prefixed answer
+func dotRoundTrip(ctx context.Context, conn *tls.Conn, query []byte) ([]byte, error) {
+	deadline := time.Now().Add(defaultTimeoutSeconds * time.Second)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(frame, uint16(len(query)))
+	copy(frame[2:], query)
+	if _, err := conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// exchangeDoT sends a packed query to a server and returns the packed answer
+// An idle pooled connection is tried first; if the server closed it, the query
+// is retried once on a fresh connection
+func exchangeDoT(ctx context.Context, server DNSServer, query []byte) ([]byte, error) {
+	if len(query) > 0xFFFF {
+		return nil, fmt.Errorf("query too large for DoT framing")
+	}
+	pool := dotPool(serverAddress(server))
+
+	if conn := takeIdleDoT(pool); conn != nil {
+		if resp, err := dotRoundTrip(ctx, conn, query); err == nil {
+			releaseDoT(pool, conn)
+			return resp, nil
+		}
+		closeDoT(pool, conn)
+	}
+
+	conn, err := dialDoT(ctx, pool, server)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dotRoundTrip(ctx, conn, query)
+	if err != nil {
+		atomic.AddInt64(&pool.Stats.ErrorCount, 1)
+		closeDoT(pool, conn)
+		return nil, fmt.Errorf("DoT exchange failed: %w", err)
+	}
+	releaseDoT(pool, conn)
+	return resp, nil
+}
+
+// openDoTConnections returns the number of open DoT connections across all servers
+func openDoTConnections() int {
+	dotPoolsMux.Lock()
+	defer dotPoolsMux.Unlock()
+
+	open := 0
+	for _, pool := range dotPools {
+		open += int(atomic.LoadInt32(&pool.ActiveCount))
+	}
+	return open
+}
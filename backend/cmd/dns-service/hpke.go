@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ============================================================================
+// HPKE (RFC 9180)
+// Base-mode sender for DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and AES-128-GCM,
+// the suite used by Oblivious DoH targets
+// ============================================================================
+
+const (
+	hpkeKEMX25519SHA256 = 0x0020
+	hpkeKDFSHA256       = 0x0001
+	hpkeAEADAES128GCM   = 0x0001
+
+	hpkeKeyLength   = 16 // Nk for AES-128-GCM
+	hpkeNonceLength = 12 // Nn for AES-128-GCM
+	hpkeHashLength  = 32 // Nh for HKDF-SHA256
+)
+
+// hpkeSender is a sealing context established with a recipient's public key
+type hpkeSender struct {
+	aead           cipher.AEAD
+	baseNonce      []byte
+	exporterSecret []byte
+	sequence       uint64
+}
+
+var (
+	hpkeKEMSuiteID = []byte{'K', 'E', 'M', 0x00, 0x20}
+	hpkeSuiteID    = []byte{'H', 'P', 'K', 'E', 0x00, 0x20, 0x00, 0x01, 0x00, 0x01}
+)
+
+// hpkeLabeledExtract is LabeledExtract: Extract(salt, "HPKE-v1" || suite_id || label || ikm)
+func hpkeLabeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	labeled := append([]byte("HPKE-v1"), suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, ikm...)
+	return hkdf.Extract(sha256.New, labeled, salt)
+}
+
+// hpkeLabeledExpand is LabeledExpand: Expand(prk, I2OSP(L, 2) || "HPKE-v1" || suite_id || label || info, L)
+func hpkeLabeledExpand(suiteID, prk []byte, label string, info []byte, length int) []byte {
+	labeled := binary.BigEndian.AppendUint16(nil, uint16(length))
+	labeled = append(labeled, "HPKE-v1"...)
+	labeled = append(labeled, suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, info...)
+
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, labeled), out); err != nil {
+		panic(err) // Only possible for lengths above 255*Nh, which are never requested
+	}
+	return out
+}
+
+// hpkeSetupBaseSender encapsulates to a recipient public key and derives the context
+// Returns enc, the ephemeral public key the recipient needs to open messages
+func hpkeSetupBaseSender(recipientKey, info []byte) ([]byte, *hpkeSender, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hpkeSetupBaseSenderWithKey(ephemeral, recipientKey, info)
+}
+
+// hpkeSetupBaseSenderWithKey is hpkeSetupBaseSender with a given ephemeral key
+func hpkeSetupBaseSenderWithKey(ephemeral *ecdh.PrivateKey, recipientKey, info []byte) ([]byte, *hpkeSender, error) {
+	publicKey, err := ecdh.X25519().NewPublicKey(recipientKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid HPKE public key: %w", err)
+	}
+	dh, err := ephemeral.ECDH(publicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enc := ephemeral.PublicKey().Bytes()
+	sender, err := hpkeKeySchedule(hpkeSharedSecret(dh, enc, recipientKey), info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, sender, nil
+}
+
+// hpkeSharedSecret is the DHKEM shared_secret = ExtractAndExpand(dh, enc || pkR)
+func hpkeSharedSecret(dh, enc, recipientKey []byte) []byte {
+	kemContext := append(append([]byte{}, enc...), recipientKey...)
+	eaePRK := hpkeLabeledExtract(hpkeKEMSuiteID, nil, "eae_prk", dh)
+	return hpkeLabeledExpand(hpkeKEMSuiteID, eaePRK, "shared_secret", kemContext, hpkeHashLength)
+}
+
+// hpkeKeySchedule derives the context for mode_base (0x00) without PSK
+func hpkeKeySchedule(sharedSecret, info []byte) (*hpkeSender, error) {
+	keyScheduleContext := []byte{0x00}
+	keyScheduleContext = append(keyScheduleContext, hpkeLabeledExtract(hpkeSuiteID, nil, "psk_id_hash", nil)...)
+	keyScheduleContext = append(keyScheduleContext, hpkeLabeledExtract(hpkeSuiteID, nil, "info_hash", info)...)
+	secret := hpkeLabeledExtract(hpkeSuiteID, sharedSecret, "secret", nil)
+
+	block, err := aes.NewCipher(hpkeLabeledExpand(hpkeSuiteID, secret, "key", keyScheduleContext, hpkeKeyLength))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hpkeSender{
+		aead:           aead,
+		baseNonce:      hpkeLabeledExpand(hpkeSuiteID, secret, "base_nonce", keyScheduleContext, hpkeNonceLength),
+		exporterSecret: hpkeLabeledExpand(hpkeSuiteID, secret, "exp", keyScheduleContext, hpkeHashLength),
+	}, nil
+}
+
+// Seal encrypts the next message
+func (s *hpkeSender) Seal(aad, plaintext []byte) []byte {
+	return s.aead.Seal(nil, s.nextNonce(), plaintext, aad)
+}
+
+// nextNonce returns base_nonce XOR I2OSP(seq, Nn) and advances the sequence number
+func (s *hpkeSender) nextNonce() []byte {
+	nonce := append([]byte{}, s.baseNonce...)
+	var sequence [8]byte
+	binary.BigEndian.PutUint64(sequence[:], s.sequence)
+	for i := range sequence {
+		nonce[hpkeNonceLength-8+i] ^= sequence[i]
+	}
+	s.sequence++
+	return nonce
+}
+
+// Export derives a secret bound to the context: LabeledExpand(exporter_secret, "sec", context, L)
+func (s *hpkeSender) Export(exporterContext []byte, length int) []byte {
+	return hpkeLabeledExpand(hpkeSuiteID, s.exporterSecret, "sec", exporterContext, length)
+}
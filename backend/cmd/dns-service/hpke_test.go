@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"testing"
+)
+
+// mustHex decodes a test vector field
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestHPKEVectors checks RFC 9180 Appendix A.1.1:
+// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM in mode_base
+func TestHPKEVectors(t *testing.T) {
+	info := mustHex(t, "4f6465206f6e2061204772656369616e2055726e")
+	skEm := mustHex(t, "52c4a758a802cd8b936eceea314432798d5baf2d7e9235dc084ab1b9cfa2f736")
+	pkRm := mustHex(t, "3948cfe0ad1ddb695d780e59077195da6c56506b027329794ab02bca80815c4d")
+	skRm := mustHex(t, "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8")
+	wantEnc := mustHex(t, "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431")
+	wantSharedSecret := mustHex(t, "fe0e18c9f024ce43799ae393c7e8fe8fce9d218875e8227b0187c04e7d2ea1fc")
+	wantBaseNonce := mustHex(t, "56d890e5accaaf011cff4b7d")
+	wantExporterSecret := mustHex(t, "45ff1c2e220db587171952c0592d5f5ebe103f1561a2614e38f2ffd47e99e3f8")
+
+	ephemeral, err := ecdh.X25519().NewPrivateKey(skEm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, sender, err := hpkeSetupBaseSenderWithKey(ephemeral, pkRm, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(enc, wantEnc) {
+		t.Errorf("enc = %x, want %x", enc, wantEnc)
+	}
+	if !bytes.Equal(sender.baseNonce, wantBaseNonce) {
+		t.Errorf("base_nonce = %x, want %x", sender.baseNonce, wantBaseNonce)
+	}
+	if !bytes.Equal(sender.exporterSecret, wantExporterSecret) {
+		t.Errorf("exporter_secret = %x, want %x", sender.exporterSecret, wantExporterSecret)
+	}
+
+	// The recipient derives the same shared secret from its private key
+	recipient, err := ecdh.X25519().NewPrivateKey(skRm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ephemeralPublic, _ := ecdh.X25519().NewPublicKey(enc)
+	dh, err := recipient.ECDH(ephemeralPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sharedSecret := hpkeSharedSecret(dh, enc, recipient.PublicKey().Bytes()); !bytes.Equal(sharedSecret, wantSharedSecret) {
+		t.Errorf("shared_secret = %x, want %x", sharedSecret, wantSharedSecret)
+	}
+
+	plaintext := mustHex(t, "4265617574792069732074727574682c20747275746820626561757479")
+	encryptions := []struct {
+		sequence   uint64
+		aad        string
+		ciphertext string
+	}{
+		{0, "436f756e742d30", "f938558b5d72f1a23810b4be2ab4f84331acc02fc97babc53a52ae8218a355a96d8770ac83d07bea87e13c512a"},
+		{1, "436f756e742d31", "af2d7e9ac9ae7e270f46ba1f975be53c09f8d875bdc8535458c2494e8a6eab251c03d0c22a56b8ca42c2063b84"},
+		{2, "436f756e742d32", "498dfcabd92e8acedc281e85af1cb4e3e31c7dc394a1ca20e173cb72516491588d96a19ad4a683518973dcc180"},
+		{4, "436f756e742d34", "583bd32bc67a5994bb8ceaca813d369bca7b2a42408cddef5e22f880b631215a09fc0012bc69fccaa251c0246d"},
+		{255, "436f756e742d323535", "7175db9717964058640a3a11fb9007941a5d1757fda1a6935c805c21af32505bf106deefec4a49ac38d71c9e0a"},
+		{256, "436f756e742d323536", "957f9800542b0b8891badb026d79cc54597cb2d225b54c00c5238c25d05c30e3fbeda97d2e0e1aba483a2df9f2"},
+	}
+	for _, encryption := range encryptions {
+		sender.sequence = encryption.sequence
+		ciphertext := sender.Seal(mustHex(t, encryption.aad), plaintext)
+		if want := mustHex(t, encryption.ciphertext); !bytes.Equal(ciphertext, want) {
+			t.Errorf("sequence %d: ciphertext = %x, want %x", encryption.sequence, ciphertext, want)
+		}
+		if sender.sequence != encryption.sequence+1 {
+			t.Errorf("sequence %d: not advanced", encryption.sequence)
+		}
+	}
+
+	exports := []struct {
+		context string
+		value   string
+	}{
+		{"", "3853fe2b4035195a573ffc53856e77058e15d9ea064de3e59f4961d0095250ee"},
+		{"00", "2e8f0b54673c7029649d4eb9d5e33bf1872cf76d623ff164ac185da9e88c21a5"},
+		{"54657374436f6e74657874", "e9e43065102c3836401bed8c3c3c75ae46be1639869391d62c61f1ec7af54931"},
+	}
+	for _, export := range exports {
+		if got, want := sender.Export(mustHex(t, export.context), 32), mustHex(t, export.value); !bytes.Equal(got, want) {
+			t.Errorf("export(%s) = %x, want %x", export.context, got, want)
+		}
+	}
+}
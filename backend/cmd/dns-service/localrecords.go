@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// LOCAL DNS RECORDS
+// User-defined answers (nas.home -> 192.168.1.10, *.dev.test -> 127.0.0.1)
+// checked before the cache and upstream, answered authoritatively
+// Privacy: Records are user configuration stored in the local config dir only
+// ============================================================================
+
+const (
+	maxLocalRecords      = 1000
+	maxLocalCNAMEChain   = 8 // Local CNAME hops followed before giving up
+	maxTXTSegmentLength  = 255
+	localRecordsFileMode = 0o600
+)
+
+// LocalRecord is one local answer; Name may start with "*." to match every subdomain
+type LocalRecord struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`          // "nas.home" or "*.dev.test"
+	Type  string `json:"type"`          // "A", "AAAA", "CNAME" or "TXT"
+	Value string `json:"value"`         // Address, target name or text
+	TTL   uint32 `json:"ttl,omitempty"` // Seconds; 0 uses the default TTL
+}
+
+var (
+	localRecords     []LocalRecord
+	localRecordsMux  sync.RWMutex
+	localRecordsPath = resolveLocalRecordsPath()
+
+	// defaultLocalRecordTTL applies to records without their own TTL (LOCAL_RECORD_TTL overrides)
+	defaultLocalRecordTTL = func() uint32 {
+		if ttl, err := strconv.ParseUint(os.Getenv("LOCAL_RECORD_TTL"), 10, 32); err == nil && ttl > 0 {
+			return uint32(ttl)
+		}
+		return 300
+	}()
+)
+
+// resolveLocalRecordsPath returns LOCAL_RECORDS_PATH or a file in the user config dir
+func resolveLocalRecordsPath() string {
+	if path := os.Getenv("LOCAL_RECORDS_PATH"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "shroudinger", "local-records.json")
+}
+
+// loadLocalRecords reads the records file; a missing file means no records
+func loadLocalRecords() error {
+	data, err := os.ReadFile(localRecordsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var records []LocalRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("invalid local records file: %w", err)
+	}
+	for i := range records {
+		if err := validateLocalRecord(&records[i]); err != nil {
+			return fmt.Errorf("local record %d: %w", i, err)
+		}
+		if records[i].ID == "" {
+			records[i].ID = newLocalRecordID() // Hand-edited files may omit IDs
+		}
+	}
+
+	localRecordsMux.Lock()
+	localRecords = records
+	localRecordsMux.Unlock()
+
+	log.Printf("🏠 Loaded %d local DNS records", len(records))
+	return nil
+}
+
+// saveLocalRecords writes the records atomically (temp file + rename)
+// Caller must hold localRecordsMux
+func saveLocalRecords() error {
+	data, err := json.MarshalIndent(localRecords, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localRecordsPath), 0o700); err != nil {
+		return err
+	}
+
+	tmp := localRecordsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, localRecordsFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, localRecordsPath)
+}
+
+// validateLocalRecord normalizes a record and checks its value against its type
+func validateLocalRecord(record *LocalRecord) error {
+	record.Name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(record.Name), "."))
+	record.Type = strings.ToUpper(strings.TrimSpace(record.Type))
+
+	name := strings.TrimPrefix(record.Name, "*.")
+	if name == "" || strings.Contains(name, "*") {
+		return fmt.Errorf("invalid name")
+	}
+	if _, err := dnsmessage.NewName(name + "."); err != nil {
+		return fmt.Errorf("invalid name: %w", err)
+	}
+
+	switch record.Type {
+	case "A", "AAAA":
+		addr, err := netip.ParseAddr(record.Value)
+		if err != nil || addr.Is4() != (record.Type == "A") || addr.Zone() != "" {
+			return fmt.Errorf("value is not a valid %s record address", record.Type)
+		}
+	case "CNAME":
+		record.Value = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(record.Value), "."))
+		if _, err := dnsmessage.NewName(record.Value + "."); err != nil || record.Value == "" {
+			return fmt.Errorf("invalid CNAME target")
+		}
+		if record.Value == name {
+			return fmt.Errorf("CNAME points to itself")
+		}
+	case "TXT":
+		if record.Value == "" {
+			return fmt.Errorf("TXT value is empty")
+		}
+	default:
+		return fmt.Errorf("type must be A, AAAA, CNAME or TXT")
+	}
+
+	return nil
+}
+
+// matchLocalRecords returns the records owning a name: exact names win over the deepest wildcard
+// Caller must hold localRecordsMux for reading
+func matchLocalRecords(name string) []LocalRecord {
+	var matched []LocalRecord
+	for _, record := range localRecords {
+		if record.Name == name {
+			matched = append(matched, record)
+		}
+	}
+	if len(matched) > 0 {
+		return matched
+	}
+
+	for suffix := name; ; {
+		dot := strings.IndexByte(suffix, '.')
+		if dot < 0 {
+			return nil
+		}
+		suffix = suffix[dot+1:]
+		for _, record := range localRecords {
+			if record.Name == "*."+suffix {
+				matched = append(matched, record)
+			}
+		}
+		if len(matched) > 0 {
+			return matched
+		}
+	}
+}
+
+// localResource converts a record to a DNS resource owned by name
+func localResource(name dnsmessage.Name, record LocalRecord) (dnsmessage.Resource, error) {
+	ttl := record.TTL
+	if ttl == 0 {
+		ttl = defaultLocalRecordTTL
+	}
+	header := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: ttl}
+
+	switch record.Type {
+	case "A":
+		header.Type = dnsmessage.TypeA
+		return dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: netip.MustParseAddr(record.Value).As4()}}, nil
+	case "AAAA":
+		header.Type = dnsmessage.TypeAAAA
+		return dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr(record.Value).As16()}}, nil
+	case "CNAME":
+		target, err := dnsmessage.NewName(record.Value + ".")
+		if err != nil {
+			return dnsmessage.Resource{}, err
+		}
+		header.Type = dnsmessage.TypeCNAME
+		return dnsmessage.Resource{Header: header, Body: &dnsmessage.CNAMEResource{CNAME: target}}, nil
+	default:
+		// Long texts are split into the 255-byte character strings TXT requires
+		var segments []string
+		for text := record.Value; text != ""; {
+			n := min(len(text), maxTXTSegmentLength)
+			segments = append(segments, text[:n])
+			text = text[n:]
+		}
+		header.Type = dnsmessage.TypeTXT
+		return dnsmessage.Resource{Header: header, Body: &dnsmessage.TXTResource{TXT: segments}}, nil
+	}
+}
+
+// resolveLocalRecords answers a query from the local table
+// Returns nil when no record owns the name; a matched name without records of the
+// queried type gets an authoritative NODATA. cnameTarget is set when the chain
+// leaves the local table and must be resolved upstream
+func resolveLocalRecords(query dnsmessage.Message) (resp *dnsmessage.Message, cnameTarget string, err error) {
+	if len(query.Questions) == 0 {
+		return nil, "", nil
+	}
+	question := query.Questions[0]
+
+	localRecordsMux.RLock()
+	defer localRecordsMux.RUnlock()
+
+	if len(localRecords) == 0 {
+		return nil, "", nil
+	}
+
+	owner := question.Name
+	name := strings.ToLower(strings.TrimSuffix(owner.String(), "."))
+	records := matchLocalRecords(name)
+	if records == nil {
+		return nil, "", nil
+	}
+
+	resp = &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.Header.ID,
+			Response:           true,
+			Authoritative:      true,
+			RecursionDesired:   query.Header.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              dnsmessage.RCodeSuccess,
+		},
+		Questions: query.Questions,
+	}
+
+	for hop := 0; ; hop++ {
+		if hop >= maxLocalCNAMEChain {
+			resp.Header.RCode = dnsmessage.RCodeServerFailure
+			return resp, "", nil
+		}
+
+		// A CNAME owns the whole name, so follow it unless CNAME itself was asked for
+		var cname *LocalRecord
+		for i := range records {
+			if records[i].Type == "CNAME" {
+				cname = &records[i]
+			}
+		}
+		if cname != nil && question.Type != dnsmessage.TypeCNAME {
+			resource, err := localResource(owner, *cname)
+			if err != nil {
+				return nil, "", err
+			}
+			resp.Answers = append(resp.Answers, resource)
+
+			owner = resource.Body.(*dnsmessage.CNAMEResource).CNAME
+			records = matchLocalRecords(cname.Value)
+			if records == nil {
+				return resp, cname.Value, nil // Continue upstream from the target
+			}
+			continue
+		}
+
+		for _, record := range records {
+			if record.Type != qtypeName(question.Type) && question.Type != dnsmessage.TypeALL {
+				continue
+			}
+			resource, err := localResource(owner, record)
+			if err != nil {
+				return nil, "", err
+			}
+			resp.Answers = append(resp.Answers, resource)
+		}
+		return resp, "", nil
+	}
+}
+
+// localRecordCount returns the number of local records for health reporting
+func localRecordCount() int {
+	localRecordsMux.RLock()
+	defer localRecordsMux.RUnlock()
+	return len(localRecords)
+}
+
+// newLocalRecordID returns a random identifier for API management
+func newLocalRecordID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// ============================================================================
+// LOCAL RECORD API HANDLERS
+// Manage the rewrite table; changes are persisted to the local config file
+// ============================================================================
+
+// handleLocalRecordsList returns all local records
+func handleLocalRecordsList(c *gin.Context) {
+	localRecordsMux.RLock()
+	records := append([]LocalRecord{}, localRecords...)
+	localRecordsMux.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"records":     records,
+		"default_ttl": defaultLocalRecordTTL,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleLocalRecordAdd validates, stores and persists a new local record
+func handleLocalRecordAdd(c *gin.Context) {
+	var record LocalRecord
+	if err := c.ShouldBindJSON(&record); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	if err := validateLocalRecord(&record); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	record.ID = newLocalRecordID()
+
+	localRecordsMux.Lock()
+	defer localRecordsMux.Unlock()
+
+	if len(localRecords) >= maxLocalRecords {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("local record limit %d reached", maxLocalRecords)})
+		return
+	}
+	for _, existing := range localRecords {
+		if existing.Name != record.Name {
+			continue
+		}
+		if (existing.Type == "CNAME") != (record.Type == "CNAME") {
+			c.JSON(http.StatusConflict, gin.H{"error": "a CNAME cannot coexist with other records for the same name"})
+			return
+		}
+		if existing.Type == "CNAME" || (existing.Type == record.Type && existing.Value == record.Value) {
+			c.JSON(http.StatusConflict, gin.H{"error": "record already exists"})
+			return
+		}
+	}
+
+	localRecords = append(localRecords, record)
+	if err := saveLocalRecords(); err != nil {
+		localRecords = localRecords[:len(localRecords)-1]
+		log.Printf("❌ Failed to save local records: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save local records"})
+		return
+	}
+
+	log.Printf("🏠 Local record added (%s), %d total", record.Type, len(localRecords))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"record":    record,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleLocalRecordDelete removes a local record by ID and persists the table
+func handleLocalRecordDelete(c *gin.Context) {
+	id := c.Param("id")
+
+	localRecordsMux.Lock()
+	defer localRecordsMux.Unlock()
+
+	for i, record := range localRecords {
+		if record.ID != id {
+			continue
+		}
+
+		previous := localRecords
+		localRecords = append(append([]LocalRecord{}, localRecords[:i]...), localRecords[i+1:]...)
+		if err := saveLocalRecords(); err != nil {
+			localRecords = previous
+			log.Printf("❌ Failed to save local records: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save local records"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":    "deleted",
+			"id":        id,
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "local record not found"})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// installLocalRecords replaces the local table and its file for the duration of a test
+func installLocalRecords(t *testing.T, records ...LocalRecord) {
+	t.Helper()
+
+	for i := range records {
+		if err := validateLocalRecord(&records[i]); err != nil {
+			t.Fatalf("record %+v: %v", records[i], err)
+		}
+		records[i].ID = newLocalRecordID()
+	}
+
+	localRecordsMux.Lock()
+	previous, previousPath := localRecords, localRecordsPath
+	localRecords = records
+	localRecordsPath = filepath.Join(t.TempDir(), "shroudinger", "local-records.json")
+	localRecordsMux.Unlock()
+
+	t.Cleanup(func() {
+		localRecordsMux.Lock()
+		localRecords, localRecordsPath = previous, previousPath
+		localRecordsMux.Unlock()
+	})
+}
+
+// localRecordsRouter serves the local record handlers
+func localRecordsRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/records", handleLocalRecordAdd)
+	router.DELETE("/records/:id", handleLocalRecordDelete)
+	return router
+}
+
+// addLocalRecord posts a record and returns the status and stored record
+func addLocalRecord(t *testing.T, router *gin.Engine, record LocalRecord) (int, LocalRecord) {
+	t.Helper()
+
+	body, _ := json.Marshal(record)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/records", bytes.NewReader(body)))
+
+	var response struct {
+		Record LocalRecord `json:"record"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	return recorder.Code, response.Record
+}
+
+func TestResolveLocalRecordsPrecedence(t *testing.T) {
+	installLocalRecords(t,
+		LocalRecord{Name: "*.dev.test", Type: "A", Value: "127.0.0.1"},
+		LocalRecord{Name: "api.dev.test", Type: "A", Value: "10.0.0.2"},
+		LocalRecord{Name: "*.test", Type: "A", Value: "10.9.9.9"},
+		LocalRecord{Name: "nas.home", Type: "TXT", Value: "shared drive"},
+	)
+
+	tests := []struct {
+		domain  string
+		qtype   dnsmessage.Type
+		local   bool
+		answers string
+	}{
+		{"api.dev.test", dnsmessage.TypeA, true, "[10.0.0.2]"},  // Exact name beats the wildcard
+		{"www.dev.test", dnsmessage.TypeA, true, "[127.0.0.1]"}, // Wildcard
+		{"a.b.dev.test", dnsmessage.TypeA, true, "[127.0.0.1]"}, // Deepest wildcard wins
+		{"other.test", dnsmessage.TypeA, true, "[10.9.9.9]"},    // Shallower wildcard
+		{"dev.test", dnsmessage.TypeA, true, "[10.9.9.9]"},      // A wildcard does not own its apex
+		{"api.dev.test", dnsmessage.TypeAAAA, true, "[]"},       // NODATA, no fallback to the wildcard
+		{"nas.home", dnsmessage.TypeA, true, "[]"},              // Name known, type not
+		{"www.example", dnsmessage.TypeA, false, ""},            // Not local
+	}
+	for _, test := range tests {
+		resp, target, err := resolveLocalRecords(mustQuery(t, test.domain, test.qtype))
+		if err != nil {
+			t.Fatalf("resolveLocalRecords(%s): %v", test.domain, err)
+		}
+		if (resp != nil) != test.local || target != "" {
+			t.Fatalf("%s %v: local answer %v, target %q; want local %v", test.domain, test.qtype, resp != nil, target, test.local)
+		}
+		if resp == nil {
+			continue
+		}
+		if !resp.Header.Authoritative || resp.Header.RCode != dnsmessage.RCodeSuccess {
+			t.Errorf("%s: header %+v, want authoritative NOERROR", test.domain, resp.Header)
+		}
+		if got := fmt.Sprint(answerAddrs(resp)); got != test.answers {
+			t.Errorf("%s %v: answers %s, want %s", test.domain, test.qtype, got, test.answers)
+		}
+	}
+}
+
+func TestResolveQueryLocalCNAMEUpstream(t *testing.T) {
+	installDoTResolver(t)
+	installLocalRecords(t,
+		LocalRecord{Name: "intranet.home", Type: "CNAME", Value: "portal.home"},
+		LocalRecord{Name: "portal.home", Type: "CNAME", Value: "www.example"},
+		LocalRecord{Name: "promo.home", Type: "CNAME", Value: "ads.example"},
+	)
+
+	resolution, err := resolveQuery(context.Background(), mustQuery(t, "intranet.home", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution.Source != "local" || resolution.Response.Header.Authoritative {
+		t.Errorf("source %s authoritative %v, want local and not authoritative", resolution.Source, resolution.Response.Header.Authoritative)
+	}
+	answers := resolution.Response.Answers
+	if len(answers) != 3 || answers[0].Header.Type != dnsmessage.TypeCNAME || answers[1].Header.Type != dnsmessage.TypeCNAME {
+		t.Fatalf("answers = %+v, want two local CNAMEs and the upstream A record", answers)
+	}
+	if got := fmt.Sprint(answerAddrs(resolution.Response)); got != "[93.184.215.14]" {
+		t.Errorf("addresses = %s, want the upstream answer for www.example", got)
+	}
+
+	// The upstream part of the chain still goes through the blocklist
+	resolution, err = resolveQuery(context.Background(), mustQuery(t, "promo.home", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution.Source != "blocked" || resolution.Decision.Policy != "blocklist" {
+		t.Errorf("promo.home: source %s policy %q, want blocked by the blocklist", resolution.Source, resolution.Decision.Policy)
+	}
+}
+
+func TestResolveLocalRecordsCNAMEChainLimit(t *testing.T) {
+	records := []LocalRecord{
+		{Name: "ping.loop", Type: "CNAME", Value: "pong.loop"},
+		{Name: "pong.loop", Type: "CNAME", Value: "ping.loop"},
+	}
+	// A chain one hop longer than allowed, ending in an address
+	for i := 0; i < maxLocalCNAMEChain; i++ {
+		records = append(records, LocalRecord{Name: fmt.Sprintf("hop%d.chain", i), Type: "CNAME", Value: fmt.Sprintf("hop%d.chain", i+1)})
+	}
+	records = append(records, LocalRecord{Name: fmt.Sprintf("hop%d.chain", maxLocalCNAMEChain), Type: "A", Value: "10.0.0.1"})
+	installLocalRecords(t, records...)
+
+	for _, domain := range []string{"ping.loop", "hop0.chain"} {
+		resp, target, err := resolveLocalRecords(mustQuery(t, domain, dnsmessage.TypeA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.Header.RCode != dnsmessage.RCodeServerFailure || target != "" {
+			t.Errorf("%s: %+v, target %q; want SERVFAIL", domain, resp, target)
+		}
+		if resp != nil && len(resp.Answers) != maxLocalCNAMEChain {
+			t.Errorf("%s: %d answers, want %d hops", domain, len(resp.Answers), maxLocalCNAMEChain)
+		}
+	}
+
+	// One hop fewer resolves
+	resp, _, err := resolveLocalRecords(mustQuery(t, "hop1.chain", dnsmessage.TypeA))
+	if err != nil || resp.Header.RCode != dnsmessage.RCodeSuccess || fmt.Sprint(answerAddrs(resp)) != "[10.0.0.1]" {
+		t.Errorf("hop1.chain: %+v, %v; want 10.0.0.1", resp, err)
+	}
+}
+
+func TestHandleLocalRecordAddConflicts(t *testing.T) {
+	installLocalRecords(t)
+	router := localRecordsRouter()
+
+	tests := []struct {
+		record LocalRecord
+		code   int
+	}{
+		{LocalRecord{Name: "nas.home", Type: "A", Value: "192.168.1.10"}, http.StatusCreated},
+		{LocalRecord{Name: "nas.home", Type: "AAAA", Value: "fd00::10"}, http.StatusCreated},
+		{LocalRecord{Name: "NAS.home.", Type: "a", Value: "192.168.1.10"}, http.StatusConflict}, // Duplicate after normalization
+		{LocalRecord{Name: "nas.home", Type: "CNAME", Value: "storage.home"}, http.StatusConflict},
+		{LocalRecord{Name: "alias.home", Type: "CNAME", Value: "nas.home"}, http.StatusCreated},
+		{LocalRecord{Name: "alias.home", Type: "TXT", Value: "note"}, http.StatusConflict},
+		{LocalRecord{Name: "alias.home", Type: "CNAME", Value: "other.home"}, http.StatusConflict},
+		{LocalRecord{Name: "self.home", Type: "CNAME", Value: "self.home"}, http.StatusBadRequest},
+		{LocalRecord{Name: "bad.home", Type: "A", Value: "fd00::1"}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if code, _ := addLocalRecord(t, router, test.record); code != test.code {
+			t.Errorf("add %+v: status %d, want %d", test.record, code, test.code)
+		}
+	}
+	if count := localRecordCount(); count != 3 {
+		t.Errorf("stored %d records, want 3", count)
+	}
+}
+
+func TestLocalRecordsSaveAndReload(t *testing.T) {
+	installLocalRecords(t)
+	router := localRecordsRouter()
+
+	_, nas := addLocalRecord(t, router, LocalRecord{Name: "nas.home", Type: "A", Value: "192.168.1.10", TTL: 60})
+	_, dev := addLocalRecord(t, router, LocalRecord{Name: "*.dev.test", Type: "A", Value: "127.0.0.1"})
+	_, note := addLocalRecord(t, router, LocalRecord{Name: "note.home", Type: "TXT", Value: "kept"})
+
+	// Written atomically with owner-only permissions
+	info, err := os.Stat(localRecordsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != localRecordsFileMode {
+		t.Errorf("file mode = %v, want %v", mode, os.FileMode(localRecordsFileMode))
+	}
+	if _, err := os.Stat(localRecordsPath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/records/"+note.ID, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("delete status = %d", recorder.Code)
+	}
+
+	// Reload from disk into an empty table
+	localRecordsMux.Lock()
+	localRecords = nil
+	localRecordsMux.Unlock()
+	if err := loadLocalRecords(); err != nil {
+		t.Fatal(err)
+	}
+
+	localRecordsMux.RLock()
+	reloaded := append([]LocalRecord{}, localRecords...)
+	localRecordsMux.RUnlock()
+	if len(reloaded) != 2 || reloaded[0] != nas || reloaded[1] != dev {
+		t.Errorf("reloaded %+v, want %+v and %+v", reloaded, nas, dev)
+	}
+
+	// A missing file is an empty table; a corrupt one is an error
+	os.Remove(localRecordsPath)
+	if err := loadLocalRecords(); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+	os.WriteFile(localRecordsPath, []byte(`[{"name": "x.home", "type": "MX", "value": "y"}]`), localRecordsFileMode)
+	if err := loadLocalRecords(); err == nil {
+		t.Error("invalid record loaded")
+	}
+}
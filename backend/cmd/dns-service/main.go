@@ -21,10 +21,12 @@ import (
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 const (
@@ -41,6 +43,7 @@ const (
 	defaultTimeoutSeconds = 5		// DNS query timeout
 	maxRetryAttempts = 3			// Retry failed queries
 	cacheTTLMinutes = 60			// DNS cache TTL
+	healthProbeDomain = "example.com"	// Boot probe name (RFC 2606 reserved)
 	
 	// Connection pooling
 	maxConnectionsPerServer = 10		// Connections per DNS server
@@ -97,8 +100,17 @@ func main() {
 			dns.POST("/batch", handleBatchResolve)		// Batch resolution
 			dns.GET("/servers", handleDNSServers)		// List DNS servers
 			dns.POST("/test", handleDNSTest)		// Test server connectivity
+			
+			// Local records (answered before cache and upstream)
+			dns.GET("/records", handleLocalRecordsList)		// List local records
+			dns.POST("/records", handleLocalRecordAdd)		// Add a local record
+			dns.DELETE("/records/:id", handleLocalRecordDelete)	// Remove a local record
 		}
 		
+		// Blocked-response configuration
+		api.GET("/blocking/config", handleBlockingConfig)		// Mode, overrides and TTL
+		api.POST("/blocking/config", handleBlockingConfigUpdate)	// Replace blocking settings
+		
 		// Cache management (anonymous)
 		cache := api.Group("/cache")
 		{
@@ -121,6 +133,7 @@ func main() {
 			protocol.GET("/dot", handleDoTStatus)		// DNS over TLS status
 			protocol.GET("/doh", handleDoHStatus)		// DNS over HTTPS status
 			protocol.GET("/doq", handleDoQStatus)		// DNS over QUIC status
+			protocol.GET("/odoh", handleODoHStatus)		// Oblivious DNS over HTTPS status
 		}
 	}
 
@@ -134,8 +147,21 @@ func main() {
 		MaxHeaderBytes: 1024,			// Small headers for performance
 	}
 
-	// Initialize DNS resolver and connection pools
-	go initializeDNSResolver()
+	// Refuse to start with a mistyped blocking mode or address
+	if err := checkBlockingConfig(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	
+	// Load user-defined local records from the config dir
+	if err := loadLocalRecords(); err != nil {
+		log.Printf("⚠️ Local records not loaded: %v", err)
+	}
+	
+	// Initialize DNS resolver and connection pools before serving requests;
+	// its background loops stop at shutdown
+	resolverCtx, stopResolver := context.WithCancel(context.Background())
+	defer stopResolver()
+	initializeDNSResolver(resolverCtx)
 	
 	// Start performance monitoring
 	go startPerformanceMonitoring()
@@ -144,7 +170,7 @@ func main() {
 	go func() {
 		log.Printf("🚀 DNS Service starting on port %s", port)
 		log.Printf("🔒 Privacy mode: No query logging, no domain persistence")
-		log.Printf("🔐 Encrypted protocols: DoT, DoH, DoQ")
+		log.Printf("🔐 Encrypted protocols: DoT, DoH, DoQ, DNSCrypt, ODoH")
 		log.Printf("⚡ Performance target: <%dms resolution, <%dMB memory", 
 			dnsResolutionTargetMs, maxMemoryUsageMB)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -152,15 +178,13 @@ func main() {
 		}
 	}()
 
-	// Initialize DNS resolver
-	go initializeDNSResolver()
-
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Println("🛑 DNS Service shutting down...")
+	stopResolver()
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -221,29 +245,46 @@ type ConnectionPool struct {
 // AnonymousCache provides DNS caching without storing domain names
 // Uses hashed keys to maintain privacy while enabling performance
 type AnonymousCache struct {
-	entries    map[string]*CacheEntry  // Hash -> Entry
-	ttl        map[string]time.Time    // Hash -> Expiry
+	shards     [cacheShardCount]*cacheShard // Independently locked LRU partitions
+	salt       []byte                       // Per-boot HMAC key, never persisted
+	keys       cacheKeyring                 // In-memory payload keys, rotated on a schedule
 	maxSize    int
+	maxBytes   int64                        // Memory budget across all shards
+	evictions  int64                        // LRU evictions (atomic)
+	staleServed int64                       // Expired answers served (atomic)
+	prefetched  int64                       // Prefetch queries sent (atomic)
+	prefetchFailed  int64                   // Prefetch queries that failed (atomic)
+	prefetchSkipped int64                   // Candidates dropped by the rate limit (atomic)
+	unsealed   bool                         // Payloads kept in the clear; only benchmarks set it to measure sealing
 	stats      CacheStats
 }
 
 // CacheEntry stores DNS response data without domain names
 type CacheEntry struct {
-	HashedKey    string        // SHA-256 hash of domain
-	ResponseData []byte        // Encrypted response data
+	HashedKey    string        // HMAC of name, type and class
+	ResponseData []byte        // Encrypted response data: nonce || AES-256-GCM ciphertext
+	KeyGeneration uint64       // Payload key that sealed ResponseData
 	CreatedAt    time.Time     // Cache entry creation time
-	AccessCount  int64         // Usage frequency
+	ExpiresAt    time.Time     // Creation time plus the minimum answer TTL
+	RefreshAfter time.Time     // Earliest background refresh once stale
+	AccessCount  int64         // Usage frequency, halved on each refresh
 }
 
 // DNSServer represents an encrypted DNS server
 type DNSServer struct {
 	Name        string   // "Cloudflare", "Quad9", etc.
 	Address     string   // "1.1.1.1", "9.9.9.9", etc.
+	ServerName  string   // TLS certificate name, e.g. "cloudflare-dns.com"
+	URL         string   // DoH endpoint, e.g. "https://cloudflare-dns.com/dns-query"
+	Stamp       string   // sdns:// stamp for DNSCrypt
+	ProxyURL    string   // ODoH proxy relaying sealed queries to URL
+	Weight      int      // Round-robin share (UPSTREAM_WEIGHTS, 0 counts as 1)
 	Port        int      // 853 for DoT, 443 for DoH
-	Protocols   []string // ["DoT", "DoH", "DoQ"]
+	Protocols   []string // ["DoT", "DoH", "DoQ", "DNSCrypt", "ODoH"]
 	Healthy     bool     // Server health status
 	Latency     time.Duration // Average latency
 	ErrorCount  int64    // Error counter
+	FailureStreak int64  // Consecutive failed exchanges, reset by a success
 	LastCheck   time.Time // Last health check
 }
 
@@ -269,6 +310,7 @@ type CacheStats struct {
 	Entries     int64
 	HitRate     float64
 	MemoryUsage int64
+	Evictions   int64
 }
 
 // DNSTestRequest represents a DNS test request from the frontend
@@ -306,7 +348,7 @@ func privacyMiddleware() gin.HandlerFunc {
 		
 		// Service identification
 		c.Header("X-Service", "dns-service")
-		c.Header("X-Protocols", "DoT,DoH,DoQ")
+		c.Header("X-Protocols", "DoT,DoH,DoQ,DNSCrypt,ODoH")
 		c.Header("X-Performance-Target", fmt.Sprintf("<%dms", dnsResolutionTargetMs))
 		
 		c.Next()
@@ -344,7 +386,8 @@ func securityMiddleware() gin.HandlerFunc {
 // ============================================================================
 
 // initializeDNSResolver sets up encrypted DNS resolution system
-func initializeDNSResolver() {
+// The cache's background loops run until ctx is done
+func initializeDNSResolver(ctx context.Context) {
 	log.Println("🔧 Initializing encrypted DNS resolver...")
 	
 	startTime = time.Now()
@@ -356,38 +399,72 @@ func initializeDNSResolver() {
 		dohConnections: NewConnectionPool("DoH", maxConnectionsPerServer),
 		doqConnections: NewConnectionPool("DoQ", maxConnectionsPerServer),
 		cache:         NewAnonymousCache(10000), // 10k cache entries
-		activeProtocol: "DoT", // Default to DNS over TLS
+		activeProtocol: initialProtocol(), // DNS over TLS unless DNS_PROTOCOL overrides
 		stats:         DNSResolverStats{},
 	}
 	
 	// Initialize encrypted DNS servers
 	dnsResolver.servers = []DNSServer{
 		{
-			Name:      "Cloudflare",
-			Address:   "1.1.1.1",
-			Port:      853,
-			Protocols: []string{"DoT", "DoH", "DoQ"},
-			Healthy:   true,
+			Name:       "Cloudflare",
+			Address:    "1.1.1.1",
+			ServerName: "cloudflare-dns.com",
+			URL:        "https://cloudflare-dns.com/dns-query",
+			Port:       853,
+			Protocols:  []string{"DoT", "DoH", "DoQ"},
+			Healthy:    true,
 		},
 		{
-			Name:      "Quad9",
-			Address:   "9.9.9.9", 
-			Port:      853,
-			Protocols: []string{"DoT", "DoH"},
-			Healthy:   true,
+			Name:       "Quad9",
+			Address:    "9.9.9.9", 
+			ServerName: "dns.quad9.net",
+			URL:        "https://dns.quad9.net/dns-query",
+			Port:       853,
+			Protocols:  []string{"DoT", "DoH"},
+			Healthy:    true,
 		},
 		{
-			Name:      "Google",
-			Address:   "8.8.8.8",
-			Port:      853,
-			Protocols: []string{"DoT", "DoH"},
-			Healthy:   true,
+			Name:       "Google",
+			Address:    "8.8.8.8",
+			ServerName: "dns.google",
+			URL:        "https://dns.google/dns-query",
+			Port:       853,
+			Protocols:  []string{"DoT", "DoH"},
+			Healthy:    true,
 		},
 	}
+	
+	// DNSCrypt resolvers from sdns:// stamps (DNSCRYPT_STAMPS, comma-separated)
+	for _, stamp := range strings.Split(os.Getenv("DNSCRYPT_STAMPS"), ",") {
+		if stamp = strings.TrimSpace(stamp); stamp == "" {
+			continue
+		}
+		server, err := dnscryptServer(stamp)
+		if err != nil {
+			log.Printf("⚠️ DNSCrypt stamp skipped: %v", err)
+			continue
+		}
+		dnsResolver.servers = append(dnsResolver.servers, server)
+	}
+	
+	// Oblivious DoH target, reached only through the proxy (ODOH_PROXY)
+	if odohProxyURL != "" {
+		server, err := odohServer(odohTargetURL, odohProxyURL)
+		if err != nil {
+			log.Printf("⚠️ ODoH server skipped: %v", err)
+		} else {
+			dnsResolver.servers = append(dnsResolver.servers, server)
+		}
+	}
+	applyUpstreamWeights(dnsResolver.servers)
+	cache := dnsResolver.cache
 	mutex.Unlock()
 	
-	log.Println("🔐 Encrypted DNS connections initialized (DoT/DoH/DoQ)")
-	log.Printf("💾 Anonymous cache initialized (hashed keys, no domain storage)")
+	log.Println("🔐 Encrypted DNS connections initialized (DoT/DoH/DoQ/DNSCrypt/ODoH)")
+	go cache.startKeyRotation(ctx, cacheKeyRotation)
+	go cache.startPrefetching(ctx, refreshCached)
+	
+	log.Printf("💾 Anonymous cache initialized (hashed keys, encrypted payloads, no domain storage)")
 	log.Printf("🎯 Performance targets: <%dms resolution, >%.0f%% cache hit rate", 
 		dnsResolutionTargetMs, targetCacheHitRate*100)
 	
@@ -409,11 +486,14 @@ func NewConnectionPool(protocol string, maxSize int) *ConnectionPool {
 
 // NewAnonymousCache creates a new anonymous DNS cache
 func NewAnonymousCache(maxSize int) *AnonymousCache {
+	maxBytes := int64(cacheMemoryBudgetMB) << 20
 	return &AnonymousCache{
-		entries: make(map[string]*CacheEntry),
-		ttl:     make(map[string]time.Time),
-		maxSize: maxSize,
-		stats:   CacheStats{},
+		shards:   newCacheShards(maxSize, maxBytes),
+		salt:     newCacheSalt(),
+		keys:     cacheKeyring{current: newCacheKey(1)},
+		maxSize:  maxSize,
+		maxBytes: maxBytes,
+		stats:    CacheStats{},
 	}
 }
 
@@ -421,37 +501,40 @@ func NewAnonymousCache(maxSize int) *AnonymousCache {
 func testServerConnections() {
 	log.Println("🔗 Testing encrypted DNS server connections...")
 	
-	mutex.RLock()
-	servers := dnsResolver.servers
-	mutex.RUnlock()
-	
-	for i, server := range servers {
-		go testServerConnection(i, server)
+	for _, server := range upstreamServers() {
+		go testServerConnection(server)
 	}
 }
 
-// testServerConnection tests connection to a single DNS server
-func testServerConnection(index int, server DNSServer) {
-	start := time.Now()
+// testServerConnection sends one probe query to a server over the active protocol
+// and records the result like any other exchange
+// Privacy: The probe name is fixed and carries no user data
+func testServerConnection(server upstreamServer) {
+	query, err := newQuery(healthProbeDomain, dnsmessage.TypeA)
+	if err != nil {
+		return
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return
+	}
 	
-	// TODO: Implement actual connection testing for DoT/DoH/DoQ
-	// For now, simulate connection test
-	time.Sleep(50 * time.Millisecond) // Simulate network delay
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeoutSeconds*time.Second)
+	defer cancel()
 	
+	start := time.Now()
+	raw, err := exchangeServer(ctx, server, packed)
+	if err == nil {
+		_, err = parseUpstreamResponse(query, raw)
+	}
 	latency := time.Since(start)
+	recordServerResult(server.DNSServer, latency, err)
 	
-	mutex.Lock()
-	dnsResolver.servers[index].Latency = latency
-	dnsResolver.servers[index].LastCheck = time.Now()
-	dnsResolver.servers[index].Healthy = latency < 100*time.Millisecond
-	mutex.Unlock()
-	
-	status := "✅"
-	if !dnsResolver.servers[index].Healthy {
-		status = "❌"
+	if err != nil {
+		log.Printf("❌ %s (%s) over %s: %v", server.Name, server.Address, server.protocol, err)
+		return
 	}
-	
-	log.Printf("%s %s (%s): %v latency", status, server.Name, server.Address, latency)
+	log.Printf("✅ %s (%s) over %s: %v latency", server.Name, server.Address, server.protocol, latency)
 }
 
 // startPerformanceMonitoring tracks DNS resolution performance
@@ -481,7 +564,9 @@ func updatePerformanceStats() {
 	// Calculate performance metrics (no user data)
 	if queryCount > 0 {
 		dnsResolver.stats.AverageLatency = totalResolutionTime / time.Duration(queryCount)
-		dnsResolver.stats.CacheHitRate = float64(cacheHits) / float64(queryCount)
+	}
+	if lookups := cacheHits + cacheMisses; lookups > 0 {
+		dnsResolver.stats.CacheHitRate = float64(cacheHits) / float64(lookups)
 	}
 	
 	dnsResolver.stats.QueriesResolved = queryCount
@@ -491,7 +576,8 @@ func updatePerformanceStats() {
 		dnsResolver.cache.stats.Hits = cacheHits
 		dnsResolver.cache.stats.Misses = cacheMisses
 		dnsResolver.cache.stats.HitRate = dnsResolver.stats.CacheHitRate
-		dnsResolver.cache.stats.Entries = int64(len(dnsResolver.cache.entries))
+		dnsResolver.cache.stats.Entries, dnsResolver.cache.stats.MemoryUsage = dnsResolver.cache.Usage()
+		dnsResolver.cache.stats.Evictions = atomic.LoadInt64(&dnsResolver.cache.evictions)
 	}
 	
 	// Performance warnings
@@ -556,33 +642,39 @@ func performDoHTest(req DNSTestRequest) DNSTestResult {
 	}
 	
 	// Use the provided URL or construct from host
-	testURL := req.URL
-	if testURL == "" {
-		testURL = fmt.Sprintf("https://%s/dns-query", req.Host)
-	}
+	server := DNSServer{Name: req.Host, Address: req.Host, URL: req.URL}
 	
-	// Perform HTTP request to DNS server
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+	// RFC 8484 wire-format query for the test domain's A record
+	query, err := newQuery(req.TestDomain, dnsmessage.TypeA)
+	if err != nil {
+		result.Error = "invalid test domain"
+		result.ResponseTime = time.Since(start)
+		return result
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		result.Error = fmt.Sprintf("DoH query encoding failed: %v", err)
+		result.ResponseTime = time.Since(start)
+		return result
 	}
 	
-	// Simple DNS query for A record
-	dnsQuery := fmt.Sprintf("%s?name=%s&type=A", testURL, req.TestDomain)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeoutSeconds*time.Second)
+	defer cancel()
 	
-	resp, err := client.Get(dnsQuery)
+	raw, err := exchangeDoH(ctx, server, packed, dohMethod)
 	if err != nil {
-		result.Error = fmt.Sprintf("DoH request failed: %v", err)
+		result.Error = err.Error()
 		result.ResponseTime = time.Since(start)
 		return result
 	}
-	defer resp.Body.Close()
 	
-	// Check response
-	if resp.StatusCode == http.StatusOK {
-		result.Success = true
+	// Only a DNS answer matching our ID and question proves a working DoH server
+	if _, err := parseUpstreamResponse(query, raw); err != nil {
+		result.Error = fmt.Sprintf("DoH server returned an invalid answer: %v", err)
 		result.Encryption = "verified"
 	} else {
-		result.Error = fmt.Sprintf("DoH server returned status %d", resp.StatusCode)
+		result.Success = true
+		result.Encryption = "verified"
 	}
 	
 	result.ResponseTime = time.Since(start)
@@ -664,26 +756,45 @@ func performDoQTest(req DNSTestRequest) DNSTestResult {
 		Encryption: "unknown",
 	}
 	
-	// For now, simulate DoQ test since implementing QUIC DNS client is complex
-	// This would require a proper QUIC DNS client library
+	// DoQ listens on UDP 853 unless the server says otherwise
+	port := req.Port
+	if port == 0 {
+		port = 853
+	}
+	server := DNSServer{Name: req.Host, Address: req.Host, Port: port}
 	
-	// Simulate connection test
-	address := fmt.Sprintf("%s:%d", req.Host, req.Port)
+	query, err := newQuery(req.TestDomain, dnsmessage.TypeA)
+	if err != nil {
+		result.Error = "invalid test domain"
+		result.ResponseTime = time.Since(start)
+		return result
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		result.Error = fmt.Sprintf("DoQ query encoding failed: %v", err)
+		result.ResponseTime = time.Since(start)
+		return result
+	}
+	
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeoutSeconds*time.Second)
+	defer cancel()
 	
-	// Test basic connectivity (fallback to TCP for testing)
-	conn, err := net.DialTimeout("tcp", address, 3*time.Second)
+	// QUIC handshake with certificate verification and ALPN "doq", then a real query
+	raw, err := exchangeDoQ(ctx, server, packed)
 	if err != nil {
-		result.Error = fmt.Sprintf("DoQ connection failed: %v", err)
+		result.Error = err.Error()
 		result.ResponseTime = time.Since(start)
 		return result
 	}
-	defer conn.Close()
 	
-	// Basic connection successful (simulated)
-	result.Success = true
 	result.Encryption = "verified"
-	result.ResponseTime = time.Since(start)
+	if _, err := parseUpstreamResponse(query, raw); err != nil {
+		result.Error = fmt.Sprintf("DoQ server returned an invalid answer: %v", err)
+	} else {
+		result.Success = true
+	}
 	
+	result.ResponseTime = time.Since(start)
 	return result
 }
 
@@ -724,7 +835,7 @@ func handleHealthCheck(c *gin.Context) {
 		"servers": gin.H{
 			"total": serverCount,
 			"healthy": healthyServers,
-			"protocols": []string{"DoT", "DoH", "DoQ"},
+			"protocols": []string{"DoT", "DoH", "DoQ", "DNSCrypt", "ODoH"},
 		},
 		"performance": gin.H{
 			"resolution_target_ms": dnsResolutionTargetMs,
@@ -737,6 +848,13 @@ func handleHealthCheck(c *gin.Context) {
 			"anonymous_caching": true,
 			"data_retention": "none",
 		},
+		"filtering": gin.H{
+			"response_ip_filter": ipFilterMode,
+			"rebinding_protection": rebindingMode,
+			"safe_search": safeSearchMode,
+			"local_records": localRecordCount(),
+			"blocking_mode": currentBlockingConfig().Mode,
+		},
 		"response_time": responseTime.String(),
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 	})
@@ -777,24 +895,184 @@ func handlePerformanceMetrics(c *gin.Context) {
 		},
 		"protocols": gin.H{
 			"active_protocol": dnsResolver.activeProtocol,
-			"available_protocols": []string{"DoT", "DoH", "DoQ"},
+			"available_protocols": []string{"DoT", "DoH", "DoQ", "DNSCrypt", "ODoH"},
 		},
 		"response_time": responseTime.String(),
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 	})
 }
 
-// Placeholder handlers for DNS service endpoints
+// handleDNSResolve resolves one name through the encrypted pipeline
+// Privacy: The domain is resolved in memory only and never logged
 func handleDNSResolve(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	var request struct {
+		Domain string `json:"domain"`		// Name to resolve (never logged)
+		Type   string `json:"type,omitempty"`	// Record type, "A" when empty
+	}
+	
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	
+	qtype, ok := parseQType(request.Type)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported type"})
+		return
+	}
+	query, err := newQuery(request.Domain, qtype)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid domain format"})
+		return
+	}
+	
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), defaultTimeoutSeconds*time.Second)
+	defer cancel()
+	
+	resolution, err := resolveQuery(ctx, query)
+	elapsed := time.Since(start)
+	if err != nil {
+		status := http.StatusBadGateway
+		if err == errNoUpstream || err == errResolverNotReady {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{"error": "resolution failed", "details": err.Error()})
+		return
+	}
+	recordQueryStats(elapsed)
+	
+	result := resolutionJSON(resolution)
+	result["type"] = recordTypeName(qtype)
+	result["resolution_time"] = elapsed.String()
+	result["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	c.JSON(http.StatusOK, result)
 }
 
+// handleBatchResolve resolves up to maxBatchResolve names concurrently
+// Privacy: Results are returned by index without the queried names
 func handleBatchResolve(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	var request struct {
+		Domains []string `json:"domains"`		// Names to resolve (never logged)
+		Type    string   `json:"type,omitempty"`	// Record type applied to every name
+	}
+	
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	
+	qtype, ok := parseQType(request.Type)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported type"})
+		return
+	}
+	if len(request.Domains) == 0 || len(request.Domains) > maxBatchResolve {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("batch size must be between 1 and %d", maxBatchResolve),
+		})
+		return
+	}
+	
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), defaultTimeoutSeconds*time.Second)
+	defer cancel()
+	
+	results := make([]gin.H, len(request.Domains))
+	var wg sync.WaitGroup
+	for i, domain := range request.Domains {
+		wg.Add(1)
+		go func(i int, domain string) {
+			defer wg.Done()
+			
+			queryStart := time.Now()
+			query, err := newQuery(domain, qtype)
+			if err != nil {
+				results[i] = gin.H{"index": i, "error": "invalid domain format"}
+				return
+			}
+			resolution, err := resolveQuery(ctx, query)
+			if err != nil {
+				results[i] = gin.H{"index": i, "error": err.Error()}
+				return
+			}
+			recordQueryStats(time.Since(queryStart))
+			
+			result := resolutionJSON(resolution)
+			result["index"] = i
+			results[i] = result
+		}(i, domain)
+	}
+	wg.Wait()
+	
+	failed := 0
+	for _, result := range results {
+		if _, ok := result["error"]; ok {
+			failed++
+		}
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"type": recordTypeName(qtype),
+		"summary": gin.H{
+			"total_domains": len(request.Domains),
+			"resolved": len(request.Domains) - failed,
+			"failed": failed,
+		},
+		"batch_time": time.Since(start).String(),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
 }
 
+// Placeholder handlers for DNS service endpoints
+
+// handleDNSServers lists the configured upstream servers and their protocols
 func handleDNSServers(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	mutex.RLock()
+	if dnsResolver == nil {
+		mutex.RUnlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service not ready"})
+		return
+	}
+	servers := append([]DNSServer(nil), dnsResolver.servers...)
+	activeProtocol := dnsResolver.activeProtocol
+	mutex.RUnlock()
+	
+	entries := make([]gin.H, 0, len(servers))
+	for _, server := range servers {
+		entry := gin.H{
+			"name": server.Name,
+			"address": server.Address,
+			"port": server.Port,
+			"server_name": server.ServerName,
+			"protocols": server.Protocols,
+			"healthy": server.Healthy,
+			"latency": server.Latency.String(),
+			"error_count": server.ErrorCount,
+			"failure_streak": server.FailureStreak,
+			"weight": serverWeight(server),
+		}
+		if server.URL != "" {
+			entry["url"] = server.URL
+		}
+		if server.Stamp != "" {
+			entry["stamp"] = server.Stamp
+			entry["dnscrypt"] = dnscryptInfo(server)
+		}
+		if server.ProxyURL != "" {
+			entry["proxy_url"] = server.ProxyURL
+		}
+		entries = append(entries, entry)
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"servers": entries,
+		"active_protocol": activeProtocol,
+		"strategy": upstreamSelection.Name(),
+		"protocols": []string{"DoT", "DoH", "DoQ", "DNSCrypt", "ODoH"},
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
 }
 
 func handleDNSTest(c *gin.Context) {
@@ -832,12 +1110,6 @@ func handleDNSTest(c *gin.Context) {
 	queryCount++
 	totalResolutionTime += testResult.ResponseTime
 	
-	if testResult.Success {
-		cacheHits++ // Simulate cache behavior
-	} else {
-		cacheMisses++
-	}
-	
 	// Response time for the API call
 	apiResponseTime := time.Since(start)
 	
@@ -868,16 +1140,104 @@ func handleDNSTest(c *gin.Context) {
 	})
 }
 
+// handleCacheStats reports live hit rate, entry count and memory usage
+// Privacy: Only aggregate counters; no keys or responses are exposed
 func handleCacheStats(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	mutex.RLock()
+	if dnsResolver == nil {
+		mutex.RUnlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service not ready"})
+		return
+	}
+	cache, hits, misses := dnsResolver.cache, cacheHits, cacheMisses
+	mutex.RUnlock()
+	
+	entries, memory := cache.Usage()
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"hits": hits,
+		"misses": misses,
+		"hit_rate": hitRate,
+		"entries": entries,
+		"max_entries": cache.maxSize,
+		"memory_usage_bytes": memory,
+		"memory_budget_bytes": cache.maxBytes,
+		"evictions": atomic.LoadInt64(&cache.evictions),
+		"shards": cacheShardCount,
+		"key_hashing": "HMAC-SHA256 with per-boot salt",
+		"payload_encryption": "AES-256-GCM",
+		"key_generation": cache.keyGeneration(),
+		"key_rotation": cacheKeyRotation.String(),
+		"negative_caching": true,
+		"stale_served": atomic.LoadInt64(&cache.staleServed),
+		"max_stale": cacheMaxStale.String(),
+		"prefetch": gin.H{
+			"enabled": cachePrefetchWindow > 0,
+			"window": cachePrefetchWindow.String(),
+			"rate_limit_per_second": cachePrefetchRate,
+			"sent": atomic.LoadInt64(&cache.prefetched),
+			"failed": atomic.LoadInt64(&cache.prefetchFailed),
+			"skipped": atomic.LoadInt64(&cache.prefetchSkipped),
+		},
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
 }
 
+// handleCacheClear drops every cached response
 func handleCacheClear(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	mutex.RLock()
+	if dnsResolver == nil {
+		mutex.RUnlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service not ready"})
+		return
+	}
+	cache := dnsResolver.cache
+	mutex.RUnlock()
+	
+	cleared := cache.Clear()
+	c.JSON(http.StatusOK, gin.H{
+		"status": "cleared",
+		"entries_cleared": cleared,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
 }
 
+// handleCacheHealth checks the cache against its memory budget and hit-rate target
 func handleCacheHealth(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	mutex.RLock()
+	if dnsResolver == nil {
+		mutex.RUnlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service not ready"})
+		return
+	}
+	cache, hits, misses := dnsResolver.cache, cacheHits, cacheMisses
+	mutex.RUnlock()
+	
+	entries, memory := cache.Usage()
+	warnings := []string{}
+	if memory > cache.maxBytes {
+		warnings = append(warnings, "memory usage exceeds budget")
+	}
+	// Hit rate is only meaningful once the cache has seen some traffic
+	if lookups := hits + misses; lookups >= 100 && float64(hits)/float64(lookups) < targetCacheHitRate {
+		warnings = append(warnings, fmt.Sprintf("hit rate below %.0f%% target", targetCacheHitRate*100))
+	}
+	
+	status := "healthy"
+	if len(warnings) > 0 {
+		status = "degraded"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": status,
+		"warnings": warnings,
+		"entries": entries,
+		"memory_usage_percent": float64(memory) / float64(cache.maxBytes) * 100,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
 }
 
 func handleLatencyStats(c *gin.Context) {
@@ -892,16 +1252,42 @@ func handleConnectionStats(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
 }
 
+// handleDoTStatus reports DNS over TLS support and pooled connections
 func handleDoTStatus(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	status := protocolStatus("DoT")
+	status["rfc"] = "RFC 7858"
+	status["open_connections"] = openDoTConnections()
+	c.JSON(http.StatusOK, status)
 }
 
+// handleDoHStatus reports DNS over HTTPS support and the request method
 func handleDoHStatus(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	status := protocolStatus("DoH")
+	status["rfc"] = "RFC 8484"
+	status["method"] = dohMethod
+	status["content_type"] = dohContentType
+	c.JSON(http.StatusOK, status)
 }
 
+// handleDoQStatus reports DNS over QUIC support and open connections
 func handleDoQStatus(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "not_implemented"})
+	status := protocolStatus("DoQ")
+	status["rfc"] = "RFC 9250"
+	status["alpn"] = doqALPN
+	status["zero_rtt_supported"] = doqSessionCache.hasTicket() // A cached session ticket lets the next connection send 0-RTT data
+	status["zero_rtt"] = doqLastZeroRTT.Load()
+	status["open_connections"] = openDoQConnections()
+	c.JSON(http.StatusOK, status)
+}
+
+// handleODoHStatus reports Oblivious DoH support and the configured proxy
+func handleODoHStatus(c *gin.Context) {
+	status := protocolStatus("ODoH")
+	status["rfc"] = "RFC 9230"
+	status["content_type"] = odohContentType
+	status["target"] = odohTargetURL
+	status["proxy_configured"] = odohProxyURL != ""
+	c.JSON(http.StatusOK, status)
 }
 
 // isDNSResolutionError checks if the error is related to DNS resolution
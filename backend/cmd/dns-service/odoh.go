@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ============================================================================
+// OBLIVIOUS DNS OVER HTTPS (RFC 9230)
+// Queries are HPKE-sealed to the target and relayed through a proxy: the proxy
+// sees our address but not the query, the target sees the query but not our address
+// Privacy: Direct queries to the target are never sent; without a proxy ODoH fails
+// ============================================================================
+
+const (
+	odohContentType     = "application/oblivious-dns-message"
+	odohConfigPath      = "/.well-known/odohconfigs"
+	odohVersion         = 0x0001
+	odohMessageQuery    = 0x01
+	odohMessageResponse = 0x02
+	odohPaddingBlock    = 128 // RFC 8467 recommended query block size
+	odohConfigRefresh   = time.Hour
+	maxODoHConfigSize   = 4096
+)
+
+var (
+	// odohTargetURL is the DoH endpoint that decrypts queries (ODOH_TARGET overrides)
+	odohTargetURL = envOrDefault("ODOH_TARGET", "https://odoh.cloudflare-dns.com/dns-query")
+	// odohProxyURL relays sealed queries to the target (ODOH_PROXY, required for ODoH)
+	odohProxyURL = os.Getenv("ODOH_PROXY")
+)
+
+// odohConfig is a target's HPKE configuration
+type odohConfig struct {
+	contents  []byte // Serialized ObliviousDoHConfigContents
+	publicKey []byte
+	keyID     []byte
+	fetchedAt time.Time
+}
+
+var (
+	odohConfigs    = map[string]*odohConfig{} // Target host -> config
+	odohConfigsMux sync.Mutex
+)
+
+// odohServer builds the ODoH server from the configured target and proxy
+func odohServer(targetURL, proxyURL string) (DNSServer, error) {
+	target, err := url.Parse(targetURL)
+	if err != nil || target.Scheme != "https" || target.Host == "" {
+		return DNSServer{}, fmt.Errorf("ODoH target must be an https URL")
+	}
+	proxy, err := url.Parse(strings.TrimSuffix(proxyURL, "{?targethost,targetpath}"))
+	if err != nil || proxy.Scheme != "https" || proxy.Host == "" {
+		return DNSServer{}, fmt.Errorf("ODoH proxy must be an https URL")
+	}
+	if strings.EqualFold(proxy.Hostname(), target.Hostname()) {
+		return DNSServer{}, fmt.Errorf("ODoH proxy and target must be different hosts")
+	}
+
+	return DNSServer{
+		Name:       "ODoH " + target.Hostname() + " via " + proxy.Hostname(),
+		Address:    target.Hostname(),
+		ServerName: target.Hostname(),
+		URL:        target.String(),
+		ProxyURL:   proxy.String(),
+		Port:       443,
+		Protocols:  []string{"ODoH"},
+		Healthy:    true,
+	}, nil
+}
+
+// lengthPrefixed appends a uint16 length and the data
+func lengthPrefixed(dst, data []byte) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(data)))
+	return append(dst, data...)
+}
+
+// readLengthPrefixed splits a uint16 length-prefixed field off the front of data
+func readLengthPrefixed(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+		return nil, nil, fmt.Errorf("truncated field")
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	return data[2 : 2+length], data[2+length:], nil
+}
+
+// hkdfExpand reads length bytes of HKDF-Expand output
+func hkdfExpand(prk []byte, info string, length int) []byte {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(info)), out); err != nil {
+		panic(err) // Only possible for lengths above 255*Nh, which are never requested
+	}
+	return out
+}
+
+// parseODoHConfigs picks the first config with version 1 and a supported HPKE suite
+// Layout: uint16 total | { uint16 version, uint16 length, contents }*
+// Contents: uint16 kem_id, uint16 kdf_id, uint16 aead_id, uint16-prefixed public key
+func parseODoHConfigs(raw []byte) (*odohConfig, error) {
+	configs, _, err := readLengthPrefixed(raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed ODoH configs: %w", err)
+	}
+
+	for len(configs) >= 4 {
+		version := binary.BigEndian.Uint16(configs)
+		contents, rest, err := readLengthPrefixed(configs[2:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed ODoH config: %w", err)
+		}
+		configs = rest
+
+		if version != odohVersion || len(contents) < 8 {
+			continue
+		}
+		publicKey, trailing, err := readLengthPrefixed(contents[6:])
+		if err != nil || len(trailing) != 0 {
+			continue
+		}
+		if binary.BigEndian.Uint16(contents[0:2]) != hpkeKEMX25519SHA256 ||
+			binary.BigEndian.Uint16(contents[2:4]) != hpkeKDFSHA256 ||
+			binary.BigEndian.Uint16(contents[4:6]) != hpkeAEADAES128GCM {
+			continue
+		}
+
+		// key_id = Expand(Extract("", config_contents), "odoh key id", Nh)
+		keyID := hkdfExpand(hkdf.Extract(sha256.New, contents, nil), "odoh key id", hpkeHashLength)
+		return &odohConfig{
+			contents:  append([]byte{}, contents...),
+			publicKey: append([]byte{}, publicKey...),
+			keyID:     keyID,
+			fetchedAt: time.Now(),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("target offers no supported ODoH config")
+}
+
+// odohTargetConfig returns the cached config of a target, fetching it when stale
+// The config request carries no query, so it goes to the target directly
+func odohTargetConfig(ctx context.Context, target *url.URL, refresh bool) (*odohConfig, error) {
+	odohConfigsMux.Lock()
+	config, ok := odohConfigs[target.Host]
+	odohConfigsMux.Unlock()
+	if ok && !refresh && time.Since(config.fetchedAt) < odohConfigRefresh {
+		return config, nil
+	}
+
+	configURL := url.URL{Scheme: "https", Host: target.Host, Path: odohConfigPath}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dohHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ODoH config request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ODoH config request returned status %d", resp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxODoHConfigSize))
+	if err != nil {
+		return nil, err
+	}
+
+	config, err = parseODoHConfigs(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	odohConfigsMux.Lock()
+	odohConfigs[target.Host] = config
+	odohConfigsMux.Unlock()
+	return config, nil
+}
+
+// odohPlaintext wraps a DNS message with zero padding to the next block
+// ObliviousDoHMessagePlaintext: uint16-prefixed dns_message, uint16-prefixed padding
+func odohPlaintext(query []byte) []byte {
+	padding := (odohPaddingBlock - (len(query)+4)%odohPaddingBlock) % odohPaddingBlock
+	plaintext := lengthPrefixed(nil, query)
+	return lengthPrefixed(plaintext, make([]byte, padding))
+}
+
+// odohMessage encodes an ObliviousDoHMessage: uint8 type, uint16-prefixed key_id, uint16-prefixed ciphertext
+func odohMessage(messageType byte, keyID, encrypted []byte) []byte {
+	message := []byte{messageType}
+	message = lengthPrefixed(message, keyID)
+	return lengthPrefixed(message, encrypted)
+}
+
+// odohAAD is the associated data: type || uint16-prefixed key_id (or response nonce)
+func odohAAD(messageType byte, keyID []byte) []byte {
+	return lengthPrefixed([]byte{messageType}, keyID)
+}
+
+// openODoHResponse decrypts a target's answer with keys bound to our query
+// secret = Export("odoh response", Nk); salt = Q_plain || uint16-prefixed nonce
+func openODoHResponse(sender *hpkeSender, queryPlaintext, body []byte) ([]byte, error) {
+	if len(body) < 1 || body[0] != odohMessageResponse {
+		return nil, fmt.Errorf("not an ODoH response")
+	}
+	responseNonce, rest, err := readLengthPrefixed(body[1:])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ODoH response: %w", err)
+	}
+	encrypted, trailing, err := readLengthPrefixed(rest)
+	if err != nil || len(trailing) != 0 {
+		return nil, fmt.Errorf("malformed ODoH response")
+	}
+
+	secret := sender.Export([]byte("odoh response"), hpkeKeyLength)
+	salt := lengthPrefixed(append([]byte{}, queryPlaintext...), responseNonce)
+	prk := hkdf.Extract(sha256.New, secret, salt)
+
+	block, err := aes.NewCipher(hkdfExpand(prk, "odoh key", hpkeKeyLength))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, hkdfExpand(prk, "odoh nonce", hpkeNonceLength), encrypted, odohAAD(odohMessageResponse, responseNonce))
+	if err != nil {
+		return nil, fmt.Errorf("ODoH response failed authentication")
+	}
+
+	answer, _, err := readLengthPrefixed(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("malformed ODoH response plaintext: %w", err)
+	}
+	return answer, nil
+}
+
+// odohProxyRequestURL addresses the target through the proxy (targethost/targetpath parameters)
+func odohProxyRequestURL(proxyURL string, target *url.URL) (string, error) {
+	proxy, err := url.Parse(proxyURL)
+	if err != nil {
+		return "", err
+	}
+	params := proxy.Query()
+	params.Set("targethost", target.Host)
+	params.Set("targetpath", target.EscapedPath())
+	proxy.RawQuery = params.Encode()
+	return proxy.String(), nil
+}
+
+// exchangeODoH seals a packed query for the target, relays it through the proxy
+// and returns the decrypted answer. A 401 means the target rotated its key; the
+// config is re-fetched and the query sent once more
+func exchangeODoH(ctx context.Context, server DNSServer, query []byte) ([]byte, error) {
+	if server.ProxyURL == "" {
+		return nil, fmt.Errorf("ODoH needs a proxy; a direct query would reveal our address to the target")
+	}
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ODoH target: %w", err)
+	}
+	requestURL, err := odohProxyRequestURL(server.ProxyURL, target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ODoH proxy: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		config, err := odohTargetConfig(ctx, target, attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		plaintext := odohPlaintext(query)
+		enc, sender, err := hpkeSetupBaseSender(config.publicKey, []byte("odoh query"))
+		if err != nil {
+			return nil, err
+		}
+		sealed := sender.Seal(odohAAD(odohMessageQuery, config.keyID), plaintext)
+		body := odohMessage(odohMessageQuery, config.keyID, append(enc, sealed...))
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", odohContentType)
+		req.Header.Set("Accept", odohContentType)
+
+		resp, err := dohHTTPClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("ODoH proxy request failed: %w", err)
+		}
+		raw, readErr := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponse+1))
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			continue // Stale key: refresh the config and retry
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("ODoH proxy returned status %d", resp.StatusCode)
+		}
+		if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediaType != odohContentType {
+			return nil, fmt.Errorf("ODoH proxy returned content type %q", resp.Header.Get("Content-Type"))
+		}
+		if readErr != nil || len(raw) > maxDoHResponse {
+			return nil, fmt.Errorf("ODoH response read failed")
+		}
+
+		return openODoHResponse(sender, plaintext, raw)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// odohTarget is an in-process Oblivious DoH target with a rotatable HPKE key
+type odohTarget struct {
+	t       *testing.T
+	records stubRecords
+
+	mu            sync.Mutex
+	key           *ecdh.PrivateKey
+	config        *odohConfig
+	rejectAll     bool // Answer every query with 401, as after repeated rotations
+	configFetches int
+	queries       int
+	unauthorized  int
+}
+
+// rotate replaces the target key; queries sealed to the old key get 401
+func (target *odohTarget) rotate(t *testing.T) {
+	t.Helper()
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseODoHConfigs(odohConfigsFor(key.PublicKey().Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target.mu.Lock()
+	target.key, target.config = key, config
+	target.mu.Unlock()
+}
+
+// odohConfigsFor serializes ObliviousDoHConfigs with one X25519 config
+func odohConfigsFor(publicKey []byte) []byte {
+	contents := binary.BigEndian.AppendUint16(nil, hpkeKEMX25519SHA256)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeKDFSHA256)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeAEADAES128GCM)
+	contents = lengthPrefixed(contents, publicKey)
+
+	config := binary.BigEndian.AppendUint16(nil, odohVersion)
+	return lengthPrefixed(nil, lengthPrefixed(config, contents))
+}
+
+// ServeHTTP serves the config and answers sealed queries
+func (target *odohTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	if r.URL.Path == odohConfigPath {
+		target.configFetches++
+		w.Write(odohConfigsFor(target.key.PublicKey().Bytes()))
+		return
+	}
+
+	target.queries++
+	body, _ := io.ReadAll(r.Body)
+	if r.URL.Path != defaultDoHPath || r.Header.Get("Content-Type") != odohContentType || len(body) < 1 || body[0] != odohMessageQuery {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	keyID, rest, err := readLengthPrefixed(body[1:])
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !bytes.Equal(keyID, target.config.keyID) || target.rejectAll {
+		target.unauthorized++
+		http.Error(w, "unknown key", http.StatusUnauthorized)
+		return
+	}
+	encrypted, _, err := readLengthPrefixed(rest)
+	if err != nil || len(encrypted) < 32 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Receiver side of HPKE: decapsulate enc and open the query
+	enc, sealed := encrypted[:32], encrypted[32:]
+	ephemeral, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	dh, _ := target.key.ECDH(ephemeral)
+	receiver, _ := hpkeKeySchedule(hpkeSharedSecret(dh, enc, target.key.PublicKey().Bytes()), []byte("odoh query"))
+	queryPlaintext, err := receiver.aead.Open(nil, receiver.nextNonce(), sealed, odohAAD(odohMessageQuery, keyID))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	query, _, err := readLengthPrefixed(queryPlaintext)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Response keys bound to the query (RFC 9230 §6.4)
+	responseNonce := make([]byte, hpkeKeyLength)
+	rand.Read(responseNonce)
+	secret := receiver.Export([]byte("odoh response"), hpkeKeyLength)
+	prk := hkdf.Extract(sha256.New, secret, lengthPrefixed(append([]byte{}, queryPlaintext...), responseNonce))
+	block, _ := aes.NewCipher(hkdfExpand(prk, "odoh key", hpkeKeyLength))
+	aead, _ := cipher.NewGCM(block)
+	responsePlaintext := lengthPrefixed(lengthPrefixed(nil, target.records.answer(target.t, query)), nil)
+	sealedResponse := aead.Seal(nil, hkdfExpand(prk, "odoh nonce", hpkeNonceLength), responsePlaintext, odohAAD(odohMessageResponse, responseNonce))
+
+	w.Header().Set("Content-Type", odohContentType)
+	w.Write(odohMessage(odohMessageResponse, responseNonce, sealedResponse))
+}
+
+// odohProxy relays sealed queries to the target named by targethost and targetpath
+type odohProxy struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	relayed []string // Target URLs requested through the proxy
+}
+
+// ServeHTTP forwards the body and relays the target's status, content type and body
+func (proxy *odohProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	targetURL := url.URL{Scheme: "https", Host: r.URL.Query().Get("targethost"), Path: r.URL.Query().Get("targetpath")}
+	proxy.mu.Lock()
+	proxy.relayed = append(proxy.relayed, targetURL.String())
+	proxy.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, targetURL.String(), r.Body)
+	if err != nil {
+		http.Error(w, "bad target", http.StatusBadRequest)
+		return
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	resp, err := proxy.client.Do(req)
+	if err != nil {
+		http.Error(w, "target unreachable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// startODoHStubs starts a target and a proxy over TLS and installs the ODoH server using them
+func startODoHStubs(t *testing.T, records stubRecords) (DNSServer, *odohTarget, *odohProxy) {
+	t.Helper()
+
+	cert, roots := newTestCertificate(t)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	target := &odohTarget{t: t, records: records}
+	target.rotate(t)
+	targetServer := httptest.NewUnstartedServer(target)
+	targetServer.TLS = tlsConfig
+	targetServer.StartTLS()
+	t.Cleanup(targetServer.Close)
+
+	proxy := &odohProxy{client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}}
+	proxyServer := httptest.NewUnstartedServer(proxy)
+	proxyServer.TLS = tlsConfig
+	proxyServer.StartTLS()
+	t.Cleanup(proxyServer.Close)
+
+	targetURL, _ := url.Parse(targetServer.URL + defaultDoHPath)
+	server := DNSServer{Name: "odoh", Address: targetURL.Hostname(), URL: targetURL.String(), ProxyURL: proxyServer.URL + "/proxy", Port: 443, Protocols: []string{"ODoH"}, Healthy: true}
+	installTestResolver(t, "ODoH", roots, server)
+	t.Cleanup(func() {
+		odohConfigsMux.Lock()
+		delete(odohConfigs, targetURL.Host)
+		odohConfigsMux.Unlock()
+	})
+	return server, target, proxy
+}
+
+func TestExchangeODoH(t *testing.T) {
+	server, target, proxy := startODoHStubs(t, testZone)
+	startBlocklistStub(t)
+
+	resolution, err := resolveQuery(context.Background(), mustQuery(t, "www.example", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution.Protocol != "ODoH" || len(resolution.Response.Answers) != 1 {
+		t.Errorf("protocol %q with %d answers, want ODoH with 1", resolution.Protocol, len(resolution.Response.Answers))
+	}
+
+	// Key rotation: the first query gets 401, the config is re-fetched and the query resent
+	target.rotate(t)
+	resolution, err = resolveQuery(context.Background(), mustQuery(t, "missing.example", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatalf("after key rotation: %v", err)
+	}
+	if resolution.Response.Header.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("missing.example rcode %v, want NXDOMAIN", resolution.Response.Header.RCode)
+	}
+
+	target.mu.Lock()
+	if target.configFetches != 2 || target.queries != 3 || target.unauthorized != 1 {
+		t.Errorf("target saw %d config fetches, %d queries, %d rejected; want 2, 3, 1", target.configFetches, target.queries, target.unauthorized)
+	}
+	target.rejectAll = true
+	target.mu.Unlock()
+
+	// Queries travel only through the proxy
+	proxy.mu.Lock()
+	if len(proxy.relayed) != 3 || proxy.relayed[0] != server.URL {
+		t.Errorf("proxy relayed %v, want 3 requests to %s", proxy.relayed, server.URL)
+	}
+	proxy.mu.Unlock()
+
+	// A second 401 is not retried again
+	query := mustQuery(t, "www.example", dnsmessage.TypeAAAA)
+	packed, _ := query.Pack()
+	if _, err := exchangeODoH(context.Background(), server, packed); err == nil {
+		t.Error("exchange succeeded although the target rejected every key")
+	}
+	target.mu.Lock()
+	if target.unauthorized != 3 {
+		t.Errorf("target rejected %d queries, want 3", target.unauthorized)
+	}
+	target.mu.Unlock()
+
+	// Without a proxy nothing is sent to the target
+	server.ProxyURL = ""
+	if _, err := exchangeODoH(context.Background(), server, packed); err == nil {
+		t.Error("exchange succeeded without a proxy")
+	}
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if target.queries != 5 {
+		t.Errorf("target saw %d queries, want 5", target.queries)
+	}
+}
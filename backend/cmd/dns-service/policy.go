@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// RESPONSE POLICIES
+// Checks applied to every upstream answer before it is cached or returned
+// ============================================================================
+
+// PolicyDecision describes what the response policies did to an answer
+// Privacy: Returned to the caller only, never logged (explanations may name a CNAME target)
+type PolicyDecision struct {
+	Blocked     bool   // Whole response must be replaced by a blocked answer
+	Modified    bool   // Some answer records were removed or rewritten
+	Policy      string // Policy that produced the decision
+	Category    string // Blocklist category, when applicable
+	Explanation string // Human-readable reason shown to the user
+	Refuse      bool   // Answer REFUSED instead of the configured blocked response
+}
+
+// responsePolicy inspects (and may edit) an upstream answer
+// Returning a decision with Blocked set stops the pipeline
+type responsePolicy struct {
+	name      string
+	blocklist bool // Driven by blocklist content, skipped while the name is paused
+	apply     func(ctx context.Context, question dnsmessage.Question, resp *dnsmessage.Message) (*PolicyDecision, error)
+}
+
+// responsePolicies run in order; cheap local checks should come before remote ones
+var responsePolicies = []responsePolicy{
+	{name: "rebinding", apply: applyRebindingPolicy},
+	{name: "cname_blocklist", blocklist: true, apply: applyCNAMEBlocklistPolicy},
+	{name: "ip_blocklist", blocklist: true, apply: applyIPBlocklistPolicy},
+}
+
+// applyResponsePolicies runs all response policies against an upstream answer
+// While blocking is paused for the name only the local protections (rebinding) run
+// Policy errors fail open so a blocklist-service outage never breaks resolution
+func applyResponsePolicies(ctx context.Context, question dnsmessage.Question, resp *dnsmessage.Message, paused bool) PolicyDecision {
+	combined := PolicyDecision{}
+
+	for _, policy := range responsePolicies {
+		if paused && policy.blocklist {
+			continue
+		}
+		decision, err := policy.apply(ctx, question, resp)
+		if err != nil {
+			log.Printf("⚠️ Response policy %s skipped: %v", policy.name, err)
+			continue
+		}
+		if decision == nil {
+			continue
+		}
+		if decision.Blocked {
+			return *decision
+		}
+		if decision.Modified {
+			combined = *decision
+		}
+	}
+
+	return combined
+}
+
+// qtypeNames maps DNS message types to the record type names used by the blocklist-service
+var qtypeNames = map[dnsmessage.Type]string{
+	dnsmessage.TypeA:     "A",
+	dnsmessage.TypeAAAA:  "AAAA",
+	dnsmessage.TypeCNAME: "CNAME",
+	dnsmessage.TypeMX:    "MX",
+	dnsmessage.TypeTXT:   "TXT",
+	dnsmessage.TypeNS:    "NS",
+	dnsmessage.TypeSOA:   "SOA",
+	dnsmessage.TypePTR:   "PTR",
+	dnsmessage.TypeSRV:   "SRV",
+	dnsmessage.TypeHINFO: "HINFO",
+	dnsmessage.TypeALL:   "ANY",
+	dnsmessage.Type(35):  "NAPTR",
+	dnsmessage.Type(43):  "DS",
+	dnsmessage.Type(48):  "DNSKEY",
+	dnsmessage.Type(64):  "SVCB",
+	dnsmessage.Type(65):  "HTTPS",
+	dnsmessage.Type(257): "CAA",
+}
+
+// qtypeName returns the blocklist-service name of a query type, empty when it has none
+func qtypeName(qtype dnsmessage.Type) string {
+	return qtypeNames[qtype]
+}
+
+// ============================================================================
+// CNAME CLOAKING DETECTION
+// Checks every CNAME target in the resolution chain, not just the queried name
+// ============================================================================
+
+// cnameChain returns the CNAME targets of an answer in chain order, without duplicates
+func cnameChain(resp *dnsmessage.Message) []string {
+	var chain []string
+	seen := make(map[string]bool)
+	for _, answer := range resp.Answers {
+		body, ok := answer.Body.(*dnsmessage.CNAMEResource)
+		if !ok {
+			continue
+		}
+		target := strings.ToLower(strings.TrimSuffix(body.CNAME.String(), "."))
+		if target != "" && !seen[target] {
+			seen[target] = true
+			chain = append(chain, target)
+		}
+	}
+	return chain
+}
+
+// applyCNAMEBlocklistPolicy blocks the whole response if any CNAME target is blocklisted
+// This catches trackers hidden behind first-party subdomains (CNAME cloaking)
+func applyCNAMEBlocklistPolicy(ctx context.Context, question dnsmessage.Question, resp *dnsmessage.Message) (*PolicyDecision, error) {
+	chain := cnameChain(resp)
+	if len(chain) == 0 {
+		return nil, nil
+	}
+
+	results, err := checkBlockedCNAMETargets(ctx, chain, qtypeName(question.Type))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		if result.Blocked {
+			return &PolicyDecision{
+				Blocked:  true,
+				Policy:   "cname_blocklist",
+				Category: result.Category,
+				Explanation: fmt.Sprintf("blocked via CNAME: hop %d of %d in the resolution chain (%s) matched the blocklist (%s)",
+					i+1, len(chain), chain[i], result.Category),
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ============================================================================
+// RESPONSE IP FILTERING
+// Drops or rewrites A/AAAA answers that point into blocked CIDR ranges
+// ============================================================================
+
+// ipFilterMode controls response-IP filtering: "drop", "rewrite" or "off"
+var ipFilterMode = func() string {
+	switch mode := os.Getenv("RESPONSE_IP_FILTER"); mode {
+	case "drop", "rewrite", "off":
+		return mode
+	default:
+		return "drop"
+	}
+}()
+
+// answerAddr extracts the address of an A or AAAA answer record
+func answerAddr(resource dnsmessage.Resource) (netip.Addr, bool) {
+	switch body := resource.Body.(type) {
+	case *dnsmessage.AResource:
+		return netip.AddrFrom4(body.A), true
+	case *dnsmessage.AAAAResource:
+		return netip.AddrFrom16(body.AAAA), true
+	default:
+		return netip.Addr{}, false
+	}
+}
+
+// applyIPBlocklistPolicy checks every answer address against the CIDR blocklists
+// "drop" removes matching records and blocks the response when none remain;
+// "rewrite" replaces matching records with the unspecified address
+func applyIPBlocklistPolicy(ctx context.Context, question dnsmessage.Question, resp *dnsmessage.Message) (*PolicyDecision, error) {
+	if ipFilterMode == "off" {
+		return nil, nil
+	}
+
+	var addrs []netip.Addr
+	var indexes []int
+	for i, answer := range resp.Answers {
+		if addr, ok := answerAddr(answer); ok {
+			addrs = append(addrs, addr)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(question.Name.String(), "."))
+	results, err := checkBlockedIPs(ctx, domain, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := make(map[int]bool)
+	category := ""
+	for i, result := range results {
+		if result.Blocked {
+			blocked[indexes[i]] = true
+			if category == "" {
+				category = result.Category
+			}
+		}
+	}
+	if len(blocked) == 0 {
+		return nil, nil
+	}
+
+	decision := &PolicyDecision{
+		Modified: true,
+		Policy:   "ip_blocklist",
+		Category: category,
+		Explanation: fmt.Sprintf("%d of %d answer addresses are in blocked IP ranges (%s)",
+			len(blocked), len(addrs), category),
+	}
+
+	if ipFilterMode == "rewrite" {
+		for index := range blocked {
+			switch body := resp.Answers[index].Body.(type) {
+			case *dnsmessage.AResource:
+				body.A = [4]byte{}
+			case *dnsmessage.AAAAResource:
+				body.AAAA = [16]byte{}
+			}
+		}
+		return decision, nil
+	}
+
+	kept := resp.Answers[:0]
+	for i, answer := range resp.Answers {
+		if !blocked[i] {
+			kept = append(kept, answer)
+		}
+	}
+	resp.Answers = kept
+
+	// Nothing usable left: block the whole response
+	if len(blocked) == len(addrs) {
+		decision.Blocked = true
+		decision.Explanation = fmt.Sprintf("all answer addresses are in blocked IP ranges (%s)", category)
+	}
+
+	return decision, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// cnameChainAnswer builds a response whose answer section is a CNAME chain of n hops
+func cnameChainAnswer(t *testing.T, n int) *dnsmessage.Message {
+	t.Helper()
+
+	resp := &dnsmessage.Message{Header: dnsmessage.Header{Response: true}}
+	owner := dnsmessage.MustNewName("start.example.")
+	for i := 0; i < n; i++ {
+		target := dnsmessage.MustNewName(fmt.Sprintf("hop%d.example.", i))
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: owner, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.CNAMEResource{CNAME: target},
+		})
+		owner = target
+	}
+	return resp
+}
+
+func TestCNAMEBlocklistPolicyLongChain(t *testing.T) {
+	stub := startBlocklistStub(t)
+	stub.blocked["hop180.example"] = true
+	question := dnsmessage.Question{Name: dnsmessage.MustNewName("start.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+
+	decision, err := applyCNAMEBlocklistPolicy(context.Background(), question, cnameChainAnswer(t, 250))
+	if err != nil {
+		t.Fatalf("applyCNAMEBlocklistPolicy: %v", err)
+	}
+	if decision == nil || !decision.Blocked {
+		t.Fatalf("decision = %+v, want blocked by hop 181", decision)
+	}
+	if fmt.Sprint(stub.batches) != "[100 100 50]" {
+		t.Errorf("batch sizes = %v, want [100 100 50]", stub.batches)
+	}
+}
+
+func TestCNAMEBlocklistPolicyCleanChain(t *testing.T) {
+	startBlocklistStub(t)
+	question := dnsmessage.Question{Name: dnsmessage.MustNewName("start.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+
+	decision, err := applyCNAMEBlocklistPolicy(context.Background(), question, cnameChainAnswer(t, 3))
+	if err != nil || decision != nil {
+		t.Errorf("applyCNAMEBlocklistPolicy = %+v, %v; want nil, nil", decision, err)
+	}
+}
+
+func TestIPBlocklistPolicyLargeAnswer(t *testing.T) {
+	stub := startBlocklistStub(t)
+	stub.blockedIPs["10.0.0.200"] = true
+	question := dnsmessage.Question{Name: dnsmessage.MustNewName("many.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+
+	resp := &dnsmessage.Message{Header: dnsmessage.Header{Response: true}}
+	for i := 0; i < 250; i++ {
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, byte(i)}},
+		})
+	}
+
+	decision, err := applyIPBlocklistPolicy(context.Background(), question, resp)
+	if err != nil {
+		t.Fatalf("applyIPBlocklistPolicy: %v", err)
+	}
+	if decision == nil || !decision.Modified {
+		t.Fatalf("decision = %+v, want 10.0.0.200 filtered", decision)
+	}
+	if fmt.Sprint(stub.ipBatches) != "[100 100 50]" {
+		t.Errorf("batch sizes = %v, want [100 100 50]", stub.ipBatches)
+	}
+}
+
+func TestQTypeName(t *testing.T) {
+	// Every type the blocklist-service accepts in qtype must have a name here
+	for _, name := range []string{"A", "AAAA", "ANY", "CAA", "CNAME", "DNSKEY", "DS", "HINFO", "HTTPS", "MX", "NAPTR", "NS", "PTR", "SOA", "SRV", "SVCB", "TXT"} {
+		found := false
+		for _, known := range qtypeNames {
+			found = found || known == name
+		}
+		if !found {
+			t.Errorf("qtypeNames has no entry for %s", name)
+		}
+	}
+	if name := qtypeName(dnsmessage.Type(257)); name != "CAA" {
+		t.Errorf("qtypeName(257) = %q, want CAA", name)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// DNS REBINDING PROTECTION
+// Strips or refuses public answers that point at private, loopback or link-local addresses
+// ============================================================================
+
+// rebindingMode controls rebinding protection: "strip", "refuse" or "off"
+var rebindingMode = func() string {
+	switch mode := os.Getenv("REBINDING_PROTECTION"); mode {
+	case "strip", "refuse", "off":
+		return mode
+	default:
+		return "off"
+	}
+}()
+
+// defaultRebindingAllowlist holds zones that legitimately resolve to local addresses
+var defaultRebindingAllowlist = []string{
+	"local",
+	"localhost",
+	"lan",
+	"internal",
+	"home.arpa",
+	"in-addr.arpa",
+	"ip6.arpa",
+}
+
+// rebindingAllowlist is the default list plus REBINDING_ALLOWLIST (comma-separated, "*." prefix optional)
+var rebindingAllowlist = func() []string {
+	allowlist := append([]string{}, defaultRebindingAllowlist...)
+	for _, entry := range strings.Split(os.Getenv("REBINDING_ALLOWLIST"), ",") {
+		entry = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(entry), "*."), "."))
+		if entry != "" {
+			allowlist = append(allowlist, entry)
+		}
+	}
+	return allowlist
+}()
+
+// thisNetwork is 0.0.0.0/8, reachable as the local host on many systems
+var thisNetwork = netip.MustParsePrefix("0.0.0.0/8")
+
+// isRebindingAllowed reports whether a name is in an allowlisted zone
+func isRebindingAllowed(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, zone := range rebindingAllowlist {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalAddress reports RFC1918, ULA, loopback, link-local and unspecified addresses
+func isLocalAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsPrivate() ||
+		addr.IsLoopback() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsUnspecified() ||
+		thisNetwork.Contains(addr)
+}
+
+// applyRebindingPolicy removes local addresses from answers for non-allowlisted names
+// "strip" drops the offending records (blocking when none remain); "refuse" refuses the whole answer
+func applyRebindingPolicy(ctx context.Context, question dnsmessage.Question, resp *dnsmessage.Message) (*PolicyDecision, error) {
+	if rebindingMode == "off" || isRebindingAllowed(question.Name.String()) {
+		return nil, nil
+	}
+
+	local := make(map[int]bool)
+	addresses := 0
+	for i, answer := range resp.Answers {
+		addr, ok := answerAddr(answer)
+		if !ok {
+			continue
+		}
+		addresses++
+		if isLocalAddress(addr) {
+			local[i] = true
+		}
+	}
+	if len(local) == 0 {
+		return nil, nil
+	}
+
+	decision := &PolicyDecision{
+		Modified: true,
+		Policy:   "rebinding",
+		Category: "rebinding",
+		Explanation: fmt.Sprintf("%d of %d answer addresses point to a private or local network",
+			len(local), addresses),
+	}
+
+	if rebindingMode == "refuse" {
+		decision.Blocked = true
+		decision.Refuse = true
+		return decision, nil
+	}
+
+	kept := resp.Answers[:0]
+	for i, answer := range resp.Answers {
+		if !local[i] {
+			kept = append(kept, answer)
+		}
+	}
+	resp.Answers = kept
+
+	if len(local) == addresses {
+		decision.Blocked = true
+		decision.Explanation = "all answer addresses point to a private or local network"
+	}
+
+	return decision, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// addressAnswer builds a response for name with one A or AAAA record per address
+func addressAnswer(t *testing.T, name string, addrs ...string) *dnsmessage.Message {
+	t.Helper()
+
+	resp := &dnsmessage.Message{Header: dnsmessage.Header{Response: true}}
+	owner := dnsmessage.MustNewName(name)
+	for _, raw := range addrs {
+		addr := netip.MustParseAddr(raw)
+		header := dnsmessage.ResourceHeader{Name: owner, Class: dnsmessage.ClassINET, TTL: 60}
+		if addr.Is4() {
+			header.Type = dnsmessage.TypeA
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: addr.As4()}})
+		} else {
+			header.Type = dnsmessage.TypeAAAA
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}})
+		}
+	}
+	return resp
+}
+
+// answerAddrs lists the addresses left in a response
+func answerAddrs(resp *dnsmessage.Message) []string {
+	var addrs []string
+	for _, answer := range resp.Answers {
+		if addr, ok := answerAddr(answer); ok {
+			addrs = append(addrs, addr.String())
+		}
+	}
+	return addrs
+}
+
+func TestApplyRebindingPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		qname     string
+		addrs     []string
+		decided   bool
+		blocked   bool
+		refuse    bool
+		remaining int
+	}{
+		{"off ignores private answers", "off", "evil.example.", []string{"192.168.1.10"}, false, false, false, 1},
+		{"public answer untouched", "strip", "good.example.", []string{"93.184.216.34", "2606:2800:220:1::1"}, false, false, false, 2},
+		{"strip keeps public records", "strip", "mixed.example.", []string{"93.184.216.34", "10.0.0.1", "fd00::1"}, true, false, false, 1},
+		{"strip blocks when all local", "strip", "evil.example.", []string{"127.0.0.1", "169.254.1.1", "0.0.0.0"}, true, true, false, 0},
+		{"strip catches mapped private IPv6", "strip", "evil.example.", []string{"::ffff:192.168.0.1"}, true, true, false, 0},
+		{"refuse refuses whole answer", "refuse", "mixed.example.", []string{"93.184.216.34", "172.16.0.1"}, true, true, true, 2},
+		{"default allowlist zone", "refuse", "printer.lan.", []string{"192.168.1.20"}, false, false, false, 1},
+		{"default allowlist apex", "strip", "home.arpa.", []string{"10.0.0.1"}, false, false, false, 1},
+		{"configured allowlist subdomain", "strip", "nas.corp.example.", []string{"10.1.2.3"}, false, false, false, 1},
+		{"allowlist does not match lookalike", "strip", "notcorp.example.", []string{"10.1.2.3"}, true, true, false, 0},
+	}
+
+	previousMode, previousAllowlist := rebindingMode, rebindingAllowlist
+	rebindingAllowlist = append(append([]string{}, defaultRebindingAllowlist...), "corp.example")
+	t.Cleanup(func() { rebindingMode, rebindingAllowlist = previousMode, previousAllowlist })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rebindingMode = test.mode
+			question := dnsmessage.Question{Name: dnsmessage.MustNewName(test.qname), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+			resp := addressAnswer(t, test.qname, test.addrs...)
+
+			decision, err := applyRebindingPolicy(context.Background(), question, resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (decision != nil) != test.decided {
+				t.Fatalf("decision = %+v, want decided %v", decision, test.decided)
+			}
+			if decision != nil && (decision.Blocked != test.blocked || decision.Refuse != test.refuse) {
+				t.Errorf("blocked, refuse = %v, %v; want %v, %v", decision.Blocked, decision.Refuse, test.blocked, test.refuse)
+			}
+			if remaining := answerAddrs(resp); len(remaining) != test.remaining {
+				t.Errorf("remaining answers = %v, want %d", remaining, test.remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// RESOLUTION PIPELINE
+// local records -> blocklist -> safe search -> encrypted upstream -> response policies
+// Privacy: Names exist in memory for the duration of a query only, never logged
+// ============================================================================
+
+const maxBatchResolve = 100
+
+var (
+	// errNoUpstream is returned when no healthy server supports the active protocol
+	errNoUpstream = errors.New("no healthy upstream server")
+	// errResolverNotReady is returned before initializeDNSResolver has run
+	errResolverNotReady = errors.New("resolver not initialized")
+)
+
+// Resolution is the outcome of one query through the pipeline
+type Resolution struct {
+	Response *dnsmessage.Message
+	Source   string         // "local", "blocked", "safe_search", "cache", "stale" or "upstream"
+	Server   string         // Upstream server that answered, if any
+	Protocol string         // Upstream protocol, if any
+	Decision PolicyDecision // Why the answer was blocked or modified
+}
+
+// qtypesByName is the reverse of qtypeNames for API requests
+var qtypesByName = func() map[string]dnsmessage.Type {
+	types := make(map[string]dnsmessage.Type, len(qtypeNames))
+	for qtype, name := range qtypeNames {
+		types[name] = qtype
+	}
+	return types
+}()
+
+// rcodeNames maps response codes to their presentation names
+var rcodeNames = map[dnsmessage.RCode]string{
+	dnsmessage.RCodeSuccess:        "NOERROR",
+	dnsmessage.RCodeFormatError:    "FORMERR",
+	dnsmessage.RCodeServerFailure:  "SERVFAIL",
+	dnsmessage.RCodeNameError:      "NXDOMAIN",
+	dnsmessage.RCodeNotImplemented: "NOTIMP",
+	dnsmessage.RCodeRefused:        "REFUSED",
+}
+
+// parseQType converts an API type name to a query type; empty means A
+func parseQType(name string) (dnsmessage.Type, bool) {
+	if name == "" {
+		return dnsmessage.TypeA, true
+	}
+	qtype, ok := qtypesByName[strings.ToUpper(name)]
+	return qtype, ok
+}
+
+// newQuery builds a recursive query with a random ID for a domain
+func newQuery(domain string, qtype dnsmessage.Type) (dnsmessage.Message, error) {
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	if domain == "" {
+		return dnsmessage.Message{}, fmt.Errorf("invalid domain")
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return dnsmessage.Message{}, fmt.Errorf("invalid domain")
+		}
+	}
+	name, err := dnsmessage.NewName(domain + ".")
+	if err != nil {
+		return dnsmessage.Message{}, fmt.Errorf("invalid domain")
+	}
+
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return dnsmessage.Message{}, err
+	}
+
+	return dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}, nil
+}
+
+// blockedResolution answers a query with the configured blocked response
+func blockedResolution(query dnsmessage.Message, decision PolicyDecision) *Resolution {
+	return &Resolution{
+		Response: buildBlockedResponse(query, decision.Category, decision.Refuse),
+		Source:   "blocked",
+		Decision: decision,
+	}
+}
+
+// resolveQuery runs a query through the pipeline
+func resolveQuery(ctx context.Context, query dnsmessage.Message) (*Resolution, error) {
+	question := query.Questions[0]
+	domain := strings.ToLower(strings.TrimSuffix(question.Name.String(), "."))
+
+	// 1. Local records answer authoritatively; a CNAME leaving the table continues below
+	local, cnameTarget, err := resolveLocalRecords(query)
+	if err != nil {
+		return nil, err
+	}
+	if local != nil && cnameTarget == "" {
+		return &Resolution{Response: local, Source: "local"}, nil
+	}
+	if local != nil {
+		targetQuery, err := newQuery(cnameTarget, question.Type)
+		if err != nil {
+			return nil, err
+		}
+		target, err := resolveQuery(ctx, targetQuery)
+		if err != nil {
+			return nil, err
+		}
+		if target.Source == "blocked" {
+			return blockedResolution(query, target.Decision), nil
+		}
+		local.Header.Authoritative = false
+		local.Header.RCode = target.Response.Header.RCode
+		local.Answers = append(local.Answers, target.Response.Answers...)
+		target.Response, target.Source = local, "local"
+		return target, nil
+	}
+
+	// 2. Blocklist verdict for the queried name (fails open when the service is down)
+	paused := false
+	results, err := checkBlockedDomains(ctx, []string{domain}, qtypeName(question.Type))
+	if err != nil {
+		log.Printf("⚠️ Blocklist check skipped: %v", err)
+	} else if verdict := results[0]; verdict.Blocked {
+		return blockedResolution(query, PolicyDecision{
+			Blocked:     true,
+			Policy:      "blocklist",
+			Category:    verdict.Category,
+			Refuse:      verdict.Refuse,
+			Explanation: fmt.Sprintf("domain matched the blocklist (%s)", verdict.Category),
+		}), nil
+	} else if verdict.Rewrite != "" && safeSearchMode == "on" {
+		// 3. Safe search: CNAME to the enforcing name plus its records
+		return resolveSafeSearch(ctx, query, verdict)
+	} else {
+		paused = verdict.Paused
+	}
+
+	// 4. Anonymous cache, then encrypted upstream; raw answers are cached so
+	// response policies below always run against the current blocklists. Stale
+	// answers are served at once while a background refresh runs
+	cache := resolverCache()
+	if cache == nil {
+		return nil, errResolverNotReady
+	}
+	resolution := &Resolution{Source: "cache"}
+	resp, lookup := cache.Get(query)
+	recordCacheResult(lookup != cacheMiss)
+	switch lookup {
+	case cacheStaleRefresh:
+		go refreshCached(question)
+		fallthrough
+	case cacheStale:
+		resolution.Source = "stale"
+	case cacheMiss:
+		var server upstreamServer
+		if resp, server, err = exchangeUpstream(ctx, query); err != nil {
+			return nil, err
+		}
+		cache.Put(query, resp)
+		resolution.Source, resolution.Server, resolution.Protocol = "upstream", server.Name, server.protocol
+	}
+
+	// 5. Response policies (rebinding, CNAME cloaking, IP blocklists); the
+	// blocklist-driven ones are skipped while the name is paused or allowed
+	decision := applyResponsePolicies(ctx, question, resp, paused)
+	if decision.Blocked {
+		blocked := blockedResolution(query, decision)
+		blocked.Server, blocked.Protocol = resolution.Server, resolution.Protocol
+		return blocked, nil
+	}
+
+	resolution.Response, resolution.Decision = resp, decision
+	return resolution, nil
+}
+
+// refreshCached re-resolves a cached question upstream and stores the answer
+// Used for stale entries and prefetches; on failure the cached answer is kept
+// and, once expired, served stale until cacheMaxStale
+func refreshCached(question dnsmessage.Question) error {
+	query, err := newQuery(strings.TrimSuffix(question.Name.String(), "."), question.Type)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeoutSeconds*time.Second)
+	defer cancel()
+
+	cache := resolverCache()
+	if cache == nil {
+		return errResolverNotReady
+	}
+	resp, _, err := exchangeUpstream(ctx, query)
+	if err != nil {
+		return err
+	}
+	cache.Put(query, resp)
+	return nil
+}
+
+// resolverCache returns the resolver's cache, or nil before initialization
+func resolverCache() *AnonymousCache {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if dnsResolver == nil {
+		return nil
+	}
+	return dnsResolver.cache
+}
+
+// resolveSafeSearch answers a search engine name with its safe-search rewrite
+// If the target cannot be resolved the CNAME alone is returned and the client follows it
+func resolveSafeSearch(ctx context.Context, query dnsmessage.Message, verdict DomainCheckResult) (*Resolution, error) {
+	resolution := &Resolution{
+		Source: "safe_search",
+		Decision: PolicyDecision{
+			Modified:    true,
+			Policy:      "safe_search",
+			Category:    verdict.Category,
+			Explanation: fmt.Sprintf("safe search enforced for %s", verdict.Category),
+		},
+	}
+
+	var targetResp *dnsmessage.Message
+	if targetQuery, err := newQuery(verdict.Rewrite, query.Questions[0].Type); err == nil {
+		resp, server, err := exchangeUpstream(ctx, targetQuery)
+		if err != nil {
+			log.Printf("⚠️ Safe-search target not resolved: %v", err)
+		} else {
+			targetResp = resp
+			resolution.Server, resolution.Protocol = server.Name, server.protocol
+		}
+	}
+
+	resp, err := buildSafeSearchAnswer(query, verdict.Rewrite, targetResp)
+	if err != nil {
+		return nil, err
+	}
+	resolution.Response = resp
+	return resolution, nil
+}
+
+// ============================================================================
+// UPSTREAM EXCHANGE
+// Sends queries to healthy servers over the active protocol with failover
+// ============================================================================
+
+// upstreamProtocols are the encrypted protocols resolution can use
+var upstreamProtocols = map[string]bool{"DoT": true, "DoH": true, "DoQ": true, "DNSCrypt": true, "ODoH": true}
+
+// initialProtocol returns DNS_PROTOCOL when it names a supported protocol, otherwise DoT
+func initialProtocol() string {
+	if protocol := os.Getenv("DNS_PROTOCOL"); upstreamProtocols[protocol] {
+		return protocol
+	}
+	return "DoT"
+}
+
+// upstreamServer is a server snapshot plus the protocol used to reach it
+type upstreamServer struct {
+	DNSServer
+	protocol string
+}
+
+// upstreamServers returns the servers supporting the active protocol that are
+// healthy, or unhealthy but due a trial query
+func upstreamServers() []upstreamServer {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if dnsResolver == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var servers []upstreamServer
+	for _, server := range dnsResolver.servers {
+		if !server.Healthy && now.Sub(server.LastCheck) < unhealthyRetryInterval {
+			continue
+		}
+		for _, protocol := range server.Protocols {
+			if protocol == dnsResolver.activeProtocol {
+				servers = append(servers, upstreamServer{DNSServer: server, protocol: protocol})
+				break
+			}
+		}
+	}
+	return servers
+}
+
+// exchangeUpstream resolves a query upstream through the configured selection strategy
+func exchangeUpstream(ctx context.Context, query dnsmessage.Message) (*dnsmessage.Message, upstreamServer, error) {
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, upstreamServer{}, err
+	}
+
+	servers := upstreamServers()
+	if len(servers) == 0 {
+		return nil, upstreamServer{}, errNoUpstream
+	}
+
+	return upstreamSelection.Exchange(ctx, servers, func(ctx context.Context, server upstreamServer) (*dnsmessage.Message, error) {
+		start := time.Now()
+		raw, err := exchangeServer(ctx, server, packed)
+
+		var resp *dnsmessage.Message
+		if err == nil {
+			resp, err = parseUpstreamResponse(query, raw)
+		}
+		// Exchanges cancelled by a race winner say nothing about the server
+		if err == nil || !errors.Is(ctx.Err(), context.Canceled) {
+			recordServerResult(server.DNSServer, time.Since(start), err)
+		}
+		return resp, err
+	})
+}
+
+// exchangeServer sends a packed query over the server's protocol
+func exchangeServer(ctx context.Context, server upstreamServer, packed []byte) ([]byte, error) {
+	switch server.protocol {
+	case "DoT":
+		return exchangeDoT(ctx, server.DNSServer, packed)
+	case "DoH":
+		return exchangeDoH(ctx, server.DNSServer, packed, dohMethod)
+	case "DoQ":
+		return exchangeDoQ(ctx, server.DNSServer, packed)
+	case "DNSCrypt":
+		return exchangeDNSCrypt(ctx, server.DNSServer, packed)
+	case "ODoH":
+		return exchangeODoH(ctx, server.DNSServer, packed)
+	default:
+		return nil, fmt.Errorf("protocol %s not supported", server.protocol)
+	}
+}
+
+// parseUpstreamResponse unpacks an answer and checks that it belongs to the query
+func parseUpstreamResponse(query dnsmessage.Message, raw []byte) (*dnsmessage.Message, error) {
+	var resp dnsmessage.Message
+	if err := resp.Unpack(raw); err != nil {
+		return nil, fmt.Errorf("malformed response: %w", err)
+	}
+
+	if !resp.Header.Response || resp.Header.ID != query.Header.ID || len(resp.Questions) != 1 {
+		return nil, fmt.Errorf("response does not match query")
+	}
+	asked, got := query.Questions[0], resp.Questions[0]
+	if got.Type != asked.Type || !strings.EqualFold(got.Name.String(), asked.Name.String()) {
+		return nil, fmt.Errorf("response does not match query")
+	}
+
+	return &resp, nil
+}
+
+const (
+	// upstreamFailurePenalty is the latency a failed exchange adds to the moving
+	// average, so "fastest" stops preferring a server that no longer answers
+	upstreamFailurePenalty = defaultTimeoutSeconds * time.Second
+	// maxConsecutiveFailures marks a server unhealthy until a trial query succeeds
+	maxConsecutiveFailures = 3
+	// unhealthyRetryInterval is how long an unhealthy server waits for its trial query
+	unhealthyRetryInterval = 30 * time.Second
+)
+
+// recordServerResult updates a server's latency, error counters and health
+// A failure counts as upstreamFailurePenalty in the moving average; after
+// maxConsecutiveFailures the server is unhealthy until an exchange succeeds
+func recordServerResult(server DNSServer, latency time.Duration, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if dnsResolver == nil {
+		return
+	}
+
+	for i := range dnsResolver.servers {
+		current := &dnsResolver.servers[i]
+		if current.Address != server.Address || current.Port != server.Port {
+			continue
+		}
+		current.LastCheck = time.Now()
+		if err != nil {
+			current.ErrorCount++
+			current.FailureStreak++
+			latency = upstreamFailurePenalty
+			if current.FailureStreak >= maxConsecutiveFailures {
+				current.Healthy = false
+			}
+		} else {
+			current.FailureStreak = 0
+			current.Healthy = true
+		}
+		if current.Latency == 0 {
+			current.Latency = latency
+		} else {
+			current.Latency = (current.Latency*7 + latency) / 8 // Moving average
+		}
+		return
+	}
+}
+
+// recordQueryStats adds a resolved query to the anonymous performance counters
+func recordQueryStats(elapsed time.Duration) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	queryCount++
+	totalResolutionTime += elapsed
+}
+
+// recordCacheResult counts a cache lookup
+func recordCacheResult(hit bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if hit {
+		cacheHits++
+	} else {
+		cacheMisses++
+	}
+}
+
+// ============================================================================
+// RESPONSE FORMATTING
+// Converts DNS messages to the JSON shape returned by the resolve API
+// ============================================================================
+
+// recordTypeName returns the presentation name of a record type
+func recordTypeName(rrtype dnsmessage.Type) string {
+	if name := qtypeName(rrtype); name != "" {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", rrtype)
+}
+
+// recordValue returns the presentation value of a record
+func recordValue(resource dnsmessage.Resource) string {
+	switch body := resource.Body.(type) {
+	case *dnsmessage.AResource, *dnsmessage.AAAAResource:
+		addr, _ := answerAddr(resource)
+		return addr.String()
+	case *dnsmessage.CNAMEResource:
+		return body.CNAME.String()
+	case *dnsmessage.NSResource:
+		return body.NS.String()
+	case *dnsmessage.PTRResource:
+		return body.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", body.Pref, body.MX.String())
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", body.Priority, body.Weight, body.Port, body.Target.String())
+	case *dnsmessage.TXTResource:
+		return strings.Join(body.TXT, "")
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", body.NS.String(), body.MBox.String(),
+			body.Serial, body.Refresh, body.Retry, body.Expire, body.MinTTL)
+	case *dnsmessage.UnknownResource:
+		return fmt.Sprintf("\\# %d %s", len(body.Data), hex.EncodeToString(body.Data)) // RFC 3597
+	default:
+		return ""
+	}
+}
+
+// resolutionJSON describes a resolution for the API
+func resolutionJSON(resolution *Resolution) gin.H {
+	resp := resolution.Response
+
+	records := make([]gin.H, 0, len(resp.Answers))
+	for _, answer := range resp.Answers {
+		records = append(records, gin.H{
+			"name":  answer.Header.Name.String(),
+			"type":  recordTypeName(answer.Header.Type),
+			"ttl":   answer.Header.TTL,
+			"value": recordValue(answer),
+		})
+	}
+
+	rcode, ok := rcodeNames[resp.Header.RCode]
+	if !ok {
+		rcode = fmt.Sprintf("RCODE%d", resp.Header.RCode)
+	}
+
+	return gin.H{
+		"rcode":       rcode,
+		"records":     records,
+		"source":      resolution.Source,
+		"server":      resolution.Server,
+		"protocol":    resolution.Protocol,
+		"blocked":     resolution.Source == "blocked",
+		"policy":      resolution.Decision.Policy,
+		"category":    resolution.Decision.Category,
+		"explanation": resolution.Decision.Explanation,
+	}
+}
+
+// ============================================================================
+// PROTOCOL STATUS
+// Per-protocol support, activity and server health for the /protocol endpoints
+// ============================================================================
+
+// protocolStatus describes one encrypted protocol and the servers offering it
+func protocolStatus(protocol string) gin.H {
+	mutex.RLock()
+	active := dnsResolver != nil && dnsResolver.activeProtocol == protocol
+	servers := []gin.H{}
+	if dnsResolver != nil {
+		for _, server := range dnsResolver.servers {
+			for _, supported := range server.Protocols {
+				if supported != protocol {
+					continue
+				}
+				servers = append(servers, gin.H{
+					"name":        server.Name,
+					"address":     server.Address,
+					"healthy":     server.Healthy,
+					"latency":     server.Latency.String(),
+					"error_count": server.ErrorCount,
+				})
+			}
+		}
+	}
+	mutex.RUnlock()
+
+	return gin.H{
+		"protocol":  protocol,
+		"supported": upstreamProtocols[protocol],
+		"active":    active,
+		"servers":   servers,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testZone is served by the upstream stubs of the end-to-end tests
+var testZone = stubRecords{
+	"www.example":         {"93.184.215.14", "2606:2800:21f:cb07::1"},
+	"cloaked.example":     {"cname:tracker.cdn.example"},
+	"tracker.cdn.example": {"198.51.100.7"},
+	"ads.example":         {"198.51.100.8"},
+}
+
+// installDoTResolver serves testZone over DoT, trusted through an injected root pool
+func installDoTResolver(t *testing.T) *blocklistStub {
+	t.Helper()
+
+	cert, roots := newTestCertificate(t)
+	port := startDoTStub(t, cert, testZone)
+	installTestResolver(t, "DoT", roots, DNSServer{Name: "stub", Address: "127.0.0.1", Port: port, Protocols: []string{"DoT"}, Healthy: true})
+
+	stub := startBlocklistStub(t)
+	stub.blocked["ads.example"] = true
+	stub.blocked["tracker.cdn.example"] = true
+	return stub
+}
+
+// postJSON sends a request body through a router holding the resolve handlers
+func postJSON(t *testing.T, path string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/resolve", handleDNSResolve)
+	router.POST("/batch", handleBatchResolve)
+
+	raw, _ := json.Marshal(body)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw)))
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s: %v (body %s)", path, err, recorder.Body.String())
+	}
+	return recorder.Code, response
+}
+
+func TestResolveQueryOverDoT(t *testing.T) {
+	installDoTResolver(t)
+
+	tests := []struct {
+		domain  string
+		qtype   dnsmessage.Type
+		source  string
+		policy  string
+		rcode   dnsmessage.RCode
+		answers int
+	}{
+		{"www.example", dnsmessage.TypeA, "upstream", "", dnsmessage.RCodeSuccess, 1},
+		{"www.example", dnsmessage.TypeA, "cache", "", dnsmessage.RCodeSuccess, 1},
+		{"www.example", dnsmessage.TypeAAAA, "upstream", "", dnsmessage.RCodeSuccess, 1},
+		{"missing.example", dnsmessage.TypeA, "upstream", "", dnsmessage.RCodeNameError, 0},
+		{"ads.example", dnsmessage.TypeA, "blocked", "blocklist", dnsmessage.RCodeSuccess, -1},
+		{"cloaked.example", dnsmessage.TypeA, "blocked", "cname_blocklist", dnsmessage.RCodeSuccess, -1},
+	}
+	for _, test := range tests {
+		resolution, err := resolveQuery(context.Background(), mustQuery(t, test.domain, test.qtype))
+		if err != nil {
+			t.Fatalf("resolveQuery(%s %v): %v", test.domain, test.qtype, err)
+		}
+		if resolution.Source != test.source || resolution.Decision.Policy != test.policy {
+			t.Errorf("%s %v: source %s policy %q, want %s policy %q",
+				test.domain, test.qtype, resolution.Source, resolution.Decision.Policy, test.source, test.policy)
+		}
+		if resolution.Source == "upstream" && (resolution.Server != "stub" || resolution.Protocol != "DoT") {
+			t.Errorf("%s: answered by %s over %s, want stub over DoT", test.domain, resolution.Server, resolution.Protocol)
+		}
+		if resolution.Response.Header.RCode != test.rcode {
+			t.Errorf("%s: rcode %v, want %v", test.domain, resolution.Response.Header.RCode, test.rcode)
+		}
+		if test.answers >= 0 && len(resolution.Response.Answers) != test.answers {
+			t.Errorf("%s %v: %d answers, want %d", test.domain, test.qtype, len(resolution.Response.Answers), test.answers)
+		}
+	}
+}
+
+func TestResolveQueryRejectsUntrustedUpstream(t *testing.T) {
+	cert, _ := newTestCertificate(t)
+	_, otherRoots := newTestCertificate(t)
+	port := startDoTStub(t, cert, testZone)
+	upstream := startDoHStub(t, cert, testZone)
+	startBlocklistStub(t)
+
+	for _, server := range []DNSServer{
+		{Name: "dot", Address: "127.0.0.1", Port: port, Protocols: []string{"DoT"}, Healthy: true},
+		{Name: "doh", Address: "127.0.0.1", Port: 443, URL: upstream, Protocols: []string{"DoH"}, Healthy: true},
+	} {
+		installTestResolver(t, server.Protocols[0], otherRoots, server)
+		if _, err := resolveQuery(context.Background(), mustQuery(t, "www.example", dnsmessage.TypeA)); err == nil {
+			t.Errorf("%s: resolved through a server whose certificate is not trusted", server.Name)
+		}
+	}
+}
+
+func TestHandleDNSResolve(t *testing.T) {
+	installDoTResolver(t)
+
+	code, response := postJSON(t, "/resolve", map[string]string{"domain": "www.example", "type": "AAAA"})
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", code, response)
+	}
+	records, _ := response["records"].([]interface{})
+	if len(records) != 1 || records[0].(map[string]interface{})["value"] != "2606:2800:21f:cb07::1" {
+		t.Errorf("records = %v, want the AAAA record", response["records"])
+	}
+	if response["type"] != "AAAA" || response["protocol"] != "DoT" || response["blocked"] != false {
+		t.Errorf("response = %v", response)
+	}
+
+	code, response = postJSON(t, "/resolve", map[string]string{"domain": "cloaked.example"})
+	if code != http.StatusOK || response["blocked"] != true || response["policy"] != "cname_blocklist" {
+		t.Errorf("cloaked.example: status %d, response %v", code, response)
+	}
+
+	for _, request := range []map[string]string{
+		{"domain": "www.example", "type": "BOGUS"},
+		{"domain": "bad..example"},
+	} {
+		if code, response := postJSON(t, "/resolve", request); code != http.StatusBadRequest {
+			t.Errorf("%v: status %d, response %v, want 400", request, code, response)
+		}
+	}
+
+	installTestResolver(t, "DoT", nil)
+	if code, response := postJSON(t, "/resolve", map[string]string{"domain": "www.example"}); code != http.StatusServiceUnavailable {
+		t.Errorf("no upstreams: status %d, response %v, want 503", code, response)
+	}
+}
+
+func TestHandleBatchResolve(t *testing.T) {
+	installDoTResolver(t)
+
+	code, response := postJSON(t, "/batch", map[string]interface{}{
+		"domains": []string{"www.example", "ads.example", "bad..example", "missing.example"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", code, response)
+	}
+
+	summary, _ := response["summary"].(map[string]interface{})
+	if summary["total_domains"] != 4.0 || summary["resolved"] != 3.0 || summary["failed"] != 1.0 {
+		t.Errorf("summary = %v, want 4 total, 3 resolved, 1 failed", summary)
+	}
+
+	results, _ := response["results"].([]interface{})
+	if len(results) != 4 {
+		t.Fatalf("results = %v", response["results"])
+	}
+	want := []struct {
+		key   string
+		value interface{}
+	}{
+		{"source", "upstream"},
+		{"policy", "blocklist"},
+		{"error", "invalid domain format"},
+		{"rcode", "NXDOMAIN"},
+	}
+	for i, result := range results {
+		result := result.(map[string]interface{})
+		if result["index"] != float64(i) || result[want[i].key] != want[i].value {
+			t.Errorf("result %d = %v, want %s %v", i, result, want[i].key, want[i].value)
+		}
+	}
+
+	tooMany := make([]string, maxBatchResolve+1)
+	for i := range tooMany {
+		tooMany[i] = "www.example"
+	}
+	if code, _ := postJSON(t, "/batch", map[string]interface{}{"domains": tooMany}); code != http.StatusBadRequest {
+		t.Errorf("oversized batch: status %d, want 400", code)
+	}
+}
+
+func TestResolveQueryHonorsPause(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	upstream := startDoHStub(t, cert, stubRecords{
+		"router.example": {"192.168.1.1"},
+		"bad.example":    {"203.0.113.9"},
+	})
+	installTestResolver(t, "DoH", roots, DNSServer{Name: "stub", Address: "127.0.0.1", Port: 443, URL: upstream, Protocols: []string{"DoH"}, Healthy: true})
+	stub := startBlocklistStub(t)
+	stub.blockedIPs["203.0.113.9"] = true
+
+	previousMode := rebindingMode
+	rebindingMode = "refuse"
+	t.Cleanup(func() { rebindingMode = previousMode })
+
+	tests := []struct {
+		domain string
+		paused bool
+		source string
+		policy string
+	}{
+		{"router.example", false, "blocked", "rebinding"}, // Rebinding protection refuses
+		{"router.example", true, "blocked", "rebinding"},  // Pauses never lift rebinding protection
+		{"bad.example", false, "blocked", "ip_blocklist"}, // Answer address in a blocked range
+		{"bad.example", true, "cache", ""},                // Temporarily allowed: blocklist filters skipped
+	}
+	for _, test := range tests {
+		stub.mu.Lock()
+		stub.paused[test.domain] = test.paused
+		stub.mu.Unlock()
+
+		resolution, err := resolveQuery(context.Background(), mustQuery(t, test.domain, dnsmessage.TypeA))
+		if err != nil {
+			t.Fatalf("resolveQuery(%s): %v", test.domain, err)
+		}
+		if resolution.Source != test.source || resolution.Decision.Policy != test.policy {
+			t.Errorf("%s paused=%v: source %s policy %q, want %s policy %q",
+				test.domain, test.paused, resolution.Source, resolution.Decision.Policy, test.source, test.policy)
+		}
+		if test.paused && test.source != "blocked" && len(resolution.Response.Answers) != 1 {
+			t.Errorf("%s paused: %d answers, want the upstream answer", test.domain, len(resolution.Response.Answers))
+		}
+	}
+
+	// The IP filter sends the queried name so temporary allows apply server-side too
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.ipDomains) == 0 || stub.ipDomains[0] != "bad.example" {
+		t.Errorf("check-ip domains = %v, want bad.example", stub.ipDomains)
+	}
+}
+
+func TestResolveQueryRebindingProtection(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	upstream := startDoHStub(t, cert, stubRecords{
+		"mixed.example":    {"93.184.215.14", "10.0.0.5"},
+		"evil.example":     {"192.168.1.1", "127.0.0.1"},
+		"nas.corp.example": {"10.1.2.3"},
+		"printer.lan":      {"192.168.1.20"},
+	})
+	installTestResolver(t, "DoH", roots, DNSServer{Name: "stub", Address: "127.0.0.1", Port: 443, URL: upstream, Protocols: []string{"DoH"}, Healthy: true})
+	startBlocklistStub(t)
+
+	previousMode, previousAllowlist := rebindingMode, rebindingAllowlist
+	rebindingMode = "strip"
+	rebindingAllowlist = append(append([]string{}, defaultRebindingAllowlist...), "corp.example")
+	t.Cleanup(func() { rebindingMode, rebindingAllowlist = previousMode, previousAllowlist })
+
+	tests := []struct {
+		domain  string
+		source  string
+		policy  string
+		answers []string
+	}{
+		{"mixed.example", "upstream", "rebinding", []string{"93.184.215.14"}}, // Private record stripped
+		{"evil.example", "blocked", "rebinding", nil},                         // Nothing public left
+		{"nas.corp.example", "upstream", "", []string{"10.1.2.3"}},            // Configured allowlist
+		{"printer.lan", "upstream", "", []string{"192.168.1.20"}},             // Default allowlist
+		{"mixed.example", "cache", "rebinding", []string{"93.184.215.14"}},    // Raw answer cached, policy reapplied
+	}
+	for _, test := range tests {
+		resolution, err := resolveQuery(context.Background(), mustQuery(t, test.domain, dnsmessage.TypeA))
+		if err != nil {
+			t.Fatalf("resolveQuery(%s): %v", test.domain, err)
+		}
+		if resolution.Source != test.source || resolution.Decision.Policy != test.policy {
+			t.Errorf("%s: source %s policy %q, want %s policy %q",
+				test.domain, resolution.Source, resolution.Decision.Policy, test.source, test.policy)
+		}
+		if test.source != "blocked" && fmt.Sprint(answerAddrs(resolution.Response)) != fmt.Sprint(test.answers) {
+			t.Errorf("%s: answers %v, want %v", test.domain, answerAddrs(resolution.Response), test.answers)
+		}
+	}
+}
+
+func TestResolverNotReady(t *testing.T) {
+	mutex.Lock()
+	previous := dnsResolver
+	dnsResolver = nil
+	mutex.Unlock()
+	t.Cleanup(func() {
+		mutex.Lock()
+		dnsResolver = previous
+		mutex.Unlock()
+	})
+	startBlocklistStub(t)
+
+	if _, err := resolveQuery(context.Background(), mustQuery(t, "www.example", dnsmessage.TypeA)); err != errResolverNotReady {
+		t.Errorf("resolveQuery error = %v, want %v", err, errResolverNotReady)
+	}
+	if err := refreshCached(mustQuery(t, "www.example", dnsmessage.TypeA).Questions[0]); err != errResolverNotReady {
+		t.Errorf("refreshCached error = %v, want %v", err, errResolverNotReady)
+	}
+	if code, response := postJSON(t, "/resolve", map[string]string{"domain": "www.example"}); code != http.StatusServiceUnavailable {
+		t.Errorf("/resolve: status %d, response %v, want 503", code, response)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/cache/stats", handleCacheStats)
+	router.DELETE("/cache", handleCacheClear)
+	router.GET("/cache/health", handleCacheHealth)
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/cache/stats"},
+		{http.MethodDelete, "/cache"},
+		{http.MethodGet, "/cache/health"},
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(route.method, route.path, nil))
+		if recorder.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: status %d, want 503", route.method, route.path, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// SAFE SEARCH REWRITES
+// Answers search engine names with a CNAME to the safe-search target the
+// blocklist-service returns, followed by the target's own records
+// ============================================================================
+
+// safeSearchTTL keeps rewritten answers short-lived so mode changes apply quickly
+const safeSearchTTL = 300
+
+// safeSearchMode controls whether rewrites from the blocklist-service are applied: "on" or "off"
+var safeSearchMode = safeSearchModeFromEnv()
+
+// safeSearchModeFromEnv reads SAFE_SEARCH; like the blocklist-service, only "on" enables rewrites
+func safeSearchModeFromEnv() string {
+	if os.Getenv("SAFE_SEARCH") == "on" {
+		return "on"
+	}
+	return "off"
+}
+
+// buildSafeSearchAnswer synthesizes the rewritten response for a query
+// targetResp is the upstream answer for the target name; its records are appended
+// after the CNAME so clients see query -> CNAME target -> addresses
+func buildSafeSearchAnswer(query dnsmessage.Message, target string, targetResp *dnsmessage.Message) (*dnsmessage.Message, error) {
+	question := query.Questions[0]
+
+	targetName, err := dnsmessage.NewName(strings.TrimSuffix(target, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.Header.ID,
+			Response:           true,
+			RecursionDesired:   query.Header.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              dnsmessage.RCodeSuccess,
+		},
+		Questions: query.Questions,
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{
+				Name:  question.Name,
+				Type:  dnsmessage.TypeCNAME,
+				Class: dnsmessage.ClassINET,
+				TTL:   safeSearchTTL,
+			},
+			Body: &dnsmessage.CNAMEResource{CNAME: targetName},
+		}},
+	}
+
+	if targetResp == nil {
+		return resp, nil
+	}
+	for _, answer := range targetResp.Answers {
+		if answer.Header.Type != question.Type && answer.Header.Type != dnsmessage.TypeCNAME {
+			continue
+		}
+		if answer.Header.TTL > safeSearchTTL {
+			answer.Header.TTL = safeSearchTTL
+		}
+		resp.Answers = append(resp.Answers, answer)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestResolveQuerySafeSearch(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	upstream := startDoHStub(t, cert, stubRecords{
+		"www.bing.com":    {"198.51.100.1"},
+		"strict.bing.com": {"198.51.100.2"},
+	})
+	installTestResolver(t, "DoH", roots, DNSServer{Name: "stub", Address: "127.0.0.1", Port: 443, URL: upstream, Protocols: []string{"DoH"}, Healthy: true})
+	stub := startBlocklistStub(t)
+	stub.rewrites["www.bing.com"] = "strict.bing.com"
+
+	previousMode := safeSearchMode
+	t.Cleanup(func() { safeSearchMode = previousMode })
+
+	// Enabled: CNAME to the enforcing name followed by its addresses
+	safeSearchMode = "on"
+	resolution, err := resolveQuery(context.Background(), mustQuery(t, "www.bing.com", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers := resolution.Response.Answers
+	if resolution.Source != "safe_search" || len(answers) != 2 {
+		t.Fatalf("source %s with %d answers, want safe_search with CNAME and A", resolution.Source, len(answers))
+	}
+	if cname, ok := answers[0].Body.(*dnsmessage.CNAMEResource); !ok || cname.CNAME.String() != "strict.bing.com." {
+		t.Errorf("first answer = %v, want CNAME strict.bing.com.", answers[0].Body)
+	}
+	if addr, _ := answerAddr(answers[1]); addr.String() != "198.51.100.2" || answers[1].Header.Name.String() != "strict.bing.com." {
+		t.Errorf("second answer = %s %s, want strict.bing.com. 198.51.100.2", answers[1].Header.Name, addr)
+	}
+
+	// Disabled: the rewrite is ignored and the real answer returned
+	safeSearchMode = "off"
+	resolution, err = resolveQuery(context.Background(), mustQuery(t, "www.bing.com", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, _ := answerAddr(resolution.Response.Answers[0]); resolution.Source == "safe_search" || addr.String() != "198.51.100.1" {
+		t.Errorf("source %s answer %s, want the upstream answer 198.51.100.1", resolution.Source, addr)
+	}
+}
+
+func TestSafeSearchModeFromEnv(t *testing.T) {
+	// Must match the blocklist-service, which only rewrites with SAFE_SEARCH=on
+	for value, want := range map[string]string{"": "off", "off": "off", "on": "on", "true": "off"} {
+		t.Setenv("SAFE_SEARCH", value)
+		if mode := safeSearchModeFromEnv(); mode != want {
+			t.Errorf("SAFE_SEARCH=%q gives mode %q, want %q", value, mode, want)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// TEST STUBS
+// In-process upstreams and blocklist-service for resolver tests
+// ============================================================================
+
+// blocklistStub is an in-process blocklist-service
+type blocklistStub struct {
+	mu         sync.Mutex
+	blocked    map[string]bool   // Blocked names (batch checks)
+	paused     map[string]bool   // Names covered by a pause or temporary allow
+	rewrites   map[string]string // Safe-search targets by name
+	blockedIPs map[string]bool   // Blocked answer addresses (check-ip)
+	batches    []int             // Sizes of match_parents batches (CNAME checks)
+	ipDomains  []string          // Queried names sent with check-ip
+	ipBatches  []int             // Sizes of check-ip batches
+}
+
+// startBlocklistStub serves the batch and check-ip endpoints and points the client at them
+func startBlocklistStub(t *testing.T) *blocklistStub {
+	t.Helper()
+
+	stub := &blocklistStub{blocked: map[string]bool{}, paused: map[string]bool{}, rewrites: map[string]string{}, blockedIPs: map[string]bool{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/blocklist/batch", func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Domains      []string `json:"domains"`
+			MatchParents bool     `json:"match_parents"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Domains) > blocklistBatchLimit {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		if request.MatchParents {
+			stub.batches = append(stub.batches, len(request.Domains))
+		}
+		results := make([]DomainCheckResult, len(request.Domains))
+		for i, domain := range request.Domains {
+			results[i] = DomainCheckResult{Blocked: stub.blocked[domain], Category: "tracking", Paused: stub.paused[domain], Rewrite: stub.rewrites[domain]}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	})
+	mux.HandleFunc("/api/v1/blocklist/check-ip", func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			IPs    []string `json:"ips"`
+			Domain string   `json:"domain"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.IPs) > blocklistBatchLimit {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.ipDomains = append(stub.ipDomains, request.Domain)
+		stub.ipBatches = append(stub.ipBatches, len(request.IPs))
+		results := make([]IPCheckResult, len(request.IPs))
+		for i, ip := range request.IPs {
+			results[i] = IPCheckResult{Blocked: stub.blockedIPs[ip], Category: "malware"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	previous := blocklistServiceURL
+	blocklistServiceURL = server.URL
+	t.Cleanup(func() { blocklistServiceURL = previous })
+	return stub
+}
+
+// newTestCertificate issues a self-signed certificate for 127.0.0.1 and returns
+// it with a root pool that trusts it, as UPSTREAM_CA_FILE would
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stub resolver"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:              []string{"stub.test"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// stubRecords answers A and AAAA queries from a name -> addresses table
+// An entry "cname:<target>" answers with a CNAME followed by the target's records
+type stubRecords map[string][]string
+
+// answer builds the response to a packed query; unknown names get NXDOMAIN
+func (records stubRecords) answer(t *testing.T, packed []byte) []byte {
+	var query dnsmessage.Message
+	if err := query.Unpack(packed); err != nil {
+		t.Errorf("stub upstream got malformed query: %v", err)
+		return nil
+	}
+	question := query.Questions[0]
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.Header.ID, Response: true, RecursionAvailable: true},
+		Questions: query.Questions,
+	}
+
+	name := strings.TrimSuffix(question.Name.String(), ".")
+	owner := question.Name
+	values, ok := records[name]
+	if !ok {
+		resp.Header.RCode = dnsmessage.RCodeNameError
+	}
+	for len(values) > 0 && strings.HasPrefix(values[0], "cname:") {
+		target := strings.TrimPrefix(values[0], "cname:")
+		targetName := dnsmessage.MustNewName(target + ".")
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: owner, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 300},
+			Body:   &dnsmessage.CNAMEResource{CNAME: targetName},
+		})
+		owner, values = targetName, records[target]
+	}
+	for _, value := range values {
+		addr := netip.MustParseAddr(value)
+		header := dnsmessage.ResourceHeader{Name: owner, Class: dnsmessage.ClassINET, TTL: 300}
+		switch {
+		case addr.Is4() && question.Type == dnsmessage.TypeA:
+			header.Type = dnsmessage.TypeA
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: addr.As4()}})
+		case addr.Is6() && question.Type == dnsmessage.TypeAAAA:
+			header.Type = dnsmessage.TypeAAAA
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}})
+		}
+	}
+
+	raw, err := resp.Pack()
+	if err != nil {
+		t.Errorf("stub upstream could not pack answer: %v", err)
+	}
+	return raw
+}
+
+// startDoHStub serves RFC 8484 POST and GET requests over TLS and returns the endpoint URL
+func startDoHStub(t *testing.T, cert tls.Certificate, records stubRecords) string {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var packed []byte
+		var err error
+		if r.Method == http.MethodGet {
+			packed, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		} else {
+			packed, err = io.ReadAll(r.Body)
+		}
+		if err != nil || r.URL.Path != defaultDoHPath {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(records.answer(t, packed))
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.URL + defaultDoHPath
+}
+
+// startDoTStub serves RFC 7858 length-prefixed queries over TLS and returns the port
+func startDoTStub(t *testing.T, cert tls.Certificate, records stubRecords) int {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var connsMu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		listener.Close()
+		connsMu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		connsMu.Unlock()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connsMu.Lock()
+			conns = append(conns, conn)
+			connsMu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				for {
+					var length [2]byte
+					if _, err := io.ReadFull(conn, length[:]); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(length[:]))
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					answer := records.answer(t, query)
+					frame := binary.BigEndian.AppendUint16(nil, uint16(len(answer)))
+					if _, err := conn.Write(append(frame, answer...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// startDoQStub serves RFC 9250 queries, one per stream, accepting 0-RTT, and returns the port
+// Every query must carry message ID 0
+func startDoQStub(t *testing.T, cert tls.Certificate, records stubRecords) int {
+	t.Helper()
+
+	listener, err := quic.ListenAddrEarly("127.0.0.1:0",
+		&tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{doqALPN}},
+		&quic.Config{Allow0RTT: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.UDPAddr).Port
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		dropDoQConnectionTo(DNSServer{Address: "127.0.0.1", Port: port})
+		cancel()
+		listener.Close()
+		wg.Wait()
+	})
+
+	serveStream := func(stream *quic.Stream) {
+		defer stream.Close()
+		var length [2]byte
+		if _, err := io.ReadFull(stream, length[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(stream, query); err != nil {
+			return
+		}
+		if binary.BigEndian.Uint16(query) != 0 {
+			t.Errorf("DoQ query sent with message ID %d, want 0", binary.BigEndian.Uint16(query))
+		}
+		answer := records.answer(t, query)
+		stream.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(answer))), answer...))
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept(ctx)
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					stream, err := conn.AcceptStream(ctx)
+					if err != nil {
+						return
+					}
+					serveStream(stream)
+				}
+			}()
+		}
+	}()
+
+	return port
+}
+
+// dropDoQConnectionTo closes the pooled connection to a server, if any
+func dropDoQConnectionTo(server DNSServer) {
+	doqConnectionsMux.Lock()
+	conn, ok := doqConnections[serverAddress(server)]
+	doqConnectionsMux.Unlock()
+	if ok {
+		dropDoQConnection(server, conn, doqNoError)
+	}
+}
+
+// installTestResolver makes servers the only upstreams, trusting roots, for the duration of a test
+func installTestResolver(t *testing.T, protocol string, roots *x509.CertPool, servers ...DNSServer) {
+	t.Helper()
+
+	previousRoots, previousClient := upstreamRootCAs, dohHTTPClient
+	upstreamRootCAs = roots
+	dohHTTPClient = &http.Client{
+		Timeout: defaultTimeoutSeconds * time.Second,
+		Transport: &http.Transport{
+			ForceAttemptHTTP2: true,
+			TLSClientConfig:   &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	mutex.Lock()
+	previousResolver := dnsResolver
+	dnsResolver = &DNSResolver{
+		cache:          NewAnonymousCache(1000),
+		servers:        servers,
+		activeProtocol: protocol,
+	}
+	mutex.Unlock()
+
+	t.Cleanup(func() {
+		dohHTTPClient.CloseIdleConnections()
+		mutex.Lock()
+		dnsResolver = previousResolver
+		mutex.Unlock()
+		upstreamRootCAs, dohHTTPClient = previousRoots, previousClient
+	})
+}
+
+// mustQuery builds a query or fails the test
+func mustQuery(t *testing.T, domain string, qtype dnsmessage.Type) dnsmessage.Message {
+	t.Helper()
+
+	query, err := newQuery(domain, qtype)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
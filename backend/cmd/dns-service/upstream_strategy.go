@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+// UPSTREAM SELECTION STRATEGIES
+// Decide which healthy servers a query goes to and in what order
+// (UPSTREAM_STRATEGY: failover, fastest, round_robin or race)
+// Privacy: Only race sends a query to more than one server at once
+// ============================================================================
+
+// upstreamExchange sends the query to one server and returns its validated answer
+type upstreamExchange func(ctx context.Context, server upstreamServer) (*dnsmessage.Message, error)
+
+// upstreamStrategy chooses servers for a query and runs the exchanges
+type upstreamStrategy interface {
+	Name() string
+	Exchange(ctx context.Context, servers []upstreamServer, try upstreamExchange) (*dnsmessage.Message, upstreamServer, error)
+}
+
+// upstreamStrategies builds each strategy by its configuration name
+var upstreamStrategies = map[string]func() upstreamStrategy{
+	"failover":    func() upstreamStrategy { return failoverStrategy{} },
+	"fastest":     func() upstreamStrategy { return fastestStrategy{} },
+	"round_robin": func() upstreamStrategy { return newRoundRobinStrategy() },
+	"race":        func() upstreamStrategy { return raceStrategy{} },
+}
+
+// upstreamSelection is the configured strategy
+var upstreamSelection = newUpstreamStrategy(envOrDefault("UPSTREAM_STRATEGY", "failover"))
+
+// newUpstreamStrategy returns the named strategy, falling back to failover
+func newUpstreamStrategy(name string) upstreamStrategy {
+	build, ok := upstreamStrategies[name]
+	if !ok {
+		log.Printf("⚠️ Unknown UPSTREAM_STRATEGY %q, using failover", name)
+		build = upstreamStrategies["failover"]
+	}
+	return build()
+}
+
+// exchangeInOrder tries servers one after another, up to maxRetryAttempts
+func exchangeInOrder(ctx context.Context, servers []upstreamServer, try upstreamExchange) (*dnsmessage.Message, upstreamServer, error) {
+	var lastErr error
+	for attempt, server := range servers {
+		if attempt >= maxRetryAttempts || ctx.Err() != nil {
+			break
+		}
+		resp, err := try(ctx, server)
+		if err == nil {
+			return resp, server, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", server.Name, err)
+	}
+
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	return nil, upstreamServer{}, lastErr
+}
+
+// failoverStrategy keeps the configured server order; later servers are only
+// used when earlier ones fail
+type failoverStrategy struct{}
+
+func (failoverStrategy) Name() string { return "failover" }
+
+func (failoverStrategy) Exchange(ctx context.Context, servers []upstreamServer, try upstreamExchange) (*dnsmessage.Message, upstreamServer, error) {
+	return exchangeInOrder(ctx, servers, try)
+}
+
+// fastestStrategy prefers the lowest latency moving average (see recordServerResult)
+// Servers without a measurement go first so every server gets measured
+type fastestStrategy struct{}
+
+func (fastestStrategy) Name() string { return "fastest" }
+
+func (fastestStrategy) Exchange(ctx context.Context, servers []upstreamServer, try upstreamExchange) (*dnsmessage.Message, upstreamServer, error) {
+	ordered := append([]upstreamServer(nil), servers...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Latency == 0 || ordered[j].Latency == 0 {
+			return ordered[i].Latency == 0 && ordered[j].Latency != 0
+		}
+		return ordered[i].Latency < ordered[j].Latency
+	})
+	return exchangeInOrder(ctx, ordered, try)
+}
+
+// roundRobinStrategy spreads queries by server weight using smooth weighted
+// round-robin, so a 3:1 split interleaves instead of sending bursts
+type roundRobinStrategy struct {
+	mu      sync.Mutex
+	current map[string]int // serverAddress -> current weight
+}
+
+func newRoundRobinStrategy() *roundRobinStrategy {
+	return &roundRobinStrategy{current: map[string]int{}}
+}
+
+func (*roundRobinStrategy) Name() string { return "round_robin" }
+
+func (s *roundRobinStrategy) Exchange(ctx context.Context, servers []upstreamServer, try upstreamExchange) (*dnsmessage.Message, upstreamServer, error) {
+	return exchangeInOrder(ctx, s.order(servers), try)
+}
+
+// order picks the next server and lists the rest by current weight for failover
+func (s *roundRobinStrategy) order(servers []upstreamServer) []upstreamServer {
+	if len(servers) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total, best := 0, 0
+	for i, server := range servers {
+		weight := serverWeight(server.DNSServer)
+		s.current[serverAddress(server.DNSServer)] += weight
+		total += weight
+		if s.current[serverAddress(server.DNSServer)] > s.current[serverAddress(servers[best].DNSServer)] {
+			best = i
+		}
+	}
+	s.current[serverAddress(servers[best].DNSServer)] -= total
+
+	rest := make([]upstreamServer, 0, len(servers)-1)
+	rest = append(rest, servers[:best]...)
+	rest = append(rest, servers[best+1:]...)
+	sort.SliceStable(rest, func(i, j int) bool {
+		return s.current[serverAddress(rest[i].DNSServer)] > s.current[serverAddress(rest[j].DNSServer)]
+	})
+	return append([]upstreamServer{servers[best]}, rest...)
+}
+
+// serverWeight returns the round-robin weight of a server (unset counts as 1)
+func serverWeight(server DNSServer) int {
+	return max(1, server.Weight)
+}
+
+// raceStrategy queries up to maxRetryAttempts servers in parallel and returns
+// the first valid answer; the remaining exchanges are cancelled
+type raceStrategy struct{}
+
+func (raceStrategy) Name() string { return "race" }
+
+func (raceStrategy) Exchange(ctx context.Context, servers []upstreamServer, try upstreamExchange) (*dnsmessage.Message, upstreamServer, error) {
+	if len(servers) > maxRetryAttempts {
+		servers = servers[:maxRetryAttempts]
+	}
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		resp   *dnsmessage.Message
+		server upstreamServer
+		err    error
+	}
+	results := make(chan result, len(servers))
+	for _, server := range servers {
+		go func(server upstreamServer) {
+			resp, err := try(raceCtx, server)
+			results <- result{resp: resp, server: server, err: err}
+		}(server)
+	}
+
+	var errs []error
+	for range servers {
+		r := <-results
+		if r.err == nil {
+			return r.resp, r.server, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", r.server.Name, r.err))
+	}
+	return nil, upstreamServer{}, errors.Join(errs...)
+}
+
+// applyUpstreamWeights sets server weights from UPSTREAM_WEIGHTS
+// Format: "Cloudflare=3,Quad9=1", matching server names or addresses
+func applyUpstreamWeights(servers []DNSServer) {
+	for _, pair := range strings.Split(os.Getenv("UPSTREAM_WEIGHTS"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 1 {
+			log.Printf("⚠️ UPSTREAM_WEIGHTS entry skipped: %q", pair)
+			continue
+		}
+		for i := range servers {
+			if strings.EqualFold(servers[i].Name, strings.TrimSpace(name)) || servers[i].Address == strings.TrimSpace(name) {
+				servers[i].Weight = weight
+			}
+		}
+	}
+}
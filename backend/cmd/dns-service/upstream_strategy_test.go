@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeUpstreams answers exchanges by server name: "ok" answers at once, "fail"
+// errors at once and "hang" waits until the exchange is cancelled
+type fakeUpstreams struct {
+	mu        sync.Mutex
+	behaviour map[string]string
+	tried     []string
+	cancelled []string
+}
+
+func (fake *fakeUpstreams) try(ctx context.Context, server upstreamServer) (*dnsmessage.Message, error) {
+	fake.mu.Lock()
+	fake.tried = append(fake.tried, server.Name)
+	behaviour := fake.behaviour[server.Name]
+	fake.mu.Unlock()
+
+	switch behaviour {
+	case "ok":
+		return &dnsmessage.Message{}, nil
+	case "hang":
+		<-ctx.Done()
+		fake.mu.Lock()
+		fake.cancelled = append(fake.cancelled, server.Name)
+		fake.mu.Unlock()
+		return nil, ctx.Err()
+	default:
+		return nil, errors.New("refused")
+	}
+}
+
+// fakeServers builds upstream servers named after their index letters
+func fakeServers(latencies ...time.Duration) []upstreamServer {
+	servers := make([]upstreamServer, len(latencies))
+	for i, latency := range latencies {
+		name := string(rune('A' + i))
+		servers[i] = upstreamServer{DNSServer: DNSServer{Name: name, Address: name, Latency: latency, Healthy: true}, protocol: "DoT"}
+	}
+	return servers
+}
+
+func TestFailoverStrategy(t *testing.T) {
+	fake := &fakeUpstreams{behaviour: map[string]string{"A": "fail", "B": "ok", "C": "ok"}}
+	_, server, err := failoverStrategy{}.Exchange(context.Background(), fakeServers(0, 0, 0), fake.try)
+	if err != nil || server.Name != "B" {
+		t.Errorf("answered by %q, %v; want B", server.Name, err)
+	}
+	if strings.Join(fake.tried, "") != "AB" {
+		t.Errorf("tried %v, want A then B", fake.tried)
+	}
+
+	// Attempts stop at maxRetryAttempts
+	fake = &fakeUpstreams{behaviour: map[string]string{}}
+	if _, _, err := (failoverStrategy{}).Exchange(context.Background(), fakeServers(0, 0, 0, 0), fake.try); err == nil {
+		t.Error("exchange succeeded with every server failing")
+	}
+	if len(fake.tried) != maxRetryAttempts {
+		t.Errorf("tried %v, want %d servers", fake.tried, maxRetryAttempts)
+	}
+}
+
+func TestFastestStrategy(t *testing.T) {
+	// C is unmeasured and goes first; when it fails the lowest average follows
+	fake := &fakeUpstreams{behaviour: map[string]string{"A": "ok", "B": "ok", "C": "fail"}}
+	servers := fakeServers(30*time.Millisecond, 10*time.Millisecond, 0)
+	_, server, err := fastestStrategy{}.Exchange(context.Background(), servers, fake.try)
+	if err != nil || server.Name != "B" {
+		t.Errorf("answered by %q, %v; want B", server.Name, err)
+	}
+	if strings.Join(fake.tried, "") != "CB" {
+		t.Errorf("tried %v, want C then B", fake.tried)
+	}
+}
+
+func TestRoundRobinStrategy(t *testing.T) {
+	servers := fakeServers(0, 0)
+	servers[0].Weight = 3
+	strategy := newRoundRobinStrategy()
+	fake := &fakeUpstreams{behaviour: map[string]string{"A": "ok", "B": "ok"}}
+
+	var picks strings.Builder
+	for i := 0; i < 8; i++ {
+		_, server, err := strategy.Exchange(context.Background(), servers, fake.try)
+		if err != nil {
+			t.Fatal(err)
+		}
+		picks.WriteString(server.Name)
+	}
+	if picks.String() != "AABAAABA" {
+		t.Errorf("picks = %s, want AABAAABA (3:1, interleaved)", picks.String())
+	}
+
+	// A failed pick falls over to the other server
+	fake.behaviour["A"] = "fail"
+	if _, server, err := strategy.Exchange(context.Background(), servers, fake.try); err != nil || server.Name != "B" {
+		t.Errorf("answered by %q, %v; want B", server.Name, err)
+	}
+}
+
+func TestRaceStrategy(t *testing.T) {
+	fake := &fakeUpstreams{behaviour: map[string]string{"A": "hang", "B": "ok", "C": "fail", "D": "ok"}}
+	_, server, err := raceStrategy{}.Exchange(context.Background(), fakeServers(0, 0, 0, 0), fake.try)
+	if err != nil || server.Name != "B" {
+		t.Errorf("answered by %q, %v; want B", server.Name, err)
+	}
+
+	// The loser is cancelled once the winner answers; D is beyond maxRetryAttempts
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		fake.mu.Lock()
+		cancelled := len(fake.cancelled)
+		fake.mu.Unlock()
+		if cancelled == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.cancelled) != 1 || fake.cancelled[0] != "A" {
+		t.Errorf("cancelled %v, want A", fake.cancelled)
+	}
+	for _, name := range fake.tried {
+		if name == "D" {
+			t.Error("raced more than maxRetryAttempts servers")
+		}
+	}
+
+	failing := &fakeUpstreams{behaviour: map[string]string{}}
+	if _, _, err := (raceStrategy{}).Exchange(context.Background(), fakeServers(0, 0), failing.try); err == nil || !strings.Contains(err.Error(), "A:") || !strings.Contains(err.Error(), "B:") {
+		t.Errorf("error = %v, want both failures", err)
+	}
+}
+
+func TestNewUpstreamStrategy(t *testing.T) {
+	for _, name := range []string{"failover", "fastest", "round_robin", "race"} {
+		if got := newUpstreamStrategy(name).Name(); got != name {
+			t.Errorf("newUpstreamStrategy(%q) = %s", name, got)
+		}
+	}
+	if got := newUpstreamStrategy("quickest").Name(); got != "failover" {
+		t.Errorf("unknown strategy = %s, want failover", got)
+	}
+}
+
+func TestRecordServerResultHealth(t *testing.T) {
+	installTestResolver(t, "DoT", nil,
+		DNSServer{Name: "A", Address: "192.0.2.1", Port: 853, Latency: 5 * time.Millisecond, Protocols: []string{"DoT"}, Healthy: true},
+		DNSServer{Name: "B", Address: "192.0.2.2", Port: 853, Latency: 20 * time.Millisecond, Protocols: []string{"DoT"}, Healthy: true})
+	failed := errors.New("timeout")
+	serverA := DNSServer{Address: "192.0.2.1", Port: 853}
+
+	order := func() string {
+		fake := &fakeUpstreams{behaviour: map[string]string{}}
+		fastestStrategy{}.Exchange(context.Background(), upstreamServers(), fake.try)
+		return strings.Join(fake.tried, "")
+	}
+	if got := order(); got != "AB" {
+		t.Fatalf("order %s, want A first", got)
+	}
+
+	// One failure moves A's average past B's
+	recordServerResult(serverA, time.Millisecond, failed)
+	if got := order(); got != "BA" {
+		t.Errorf("after a failure: order %s, want B first", got)
+	}
+
+	// maxConsecutiveFailures take A out of rotation
+	for i := 1; i < maxConsecutiveFailures; i++ {
+		recordServerResult(serverA, time.Millisecond, failed)
+	}
+	if got := order(); got != "B" {
+		t.Errorf("after %d failures: order %s, want B only", maxConsecutiveFailures, got)
+	}
+
+	// After the retry interval A gets a trial query; a success restores it
+	mutex.Lock()
+	dnsResolver.servers[0].LastCheck = time.Now().Add(-unhealthyRetryInterval)
+	mutex.Unlock()
+	if got := order(); got != "BA" {
+		t.Errorf("after the retry interval: order %s, want B then A", got)
+	}
+	recordServerResult(serverA, time.Millisecond, nil)
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if a := dnsResolver.servers[0]; !a.Healthy || a.FailureStreak != 0 || a.ErrorCount != maxConsecutiveFailures {
+		t.Errorf("after a success: healthy %v, streak %d, errors %d", a.Healthy, a.FailureStreak, a.ErrorCount)
+	}
+}
+
+func TestExchangeUpstreamFastestSkipsDeadServer(t *testing.T) {
+	cert, roots := newTestCertificate(t)
+	port := startDoTStub(t, cert, testZone)
+
+	// A port with nothing listening refuses connections at once
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	installTestResolver(t, "DoT", roots,
+		DNSServer{Name: "dead", Address: "127.0.0.1", Port: deadPort, Latency: time.Millisecond, Protocols: []string{"DoT"}, Healthy: true},
+		DNSServer{Name: "live", Address: "127.0.0.1", Port: port, Latency: 50 * time.Millisecond, Protocols: []string{"DoT"}, Healthy: true})
+	previous := upstreamSelection
+	upstreamSelection = fastestStrategy{}
+	t.Cleanup(func() { upstreamSelection = previous })
+
+	for i := 0; i < 3; i++ {
+		_, server, err := exchangeUpstream(context.Background(), mustQuery(t, "www.example", dnsmessage.TypeA))
+		if err != nil || server.Name != "live" {
+			t.Fatalf("query %d: answered by %q, %v; want live", i, server.Name, err)
+		}
+	}
+
+	// Only the first query tried the dead server; its penalized average sends later queries elsewhere
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if dead := dnsResolver.servers[0]; dead.ErrorCount != 1 || dead.Latency < dnsResolver.servers[1].Latency {
+		t.Errorf("dead server: %d errors, latency %v; want 1 error and a penalized latency", dead.ErrorCount, dead.Latency)
+	}
+}
@@ -4,7 +4,14 @@ go 1.23.0
 
 toolchain go1.24.5
 
-require github.com/gin-gonic/gin v1.9.1
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/gin-gonic/gin v1.9.1
+	github.com/klauspost/compress v1.18.0
+	github.com/quic-go/quic-go v0.54.0
+	golang.org/x/crypto v0.35.0
+	golang.org/x/net v0.28.0
+)
 
 require (
 	github.com/bytedance/sonic v1.9.1 // indirect
@@ -24,11 +31,13 @@ require (
 	github.com/pelletier/go-toml/v2 v2.0.8 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.11 // indirect
+	go.uber.org/mock v0.5.0 // indirect
 	golang.org/x/arch v0.3.0 // indirect
-	golang.org/x/crypto v0.35.0 // indirect
-	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
-	google.golang.org/protobuf v1.30.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )